@@ -0,0 +1,434 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Append-only log format:
+//
+//	header: magic (5 bytes, "MCAOF") | version (uint16)
+//	set:    type (1 byte, MutationSet) | key and data (see writeItem)
+//	delete: type (1 byte, MutationDelete) | key length (uint32) | key
+//	flush:  type (1 byte, MutationFlush) | flush at (int64, Unix milliseconds)
+//
+// Integers are big-endian
+const appendOnlyLogMagic = "MCAOF"
+const appendOnlyLogVersion uint16 = 1
+
+// The log is rewritten once it's at least this large and has doubled in size since it was last rewritten
+const minRewriteSize = 64 * 1024 * 1024
+
+var InvalidFsyncPolicyError = errors.New("invalid fsync policy")
+
+// FsyncPolicy how often the append-only log is flushed to disk. Mutations are always written to the file right away,
+// so they're only lost if the machine crashes before they're flushed
+type FsyncPolicy string
+
+// FsyncAlways flushes every mutation before the command returns. Safest, but slowest
+const FsyncAlways FsyncPolicy = "always"
+
+// FsyncEverySecond flushes the mutations once per second, so at most a second of mutations is lost
+const FsyncEverySecond FsyncPolicy = "everysec"
+
+// FsyncNo leaves flushing to the operating system
+const FsyncNo FsyncPolicy = "no"
+
+// ParseFsyncPolicy returns the fsync policy matching name (case-insensitive). Returns InvalidFsyncPolicyError if
+// there's no such policy
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	policy := FsyncPolicy(strings.ToLower(name))
+
+	switch policy {
+	case FsyncAlways, FsyncEverySecond, FsyncNo:
+		return policy, nil
+	}
+
+	return "", InvalidFsyncPolicyError
+}
+
+// AppendOnlyLog records every mutation of a cache to a file, so its contents can be restored after a restart. The file
+// is rewritten from the contents of the cache in the background once it grows too large (see Rewrite)
+type AppendOnlyLog struct {
+	cache  *Cache
+	path   string
+	policy FsyncPolicy
+	file   *os.File
+	// Size of the file
+	size int64
+	// Size of the file after it was opened or last rewritten
+	rewrittenSize int64
+	// Mutations made while the file is being rewritten. Nil if it isn't being rewritten
+	rewriteBuffer *bytes.Buffer
+	// Whether there are mutations that haven't been flushed to disk
+	dirty  bool
+	closed bool
+	// Guards file, the sizes, rewriteBuffer, dirty, and closed
+	mutex *sync.Mutex
+	// Prevents rewrites from running at the same time
+	rewriteMutex *sync.Mutex
+	// Closed to stop the background task
+	stopBackgroundTask chan struct{}
+	// Closed by the background task once it stops
+	backgroundTaskDone chan struct{}
+}
+
+// OpenAppendOnlyLog restores the contents of the cache from the log at path and records every later mutation of the
+// cache to it. A new log is created from the contents of the cache if the file doesn't exist. Mutations that were
+// partially written, e.g., because the server crashed, are discarded. Returns InvalidAppendOnlyLogError if the file
+// isn't a log this version can read
+func OpenAppendOnlyLog(cache *Cache, path string, policy FsyncPolicy) (*AppendOnlyLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening append-only log: %v", err)
+	}
+
+	appendOnlyLog := &AppendOnlyLog{
+		cache:              cache,
+		path:               path,
+		policy:             policy,
+		file:               file,
+		mutex:              &sync.Mutex{},
+		rewriteMutex:       &sync.Mutex{},
+		stopBackgroundTask: make(chan struct{}),
+		backgroundTaskDone: make(chan struct{}),
+	}
+
+	created, err := appendOnlyLog.load()
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	cache.AddMutationListener(appendOnlyLog.append)
+	go appendOnlyLog.runBackgroundTask()
+
+	// A new log needs to include the data stored before it was created, e.g., loaded from a snapshot
+	if created && cache.Size() > 0 {
+		if err := appendOnlyLog.Rewrite(); err != nil {
+			appendOnlyLog.Close()
+			return nil, err
+		}
+	}
+
+	return appendOnlyLog, nil
+}
+
+// Rewrite replaces the log with the shortest log that restores the current contents of the cache. Mutations made
+// while the log is rewritten are recorded in both the current and the new log, so no mutations are lost if the
+// rewrite fails
+func (receiver *AppendOnlyLog) Rewrite() error {
+	receiver.rewriteMutex.Lock()
+	defer receiver.rewriteMutex.Unlock()
+
+	receiver.mutex.Lock()
+	if receiver.closed {
+		receiver.mutex.Unlock()
+		return nil
+	}
+	receiver.rewriteBuffer = &bytes.Buffer{}
+	receiver.mutex.Unlock()
+
+	tempPath := receiver.path + ".rewrite"
+	file, err := receiver.writeContents(tempPath)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	rewriteBuffer := receiver.rewriteBuffer
+	receiver.rewriteBuffer = nil
+
+	if err == nil && !receiver.closed {
+		err = receiver.replaceFile(file, tempPath, rewriteBuffer.Bytes())
+	}
+
+	if err != nil || receiver.closed {
+		file.Close()
+		os.Remove(tempPath)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error rewriting append-only log: %v", err)
+	}
+
+	return nil
+}
+
+// Close stops recording mutations and flushes the log to disk
+func (receiver *AppendOnlyLog) Close() error {
+	receiver.mutex.Lock()
+	if receiver.closed {
+		receiver.mutex.Unlock()
+		return nil
+	}
+	receiver.closed = true
+	receiver.mutex.Unlock()
+
+	close(receiver.stopBackgroundTask)
+	<-receiver.backgroundTaskDone
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	syncErr := receiver.file.Sync()
+	closeErr := receiver.file.Close()
+
+	return errors.Join(syncErr, closeErr)
+}
+
+// load replays the log, or writes the header if the file is empty, and leaves the file ready to append mutations.
+// Returns whether the log is new
+func (receiver *AppendOnlyLog) load() (bool, error) {
+	info, err := receiver.file.Stat()
+
+	if err != nil {
+		return false, fmt.Errorf("error opening append-only log: %v", err)
+	}
+
+	if info.Size() == 0 {
+		return true, receiver.writeHeader()
+	}
+
+	count, validSize, err := receiver.replay()
+
+	if err != nil {
+		return false, err
+	}
+
+	if validSize < info.Size() {
+		log.Printf("Discarding %d bytes of partially written mutations at the end of %s\n", info.Size()-validSize, receiver.path)
+
+		if err := receiver.file.Truncate(validSize); err != nil {
+			return false, fmt.Errorf("error truncating append-only log: %v", err)
+		}
+	}
+
+	if _, err := receiver.file.Seek(validSize, io.SeekStart); err != nil {
+		return false, fmt.Errorf("error opening append-only log: %v", err)
+	}
+
+	receiver.size = validSize
+	receiver.rewrittenSize = validSize
+	log.Printf("Replayed %d mutations from %s\n", count, receiver.path)
+
+	return false, nil
+}
+
+func (receiver *AppendOnlyLog) writeHeader() error {
+	var header bytes.Buffer
+	header.WriteString(appendOnlyLogMagic)
+	binary.Write(&header, binary.BigEndian, appendOnlyLogVersion)
+
+	if _, err := receiver.file.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("error creating append-only log: %v", err)
+	}
+
+	receiver.size = int64(header.Len())
+	receiver.rewrittenSize = receiver.size
+
+	return nil
+}
+
+// replay applies the mutations in the file to the cache. Returns the number of mutations applied and the size of the
+// file up to the last mutation that was completely written
+func (receiver *AppendOnlyLog) replay() (int, int64, error) {
+	reader := &countingReader{reader: bufio.NewReader(receiver.file)}
+
+	header := make([]byte, len(appendOnlyLogMagic)+2)
+
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(appendOnlyLogMagic)]) != appendOnlyLogMagic {
+		return 0, 0, &InvalidAppendOnlyLogError{Reason: "missing header"}
+	}
+
+	if version := binary.BigEndian.Uint16(header[len(appendOnlyLogMagic):]); version != appendOnlyLogVersion {
+		return 0, 0, &InvalidAppendOnlyLogError{Reason: fmt.Sprintf("unsupported version %d", version)}
+	}
+
+	count := 0
+
+	for {
+		validSize := reader.count
+		mutation, err := ReadMutation(reader)
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return count, validSize, nil
+		}
+
+		if err != nil {
+			return count, validSize, &InvalidAppendOnlyLogError{Reason: err.Error()}
+		}
+
+		// Items might not fit if the memory limit was lowered since they were stored
+		itemTooLargeError := &ItemTooLargeError{}
+		if err := receiver.cache.Apply(mutation); err != nil && !errors.As(err, &itemTooLargeError) {
+			return count, validSize, err
+		}
+
+		count++
+	}
+}
+
+// writeContents writes a log that restores the current contents of the cache to a new file at path. Returns the file,
+// which is left open so the mutations made in the meantime can be appended
+func (receiver *AppendOnlyLog) writeContents(path string) (*os.File, error) {
+	file, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+
+	writer.WriteString(appendOnlyLogMagic)
+	binary.Write(writer, binary.BigEndian, appendOnlyLogVersion)
+
+	for _, s := range receiver.cache.shards {
+		for _, item := range s.Items() {
+			if err := WriteMutation(writer, Mutation{Type: MutationSet, Key: item.key, Data: item.data}); err != nil {
+				return file, err
+			}
+		}
+	}
+
+	// A flush that hasn't been reached yet still needs to invalidate the items
+	if flushAt, pending := receiver.cache.PendingFlush(); pending {
+		WriteMutation(writer, Mutation{Type: MutationFlush, FlushAt: flushAt})
+	}
+
+	return file, writer.Flush()
+}
+
+// replaceFile replaces the log with the rewritten file once the mutations made during the rewrite are appended to it.
+// Assumes the caller holds the mutex
+func (receiver *AppendOnlyLog) replaceFile(file *os.File, path string, pendingMutations []byte) error {
+	if _, err := file.Write(pendingMutations); err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, receiver.path); err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	receiver.file.Close()
+	receiver.file = file
+	receiver.size = info.Size()
+	receiver.rewrittenSize = info.Size()
+	receiver.dirty = false
+
+	return nil
+}
+
+// append records a mutation. Used as the mutation listener of the cache
+func (receiver *AppendOnlyLog) append(mutation Mutation) {
+	var record bytes.Buffer
+	WriteMutation(&record, mutation)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.closed {
+		return
+	}
+
+	if receiver.rewriteBuffer != nil {
+		receiver.rewriteBuffer.Write(record.Bytes())
+	}
+
+	n, err := receiver.file.Write(record.Bytes())
+	receiver.size += int64(n)
+
+	if err != nil {
+		log.Println("Error writing to append-only log: ", err)
+		return
+	}
+
+	if receiver.policy != FsyncAlways {
+		receiver.dirty = true
+		return
+	}
+
+	if err := receiver.file.Sync(); err != nil {
+		log.Println("Error flushing append-only log: ", err)
+	}
+}
+
+// runBackgroundTask flushes the log every second if the policy is FsyncEverySecond, and rewrites it once it grows too
+// large
+func (receiver *AppendOnlyLog) runBackgroundTask() {
+	defer close(receiver.backgroundTaskDone)
+
+	for {
+		select {
+		case <-receiver.stopBackgroundTask:
+			return
+		case <-receiver.cache.timeSource.After(time.Second):
+		}
+
+		if receiver.policy == FsyncEverySecond {
+			receiver.flush()
+		}
+
+		if receiver.shouldRewrite() {
+			if err := receiver.Rewrite(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (receiver *AppendOnlyLog) flush() {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if !receiver.dirty || receiver.closed {
+		return
+	}
+
+	if err := receiver.file.Sync(); err != nil {
+		log.Println("Error flushing append-only log: ", err)
+		return
+	}
+
+	receiver.dirty = false
+}
+
+func (receiver *AppendOnlyLog) shouldRewrite() bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return receiver.size >= minRewriteSize && receiver.size >= 2*receiver.rewrittenSize
+}
+
+// countingReader counts the number of bytes read
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (receiver *countingReader) Read(p []byte) (int, error) {
+	n, err := receiver.reader.Read(p)
+	receiver.count += int64(n)
+
+	return n, err
+}
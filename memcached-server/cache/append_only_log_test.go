@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendOnlyLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+	expiresAt := timeSource.Now().Add(time.Hour)
+
+	cache.Set("set", Data{Value: []byte("hello"), Flags: 3})
+	cache.Add("add", Data{Value: []byte("1")})
+	cache.Append("set", Data{Value: []byte(" world")})
+	cache.Increment("add", 10)
+	cache.Touch("add", expiresAt)
+	cache.Set("deleted", Data{Value: []byte("bye")})
+	cache.Delete("deleted")
+
+	if err := appendOnlyLog.Close(); err != nil {
+		t.Fatalf("Unexpected error closing log: %v\n", err)
+	}
+
+	restored := New(-1, WithTimeSource(timeSource))
+	openTestAppendOnlyLog(t, restored, path)
+
+	assertValue(t, restored, "set", "hello world")
+	assertValue(t, restored, "add", "11")
+
+	if data, _ := restored.Get("set"); data.Flags != 3 {
+		t.Fatalf("Unexpected flags. Expected 3, got %d\n", data.Flags)
+	}
+
+	if data, _ := restored.Get("add"); !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected expiration time. Expected %v, got %v\n", expiresAt, data.ExpiresAt)
+	}
+
+	if _, err := restored.Get("deleted"); err == nil {
+		t.Fatalf("Unexpected deleted key restored\n")
+	}
+}
+
+func TestAppendOnlyLogReplayFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cache := New(-1)
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	cache.Set("key1", Data{Value: []byte("1")})
+	cache.Flush(0)
+	time.Sleep(time.Millisecond)
+	cache.Set("key2", Data{Value: []byte("2")})
+	appendOnlyLog.Close()
+
+	restored := New(-1)
+	openTestAppendOnlyLog(t, restored, path)
+
+	if _, err := restored.Get("key1"); err == nil {
+		t.Fatalf("Unexpected flushed key restored\n")
+	}
+
+	assertValue(t, restored, "key2", "2")
+}
+
+func TestAppendOnlyLogSkipsExpiredItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	cache.Set("key1", Data{Value: []byte("1"), ExpiresAt: timeSource.Now().Add(time.Minute)})
+	appendOnlyLog.Close()
+
+	timeSource.Advance(time.Hour)
+
+	restored := New(-1, WithTimeSource(timeSource))
+	openTestAppendOnlyLog(t, restored, path)
+
+	if restored.Size() != 0 {
+		t.Fatalf("Unexpected expired key restored\n")
+	}
+}
+
+func TestAppendOnlyLogDiscardsPartialMutation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cache := New(-1)
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	cache.Set("key1", Data{Value: []byte("1")})
+	appendOnlyLog.Close()
+
+	info, _ := os.Stat(path)
+	validSize := info.Size()
+
+	// A set mutation cut short by a crash
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte{byte(MutationSet), 0, 0, 0, 4, 'k'})
+	file.Close()
+
+	restored := New(-1)
+	appendOnlyLog = openTestAppendOnlyLog(t, restored, path)
+
+	assertValue(t, restored, "key1", "1")
+
+	if info, _ := os.Stat(path); info.Size() != validSize {
+		t.Fatalf("Unexpected file size. Expected %d, got %d\n", validSize, info.Size())
+	}
+
+	// Mutations made afterward are appended after the last complete mutation
+	restored.Set("key2", Data{Value: []byte("2")})
+	appendOnlyLog.Close()
+
+	restoredAgain := New(-1)
+	openTestAppendOnlyLog(t, restoredAgain, path)
+
+	assertValue(t, restoredAgain, "key1", "1")
+	assertValue(t, restoredAgain, "key2", "2")
+}
+
+func TestAppendOnlyLogInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	os.WriteFile(path, []byte("not a log"), 0644)
+
+	_, err := OpenAppendOnlyLog(New(-1), path, FsyncNo)
+	invalidAppendOnlyLogError := &InvalidAppendOnlyLogError{}
+
+	if !errors.As(err, &invalidAppendOnlyLogError) {
+		t.Fatalf("Unexpected error. Expected InvalidAppendOnlyLogError, got %v\n", err)
+	}
+}
+
+func TestAppendOnlyLogRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cache := New(-1)
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	for i := range 100 {
+		cache.Set("key1", Data{Value: []byte{byte('0' + i%10)}})
+	}
+
+	cache.Set("key2", Data{Value: []byte("2")})
+	cache.Delete("key2")
+
+	sizeBefore := fileSize(t, path)
+
+	if err := appendOnlyLog.Rewrite(); err != nil {
+		t.Fatalf("Unexpected error rewriting log: %v\n", err)
+	}
+
+	if sizeAfter := fileSize(t, path); sizeAfter >= sizeBefore/10 {
+		t.Fatalf("Unexpected size after rewrite. Expected less than %d, got %d\n", sizeBefore/10, sizeAfter)
+	}
+
+	// Mutations are appended to the rewritten log
+	cache.Set("key3", Data{Value: []byte("3")})
+	appendOnlyLog.Close()
+
+	restored := New(-1)
+	openTestAppendOnlyLog(t, restored, path)
+
+	assertValue(t, restored, "key1", "9")
+	assertValue(t, restored, "key3", "3")
+
+	if restored.Size() != 2 {
+		t.Fatalf("Unexpected size. Expected 2, got %d\n", restored.Size())
+	}
+}
+
+func TestAppendOnlyLogRewriteKeepsPendingFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	cache.Set("key1", Data{Value: []byte("1")})
+	cache.Flush(time.Minute)
+	appendOnlyLog.Rewrite()
+	appendOnlyLog.Close()
+
+	restored := New(-1, WithTimeSource(timeSource))
+	openTestAppendOnlyLog(t, restored, path)
+
+	assertValue(t, restored, "key1", "1")
+	timeSource.Advance(time.Minute)
+
+	if _, err := restored.Get("key1"); err == nil {
+		t.Fatalf("Unexpected key not flushed\n")
+	}
+}
+
+func TestAppendOnlyLogCreatedFromCacheContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("1")})
+
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+	appendOnlyLog.Close()
+
+	restored := New(-1)
+	openTestAppendOnlyLog(t, restored, path)
+
+	assertValue(t, restored, "key1", "1")
+}
+
+func TestAppendOnlyLogStopsRecordingWhenClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cache := New(-1)
+	appendOnlyLog := openTestAppendOnlyLog(t, cache, path)
+
+	appendOnlyLog.Close()
+	sizeBefore := fileSize(t, path)
+	cache.Set("key1", Data{Value: []byte("1")})
+
+	if fileSize(t, path) != sizeBefore {
+		t.Fatalf("Unexpected mutation recorded after the log was closed\n")
+	}
+
+	// Closing twice is a no-op
+	if err := appendOnlyLog.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for name, expected := range map[string]FsyncPolicy{"always": FsyncAlways, "EVERYSEC": FsyncEverySecond, "no": FsyncNo} {
+		policy, err := ParseFsyncPolicy(name)
+
+		if err != nil || policy != expected {
+			t.Errorf("Unexpected result for %s: %s, %v\n", name, policy, err)
+		}
+	}
+
+	if _, err := ParseFsyncPolicy("sometimes"); !errors.Is(err, InvalidFsyncPolicyError) {
+		t.Fatalf("Unexpected error. Expected InvalidFsyncPolicyError, got %v\n", err)
+	}
+}
+
+func openTestAppendOnlyLog(t *testing.T, cache *Cache, path string) *AppendOnlyLog {
+	appendOnlyLog, err := OpenAppendOnlyLog(cache, path, FsyncAlways)
+
+	if err != nil {
+		t.Fatalf("Unexpected error opening log: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		appendOnlyLog.Close()
+	})
+
+	return appendOnlyLog
+}
+
+func assertValue(t *testing.T, cache *Cache, key string, expected string) {
+	data, err := cache.Get(key)
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %v\n", key, err)
+	}
+
+	if !reflect.DeepEqual(data.Value, []byte(expected)) {
+		t.Fatalf("Unexpected value for %s. Expected '%s', got '%s'\n", key, expected, data.Value)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
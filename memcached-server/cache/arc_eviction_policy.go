@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"container/list"
+	"math"
+)
+
+// arcEvictionPolicy Adaptive Replacement Cache (Megiddo & Modha). Keys seen once are tracked in a recency list (t1)
+// and keys seen at least twice in a frequency list (t2). The "ghost" lists (b1 and b2) remember keys recently evicted
+// from each of them, and hits on the ghosts adapt how much of the cache is given to recency vs frequency.
+//
+// Unlike the original algorithm, eviction happens before the new key is known, so the choice of victim can't take into
+// account whether the new key is in b2
+type arcEvictionPolicy struct {
+	// Least recently used keys are in the front of every list
+	t1, t2, b1, b2 *list.List
+	lookupTable    map[string]*list.Element
+	// Target size of t1
+	target int
+	// Maximum number of keys in the cache. If it's unbounded, the number of keys currently in the cache is used instead
+	capacity int
+}
+
+type arcEntry struct {
+	key  string
+	list *list.List
+}
+
+func newARCEvictionPolicy(capacity int) *arcEvictionPolicy {
+	return &arcEvictionPolicy{
+		t1:          list.New(),
+		t2:          list.New(),
+		b1:          list.New(),
+		b2:          list.New(),
+		lookupTable: make(map[string]*list.Element),
+		capacity:    capacity,
+	}
+}
+
+func (receiver *arcEvictionPolicy) Insert(key string) {
+	element, isGhost := receiver.lookupTable[key]
+
+	if !isGhost {
+		receiver.pushBack(receiver.t1, key)
+		return
+	}
+
+	entry := element.Value.(*arcEntry)
+
+	// A hit in a ghost list means the key was evicted too early, so more space is given to the list it was evicted from
+	if entry.list == receiver.b1 {
+		delta := max(receiver.b2.Len()/receiver.b1.Len(), 1)
+		receiver.target = min(receiver.target+delta, receiver.effectiveCapacity())
+	} else {
+		delta := max(receiver.b1.Len()/receiver.b2.Len(), 1)
+		receiver.target = max(receiver.target-delta, 0)
+	}
+
+	receiver.remove(element)
+	receiver.pushBack(receiver.t2, key)
+}
+
+func (receiver *arcEvictionPolicy) Access(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok || !receiver.isResident(element) {
+		return
+	}
+
+	receiver.remove(element)
+	receiver.pushBack(receiver.t2, key)
+}
+
+func (receiver *arcEvictionPolicy) Remove(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	// Ghost entries are kept since the key was evicted by Victim
+	if ok && receiver.isResident(element) {
+		receiver.remove(element)
+	}
+}
+
+// Victim moves the victim to its ghost list, so the key is remembered after the shard removes it
+func (receiver *arcEvictionPolicy) Victim() (string, bool) {
+	var from, to *list.List
+
+	if receiver.t1.Len() > 0 && (receiver.t1.Len() > receiver.target || receiver.t2.Len() == 0) {
+		from, to = receiver.t1, receiver.b1
+	} else if receiver.t2.Len() > 0 {
+		from, to = receiver.t2, receiver.b2
+	} else {
+		return "", false
+	}
+
+	key := from.Front().Value.(*arcEntry).key
+
+	receiver.remove(receiver.lookupTable[key])
+	receiver.pushBack(to, key)
+	receiver.trimGhosts()
+
+	return key, true
+}
+
+func (receiver *arcEvictionPolicy) Len() int {
+	return receiver.t1.Len() + receiver.t2.Len()
+}
+
+// trimGhosts keeps |t1| + |b1| <= c and |t1| + |t2| + |b1| + |b2| <= 2c
+func (receiver *arcEvictionPolicy) trimGhosts() {
+	capacity := receiver.effectiveCapacity()
+
+	for receiver.b1.Len() > 0 && receiver.t1.Len()+receiver.b1.Len() > capacity {
+		receiver.remove(receiver.b1.Front())
+	}
+
+	for receiver.b2.Len() > 0 && receiver.Len()+receiver.b1.Len()+receiver.b2.Len() > 2*capacity {
+		receiver.remove(receiver.b2.Front())
+	}
+}
+
+func (receiver *arcEvictionPolicy) effectiveCapacity() int {
+	if receiver.capacity == math.MaxInt {
+		return max(receiver.Len(), 1)
+	}
+
+	return receiver.capacity
+}
+
+func (receiver *arcEvictionPolicy) isResident(element *list.Element) bool {
+	entry := element.Value.(*arcEntry)
+
+	return entry.list == receiver.t1 || entry.list == receiver.t2
+}
+
+func (receiver *arcEvictionPolicy) pushBack(l *list.List, key string) {
+	receiver.lookupTable[key] = l.PushBack(&arcEntry{key: key, list: l})
+}
+
+func (receiver *arcEvictionPolicy) remove(element *list.Element) {
+	entry := element.Value.(*arcEntry)
+
+	entry.list.Remove(element)
+	delete(receiver.lookupTable, entry.key)
+}
@@ -1,245 +1,480 @@
 package cache
 
 import (
-	"container/list"
 	"log"
 	"math"
+	"memcached-server/utils"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type keyValue struct {
-	Key   string
-	Value Data
-}
+// Expiration times over this number of seconds (30 days) are Unix timestamps rather than relative to the current time
+const maxRelativeExpiration = 60 * 60 * 24 * 30
 
 type Data struct {
-	Value     string
+	// Raw bytes of the value. The cache keeps a reference to the slice, so it must not be modified after it's stored
+	Value     []byte
 	Flags     uint16
 	ByteCount int
 	// Time at which the data will expire. Defaults to never expire (`time.UnixMilli(0)`)
 	ExpiresAt time.Time
+	// Unique value assigned by the cache every time the data is stored. Used for check-and-set operations. Any value
+	// provided when storing data is ignored
+	CasUnique uint64
 }
 
-// Cache simple in-memory cache
+// Cache simple in-memory cache. It's safe for concurrent use.
+//
+// Keys are distributed across one or more shards (see WithShards) by hash. Each shard is an independent cache with its
+// own lock and eviction policy (see WithEvictionPolicy), so eviction decisions are made within a shard rather than
+// across the whole cache
 type Cache struct {
-	// Least frequently used elements are in the fronts
-	accessList           *list.List
-	lookupTable          map[string]*list.Element
-	shouldRunCleanupTask bool
-	Capacity             int
+	shards []*shard
+	// Closed to stop the cleanup task. Nil if the task isn't running
+	stopCleanupTask chan struct{}
+	// Closed by the cleanup task once it stops
+	cleanupTaskDone chan struct{}
+	// Guards stopCleanupTask and cleanupTaskDone
+	cleanupTaskMutex *sync.Mutex
+	flushAt          *atomic.Int64
+	timeSource       utils.TimeSource
+	// See AddMutationListener. Replaced rather than modified, so shards can read it without locking
+	listeners *atomic.Pointer[[]MutationListener]
+	Capacity  int
+	// Maximum number of bytes used by the items in the cache, including per-item overhead. Items are evicted when
+	// storing data would exceed it
+	MemoryLimit    int64
+	NumShards      int
+	EvictionPolicy EvictionPolicy
+	// Growth factor of the slab classes of the slab allocator (see WithSlabAllocator). Zero if it's disabled
+	SlabGrowthFactor float64
 }
 
-// New Creates new Cache instance with a given capacity. Capacity will be unbounded if `capacity <= 0`
-func New(capacity int) *Cache {
-	if capacity <= 0 {
-		capacity = math.MaxInt
+type config struct {
+	numShards        int
+	memoryLimit      int64
+	evictionPolicy   EvictionPolicy
+	timeSource       utils.TimeSource
+	slabGrowthFactor float64
+}
+
+// Option configures optional settings of a Cache
+type Option func(*config)
+
+// WithShards splits the cache into numShards independently locked shards to reduce lock contention between concurrent
+// operations. Defaults to a single shard. Values less than 1 are ignored
+func WithShards(numShards int) Option {
+	return func(c *config) {
+		if numShards > 0 {
+			c.numShards = numShards
+		}
 	}
+}
 
-	return &Cache{
-		accessList:           list.New(),
-		lookupTable:          make(map[string]*list.Element),
-		Capacity:             capacity,
-		shouldRunCleanupTask: false,
+// WithMemoryLimit bounds the number of bytes used by the items in the cache. Each shard gets an equal share of the
+// limit, and storing an item larger than a shard's share fails with ItemTooLargeError. Defaults to unbounded. Values
+// less than 1 are ignored
+func WithMemoryLimit(bytes int64) Option {
+	return func(c *config) {
+		if bytes > 0 {
+			c.memoryLimit = bytes
+		}
 	}
 }
 
-// Size Returns the number of keys currently in the cache
-func (receiver *Cache) Size() int {
-	return len(receiver.lookupTable)
+// WithEvictionPolicy sets the algorithm used to pick which keys are evicted when the cache is full. Defaults to
+// EvictionPolicyLRU
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(c *config) {
+		c.evictionPolicy = policy
+	}
 }
 
-// Set stores key with given value in the cache. Returns error if key is invalid (e.g., empty string)
-func (receiver *Cache) Set(key string, data Data) error {
-	if len(key) < 1 {
-		return &EmptyKeyError{}
+// WithTimeSource sets the clock used to decide when data expires and to schedule the cleanup task (see
+// RunExpireDataCleanupBackgroundTask). Defaults to the system clock
+func WithTimeSource(timeSource utils.TimeSource) Option {
+	return func(c *config) {
+		c.timeSource = timeSource
 	}
+}
 
-	if element, exists := receiver.lookupTable[key]; exists {
-		element.Value.(*keyValue).Value = data
-		receiver.accessList.MoveToBack(element)
+// WithSlabAllocator stores keys and values in chunks of large preallocated pages instead of a heap object each, which
+// greatly reduces the work of the garbage collector for large caches. Chunks are grouped into slab classes, each with
+// chunks growthFactor times larger than the previous one, and items are stored in the smallest chunk they fit in.
+// Values less than or equal to 1 default to 1.25, same as memcached.
+//
+// The memory limit (see WithMemoryLimit) bounds the number of pages, with at least one page per shard, rather than
+// the bytes used by the items. When a slab class runs out of chunks, the least recently used items of that class are
+// evicted rather than the ones picked by the eviction policy, which is still used when the capacity is reached. Items
+// larger than a page (1MB) are rejected with ItemTooLargeError.
+//
+// Only the chunks count towards the memory limit. The lookup table keeps its own copy of every key on the heap, along
+// with the per-item bookkeeping, which isn't accounted for
+func WithSlabAllocator(growthFactor float64) Option {
+	return func(c *config) {
+		c.slabGrowthFactor = growthFactor
+
+		if growthFactor <= 1 {
+			c.slabGrowthFactor = defaultSlabGrowthFactor
+		}
+	}
+}
 
-		return nil
+// New Creates new Cache instance with a given capacity. Capacity will be unbounded if `capacity <= 0`
+func New(capacity int, options ...Option) *Cache {
+	if capacity <= 0 {
+		capacity = math.MaxInt
 	}
 
-	if receiver.Size() == receiver.Capacity {
-		leastRecentlyUsedElement := receiver.accessList.Front()
-		prevKey := leastRecentlyUsedElement.Value.(*keyValue).Key
+	cfg := config{
+		numShards:      1,
+		memoryLimit:    math.MaxInt64,
+		evictionPolicy: EvictionPolicyLRU,
+		timeSource:     &utils.RealTimeSource{},
+	}
 
-		delete(receiver.lookupTable, prevKey)
+	for _, option := range options {
+		option(&cfg)
+	}
 
-		leastRecentlyUsedElement.Value.(*keyValue).Key = key
-		leastRecentlyUsedElement.Value.(*keyValue).Value = data
-		receiver.accessList.MoveToBack(leastRecentlyUsedElement)
+	// Every shard needs to be able to hold at least one key
+	numShards := min(cfg.numShards, capacity)
+	lastCasUnique := &atomic.Uint64{}
+	flushAt := &atomic.Int64{}
+	listeners := &atomic.Pointer[[]MutationListener]{}
+	listeners.Store(&[]MutationListener{})
+	shards := make([]*shard, numShards)
+
+	for i := range shards {
+		shards[i] = newShard(
+			shardCapacity(capacity, numShards, i),
+			shardMemoryLimit(cfg.memoryLimit, numShards),
+			cfg.evictionPolicy,
+			lastCasUnique,
+			flushAt,
+			cfg.timeSource,
+			listeners,
+			cfg.slabGrowthFactor,
+		)
+	}
 
-		receiver.lookupTable[key] = leastRecentlyUsedElement
-		return nil
+	return &Cache{
+		shards:           shards,
+		flushAt:          flushAt,
+		timeSource:       cfg.timeSource,
+		listeners:        listeners,
+		Capacity:         capacity,
+		MemoryLimit:      cfg.memoryLimit,
+		NumShards:        numShards,
+		EvictionPolicy:   cfg.evictionPolicy,
+		SlabGrowthFactor: cfg.slabGrowthFactor,
+		cleanupTaskMutex: &sync.Mutex{},
+	}
+}
+
+// Size Returns the number of keys currently in the cache
+func (receiver *Cache) Size() int {
+	size := 0
+
+	for _, s := range receiver.shards {
+		size += s.Size()
 	}
 
-	element := receiver.accessList.PushBack(&keyValue{Key: key, Value: data})
-	receiver.lookupTable[key] = element
+	return size
+}
 
-	return nil
+// Set stores key with given value in the cache and returns the CAS unique value assigned to it. Returns error if key is
+// invalid (e.g., empty string)
+func (receiver *Cache) Set(key string, data Data) (uint64, error) {
+	return receiver.shardFor(key).Set(key, data)
 }
 
 // Get retrieves value from the cache by key. Returns error if key is not found or if the key is invalid (e.g., empty string)
 func (receiver *Cache) Get(key string) (Data, error) {
-	if len(key) < 1 {
-		return Data{}, &EmptyKeyError{}
-	}
+	return receiver.shardFor(key).Get(key)
+}
 
-	if _, exists := receiver.lookupTable[key]; !exists {
-		return Data{}, &KeyNotFoundError{key}
+// ExpirationTime converts an expiration time as sent by memcached clients to the time at which the data expires:
+//   - Zero means the data never expires
+//   - Negative values mean the data is already expired
+//   - Values up to 30 days are the number of seconds from now
+//   - Larger values are Unix timestamps
+func (receiver *Cache) ExpirationTime(exptime int) time.Time {
+	switch {
+	case exptime == 0:
+		return time.UnixMilli(0)
+	case exptime < 0:
+		return time.UnixMilli(1)
+	case exptime > maxRelativeExpiration:
+		return time.Unix(int64(exptime), 0)
 	}
 
-	element := receiver.lookupTable[key]
-	value := element.Value.(*keyValue).Value
+	return receiver.timeSource.Now().Add(time.Second * time.Duration(exptime))
+}
 
-	if isExpired(value) {
-		err := receiver.Delete(key)
+// Now returns the current time of the clock the cache uses to decide when data expires (see WithTimeSource)
+func (receiver *Cache) Now() time.Time {
+	return receiver.timeSource.Now()
+}
 
-		if err != nil {
-			return Data{}, err
-		}
+// Touch updates the expiration time of the key. Returns KeyNotFoundError if the key is not found
+func (receiver *Cache) Touch(key string, expiresAt time.Time) error {
+	return receiver.shardFor(key).Touch(key, expiresAt)
+}
 
-		return Data{}, &KeyNotFoundError{key}
-	}
+// GetAndTouch retrieves value from the cache by key and updates its expiration time. Returns the same errors as Get
+func (receiver *Cache) GetAndTouch(key string, expiresAt time.Time) (Data, error) {
+	return receiver.shardFor(key).GetAndTouch(key, expiresAt)
+}
 
-	receiver.accessList.MoveToBack(element)
+// Flush invalidates all the items in the cache after delay. Items stored before then, including the ones stored while
+// waiting for the delay, are invalidated. Items stored afterward are not affected. A new flush replaces the pending one
+func (receiver *Cache) Flush(delay time.Duration) {
+	flushAt := receiver.timeSource.Now().Add(delay)
 
-	return value, nil
+	receiver.flushAt.Store(flushAt.UnixNano())
+	notifyListeners(*receiver.listeners.Load(), Mutation{Type: MutationFlush, FlushAt: flushAt})
 }
 
-// Delete key if it exists. Currently there are no errors for this function
-func (receiver *Cache) Delete(key string) error {
-	if _, exists := receiver.lookupTable[key]; !exists {
-		return nil
+// PendingFlush returns the time at which the last flush (see Flush) invalidates the items. False if the cache was never
+// flushed or the flush already took effect
+func (receiver *Cache) PendingFlush() (time.Time, bool) {
+	flushAt := receiver.flushAt.Load()
+
+	if flushAt == 0 || flushAt <= receiver.timeSource.Now().UnixNano() {
+		return time.Time{}, false
 	}
 
-	element, _ := receiver.lookupTable[key]
+	return time.Unix(0, flushAt), true
+}
 
-	receiver.accessList.Remove(element)
-	delete(receiver.lookupTable, key)
+// Clear deletes all the items in the cache right away. Unlike Flush, items stored afterward are never affected
+func (receiver *Cache) Clear() {
+	for _, s := range receiver.shards {
+		s.Clear()
+	}
+}
 
-	return nil
+// Peek retrieves value from the cache by key without counting it as an access, e.g., for the eviction policy or the
+// stats. Returns the same errors as Get
+func (receiver *Cache) Peek(key string) (Data, error) {
+	return receiver.shardFor(key).Peek(key)
 }
 
-func (receiver *Cache) Add(key string, data Data) error {
-	if receiver.hasKey(key) && !receiver.isKeyExpired(key) {
-		return &KeyAlreadyExistsError{Key: key}
+// Stats Returns the stats of all shards combined
+func (receiver *Cache) Stats() Stats {
+	stats := Stats{}
+
+	for _, s := range receiver.shards {
+		stats = stats.add(s.Stats())
 	}
 
-	return receiver.Set(key, data)
+	return stats
 }
 
-func (receiver *Cache) Replace(key string, data Data) error {
-	if !receiver.hasKey(key) || receiver.isKeyExpired(key) {
-		return &KeyNotFoundError{Key: key}
+// SlabStats returns the stats of each slab class of all shards combined, from the smallest chunks to the largest. Nil if
+// the slab allocator is disabled (see WithSlabAllocator)
+func (receiver *Cache) SlabStats() []SlabClassStats {
+	var stats []SlabClassStats
+
+	for _, s := range receiver.shards {
+		shardStats := s.SlabStats()
+
+		if stats == nil {
+			stats = shardStats
+			continue
+		}
+
+		for i := range stats {
+			stats[i] = stats[i].add(shardStats[i])
+		}
 	}
 
-	return receiver.Set(key, data)
+	return stats
 }
 
-// Append data is appended to the data matching the given key, if exists. Returns error if key doesn't exist
-func (receiver *Cache) Append(key string, data Data) error {
-	cachedData, err := receiver.Get(key)
+// Delete key if it exists. Returns KeyNotFoundError otherwise, so only one of concurrent deletes of a key succeeds
+func (receiver *Cache) Delete(key string) error {
+	return receiver.shardFor(key).Delete(key)
+}
 
-	if err != nil {
-		return err
-	}
+// CompareAndDelete deletes the key only if it hasn't been updated since it was fetched, i.e., the CAS unique of the cached
+// data matches casUnique. Returns KeyNotFoundError if the key doesn't exist, and CasMismatchError if the CAS unique
+// doesn't match
+func (receiver *Cache) CompareAndDelete(key string, casUnique uint64) error {
+	return receiver.shardFor(key).CompareAndDelete(key, casUnique)
+}
 
-	return receiver.Set(key, Data{
-		Value:     cachedData.Value + data.Value,
-		ByteCount: cachedData.ByteCount + data.ByteCount,
-		// There's no requirements in the project regarding the handling of these fields, so
-		// just leave it as it is
-		Flags:     cachedData.Flags,
-		ExpiresAt: cachedData.ExpiresAt,
-	})
+// Add stores the data only if the key doesn't exist and returns the CAS unique value assigned to it. Returns
+// KeyAlreadyExistsError otherwise
+func (receiver *Cache) Add(key string, data Data) (uint64, error) {
+	return receiver.shardFor(key).Add(key, data)
 }
 
-// Prepend data is prepended to the data matching the given key, if exists. Returns error if key doesn't exist
-func (receiver *Cache) Prepend(key string, data Data) error {
-	cachedData, err := receiver.Get(key)
+// Replace stores the data only if the key exists and returns the CAS unique value assigned to it. Returns
+// KeyNotFoundError otherwise
+func (receiver *Cache) Replace(key string, data Data) (uint64, error) {
+	return receiver.shardFor(key).Replace(key, data)
+}
 
-	if err != nil {
-		return err
-	}
+// Cas stores the data only if it hasn't been updated since it was fetched, i.e., the CAS unique of the cached data
+// matches casUnique, and returns the new CAS unique value. Returns error if the key doesn't exist or if the CAS unique
+// doesn't match
+func (receiver *Cache) Cas(key string, data Data, casUnique uint64) (uint64, error) {
+	return receiver.shardFor(key).Cas(key, data, casUnique)
+}
 
-	return receiver.Set(key, Data{
-		Value:     data.Value + cachedData.Value,
-		ByteCount: cachedData.ByteCount + data.ByteCount,
-		// There's no requirements in the project regarding the handling of these fields, so
-		// just leave it as it is
-		Flags:     cachedData.Flags,
-		ExpiresAt: cachedData.ExpiresAt,
+// Append data is appended to the data matching the given key, if exists, and returns the new CAS unique value. Returns
+// error if key doesn't exist
+func (receiver *Cache) Append(key string, data Data) (uint64, error) {
+	return receiver.shardFor(key).Append(key, data)
+}
+
+// Prepend data is prepended to the data matching the given key, if exists, and returns the new CAS unique value.
+// Returns error if key doesn't exist
+func (receiver *Cache) Prepend(key string, data Data) (uint64, error) {
+	return receiver.shardFor(key).Prepend(key, data)
+}
+
+// Increment increments the value matching the given key by delta and returns the new value along with its CAS unique
+// value. The value must be the decimal representation of a 64-bit unsigned integer and wraps around on overflow.
+// Returns error if key doesn't exist or if the value isn't numeric
+func (receiver *Cache) Increment(key string, delta uint64) (uint64, uint64, error) {
+	return receiver.shardFor(key).UpdateCounter(key, func(value uint64) uint64 {
+		return value + delta
+	})
+}
+
+// Decrement decrements the value matching the given key by delta and returns the new value along with its CAS unique
+// value. The value must be the decimal representation of a 64-bit unsigned integer. Decrementing below 0 results in 0.
+// Returns error if key doesn't exist or if the value isn't numeric
+func (receiver *Cache) Decrement(key string, delta uint64) (uint64, uint64, error) {
+	return receiver.shardFor(key).UpdateCounter(key, func(value uint64) uint64 {
+		if delta > value {
+			return 0
+		}
+
+		return value - delta
 	})
 }
 
 // RunExpireDataCleanupBackgroundTask starts background task to clean up expired data. No effect if there's already
-// a task running for this cache instance. It's recommended to not set the frequency too low (less than 5 seconds) since it will negatively
-// impact performance
+// a task running for this cache instance. Each run only goes through the items that are due, so its cost depends on the
+// number of items that expired since the last run rather than on the size of the cache
 func (receiver *Cache) RunExpireDataCleanupBackgroundTask(cleanupFrequencyMs int) {
-	go receiver.setUpCleanupBackgroundTask(cleanupFrequencyMs)
+	receiver.cleanupTaskMutex.Lock()
+	defer receiver.cleanupTaskMutex.Unlock()
+
+	if receiver.stopCleanupTask != nil {
+		return
+	}
+
+	receiver.stopCleanupTask = make(chan struct{})
+	receiver.cleanupTaskDone = make(chan struct{})
+
+	go receiver.setUpCleanupBackgroundTask(cleanupFrequencyMs, receiver.stopCleanupTask, receiver.cleanupTaskDone)
 }
 
-// Close frees up resources and any running background tasks like Cache.RunExpireDataCleanupBackgroundTask
+// Close frees up resources and any running background tasks like Cache.RunExpireDataCleanupBackgroundTask. Blocks
+// until the background tasks stop
 func (receiver *Cache) Close() {
 	receiver.stopCleanupBackgroundTask()
 }
 
-func (receiver *Cache) setUpCleanupBackgroundTask(frequencyMs int) {
-	receiver.shouldRunCleanupTask = true
+func (receiver *Cache) setUpCleanupBackgroundTask(frequencyMs int, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
 
-	for receiver.shouldRunCleanupTask {
+	for {
 		log.Print("Deleting expired records...")
 		receiver.clearExpiredData()
-		time.Sleep(time.Millisecond * time.Duration(frequencyMs))
+
+		select {
+		case <-stop:
+			return
+		case <-receiver.timeSource.After(time.Millisecond * time.Duration(frequencyMs)):
+		}
 	}
 }
 
 func (receiver *Cache) clearExpiredData() {
-	node := receiver.accessList.Front()
-	sizeBefore := receiver.Size()
-
-	// Iterating through the list is much faster (10-20x) than iterating through keys of the lookupTable.
-	for node != nil {
-		next := node.Next()
-		val := node.Value.(*keyValue)
-		if isExpired(val.Value) {
-			receiver.Delete(val.Key)
-		}
+	numRecordsDeleted := 0
 
-		node = next
+	// Shards are cleared one at a time so the rest of the cache remains available while a shard is being cleared
+	for _, s := range receiver.shards {
+		numRecordsDeleted += s.ClearExpiredData()
 	}
 
-	numRecordsDeleted := sizeBefore - receiver.Size()
-
 	if numRecordsDeleted > 0 {
 		log.Printf("Deleted %d records\n", numRecordsDeleted)
 	}
 }
 
+// stopCleanupBackgroundTask stops the cleanup task and waits for it to finish the run in progress, if any
 func (receiver *Cache) stopCleanupBackgroundTask() {
-	receiver.shouldRunCleanupTask = false
+	receiver.cleanupTaskMutex.Lock()
+	defer receiver.cleanupTaskMutex.Unlock()
+
+	if receiver.stopCleanupTask == nil {
+		return
+	}
+
+	close(receiver.stopCleanupTask)
+	<-receiver.cleanupTaskDone
+
+	receiver.stopCleanupTask = nil
+	receiver.cleanupTaskDone = nil
 	log.Println("Cleanup task stopped")
 }
 
-func (receiver *Cache) hasKey(key string) bool {
-	_, ok := receiver.lookupTable[key]
+func (receiver *Cache) shardFor(key string) *shard {
+	if len(receiver.shards) == 1 {
+		return receiver.shards[0]
+	}
 
-	return ok
+	return receiver.shards[hashKey(key)%uint32(len(receiver.shards))]
 }
 
-func (receiver *Cache) isKeyExpired(key string) bool {
-	element, ok := receiver.lookupTable[key]
+// hashKey 32-bit FNV-1a hash of the key. Implemented inline instead of using `hash/fnv` to avoid allocations
+func hashKey(key string) uint32 {
+	const offsetBasis = 2166136261
+	const prime = 16777619
+
+	hash := uint32(offsetBasis)
+
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime
+	}
+
+	return hash
+}
+
+// shardCapacity splits capacity as evenly as possible across numShards
+func shardCapacity(capacity int, numShards int, shardIndex int) int {
+	if capacity == math.MaxInt {
+		return capacity
+	}
+
+	perShard := capacity / numShards
+
+	if shardIndex < capacity%numShards {
+		perShard++
+	}
+
+	return perShard
+}
 
-	if !ok {
-		return false
+func shardMemoryLimit(memoryLimit int64, numShards int) int64 {
+	if memoryLimit == math.MaxInt64 {
+		return memoryLimit
 	}
 
-	return isExpired(element.Value.(*keyValue).Value)
+	return memoryLimit / int64(numShards)
 }
 
-func isExpired(data Data) bool {
-	return data.ExpiresAt.UnixMilli() > 0 && time.Now().UnixMilli() > data.ExpiresAt.UnixMilli()
+// isExpired returns whether the data expired by now. Data is expired from the millisecond of its expiration time on, so
+// data stored to expire right away (e.g., at `time.Now()`) is expired even if now is read in the same millisecond
+func isExpired(data Data, now time.Time) bool {
+	return data.ExpiresAt.UnixMilli() > 0 && now.UnixMilli() >= data.ExpiresAt.UnixMilli()
 }
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// Run with `go test -bench . -run ^$ ./cache/` to compare throughput of a single shard against multiple shards
+
+const numBenchmarkKeys = 100_000
+
+var benchmarkShardCounts = []int{1, 4, 16, 64}
+
+// BenchmarkReadHeavy 90% reads and 10% writes from parallel goroutines
+func BenchmarkReadHeavy(b *testing.B) {
+	for _, numShards := range benchmarkShardCounts {
+		b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+			cache := newBenchmarkCache(numShards)
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := strconv.Itoa(i % numBenchmarkKeys)
+
+					if i%10 == 0 {
+						cache.Set(key, Data{Value: []byte("value"), ByteCount: 5})
+					} else {
+						cache.Get(key)
+					}
+
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkWriteHeavy only writes from parallel goroutines
+func BenchmarkWriteHeavy(b *testing.B) {
+	for _, numShards := range benchmarkShardCounts {
+		b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+			cache := newBenchmarkCache(numShards)
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Set(strconv.Itoa(i%numBenchmarkKeys), Data{Value: []byte("value"), ByteCount: 5})
+					i++
+				}
+			})
+		})
+	}
+}
+
+func newBenchmarkCache(numShards int) *Cache {
+	cache := New(-1, WithShards(numShards))
+
+	for i := range numBenchmarkKeys {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("value"), ByteCount: 5})
+	}
+
+	return cache
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests are meant to be run with the race detector enabled (`go test -race ./...`)
+
+const numWorkers = 16
+const numOperationsPerWorker = 2_000
+
+func TestConcurrentSetGet(t *testing.T) {
+	cache := New(-1)
+
+	runConcurrently(func(worker int) {
+		for i := range numOperationsPerWorker {
+			key := fmt.Sprintf("%d-%d", worker, i)
+			value := strconv.Itoa(i)
+
+			if _, err := cache.Set(key, Data{Value: []byte(value), ByteCount: len(value)}); err != nil {
+				t.Error(err)
+				return
+			}
+
+			data, err := cache.Get(key)
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if string(data.Value) != value {
+				t.Errorf("Unexpected value for key %s. Expected '%s', got '%s'\n", key, value, data.Value)
+				return
+			}
+		}
+	})
+
+	if cache.Size() != numWorkers*numOperationsPerWorker {
+		t.Errorf("Incorrect cache size. Expected: %d, got: %d\n", numWorkers*numOperationsPerWorker, cache.Size())
+	}
+}
+
+func TestConcurrentAppendSameKey(t *testing.T) {
+	cache := New(-1)
+	cache.Set("test", Data{})
+
+	runConcurrently(func(worker int) {
+		for range numOperationsPerWorker {
+			if _, err := cache.Append("test", Data{Value: []byte("a"), ByteCount: 1}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	data, _ := cache.Get("test")
+
+	// Every append must be applied exactly once. Lost updates would result in a shorter value
+	if len(data.Value) != numWorkers*numOperationsPerWorker || data.ByteCount != len(data.Value) {
+		t.Errorf("Expected value of length %d, got length %d (byte count %d)\n", numWorkers*numOperationsPerWorker, len(data.Value), data.ByteCount)
+	}
+}
+
+func TestConcurrentIncrementSameKey(t *testing.T) {
+	cache := New(-1)
+	cache.Set("counter", Data{Value: []byte("0"), ByteCount: 1})
+
+	runConcurrently(func(worker int) {
+		for range numOperationsPerWorker {
+			if _, _, err := cache.Increment("counter", 1); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	data, _ := cache.Get("counter")
+	expected := strconv.Itoa(numWorkers * numOperationsPerWorker)
+
+	if string(data.Value) != expected {
+		t.Errorf("Unexpected counter value. Expected %s, got %s\n", expected, data.Value)
+	}
+}
+
+func TestConcurrentAddSameKey(t *testing.T) {
+	cache := New(-1)
+	numAdded := 0
+	mutex := sync.Mutex{}
+
+	runConcurrently(func(worker int) {
+		if _, err := cache.Add("test", Data{Value: []byte(strconv.Itoa(worker))}); err == nil {
+			mutex.Lock()
+			numAdded++
+			mutex.Unlock()
+		}
+	})
+
+	if numAdded != 1 {
+		t.Errorf("Expected exactly one add to succeed. %d succeeded\n", numAdded)
+	}
+}
+
+func TestConcurrentDeleteSameKey(t *testing.T) {
+	cache := New(-1)
+	numDeleted := 0
+	mutex := sync.Mutex{}
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+
+	runConcurrently(func(worker int) {
+		if err := cache.Delete("test"); err == nil {
+			mutex.Lock()
+			numDeleted++
+			mutex.Unlock()
+		}
+	})
+
+	if numDeleted != 1 {
+		t.Errorf("Expected exactly one delete to succeed. %d succeeded\n", numDeleted)
+	}
+}
+
+func TestConcurrentMixedOperationsWithCleanupTask(t *testing.T) {
+	testConcurrentMixedOperationsWithCleanupTask(t, New(1_000))
+}
+
+func TestConcurrentMixedOperationsWithCleanupTask_Sharded(t *testing.T) {
+	testConcurrentMixedOperationsWithCleanupTask(t, New(1_000, WithShards(8)))
+}
+
+func testConcurrentMixedOperationsWithCleanupTask(t *testing.T, cache *Cache) {
+	cache.RunExpireDataCleanupBackgroundTask(1)
+
+	defer cache.stopCleanupBackgroundTask()
+
+	runConcurrently(func(worker int) {
+		for i := range numOperationsPerWorker {
+			key := strconv.Itoa(i % 100)
+			data := Data{Value: []byte("a"), ByteCount: 1}
+
+			// Some of the data expires right away so the cleanup task has something to delete
+			if i%3 == 0 {
+				data.ExpiresAt = time.Now()
+			}
+
+			switch (worker + i) % 7 {
+			case 0:
+				cache.Set(key, data)
+			case 1:
+				cache.Get(key)
+			case 2:
+				cache.Append(key, data)
+			case 3:
+				cache.Prepend(key, data)
+			case 4:
+				cache.Delete(key)
+			case 5:
+				cache.Add(key, data)
+			case 6:
+				cache.Replace(key, data)
+			}
+		}
+	})
+
+	if cache.Size() > 100 {
+		t.Errorf("Expected at most %d keys, got %d\n", 100, cache.Size())
+	}
+}
+
+func TestConcurrentEviction(t *testing.T) {
+	capacity := 100
+	cache := New(capacity)
+
+	runConcurrently(func(worker int) {
+		for i := range numOperationsPerWorker {
+			cache.Set(fmt.Sprintf("%d-%d", worker, i), Data{})
+		}
+	})
+
+	if cache.Size() != capacity {
+		t.Errorf("Incorrect cache size. Expected: %d, got: %d\n", capacity, cache.Size())
+	}
+
+	if evictionPolicyLength(cache) != capacity {
+		t.Errorf("Eviction policy is out of sync with the lookup table. Expected length %d, got %d\n", capacity, evictionPolicyLength(cache))
+	}
+}
+
+// runConcurrently runs worker in numWorkers goroutines and waits for all of them to finish
+func runConcurrently(worker func(worker int)) {
+	wg := sync.WaitGroup{}
+
+	for i := range numWorkers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			worker(i)
+		}()
+	}
+
+	wg.Wait()
+}
@@ -2,29 +2,32 @@ package cache
 
 import (
 	"log"
+	"runtime"
 	"strconv"
 	"testing"
 	"time"
 )
 
 func TestActiveExpirationCleanup(t *testing.T) {
-	cache := New(-1)
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
 
 	defer cache.stopCleanupBackgroundTask()
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
-		ExpiresAt: time.Now(),
+		ExpiresAt: timeSource.Now(),
 	})
 
+	timeSource.Advance(time.Second)
 	cache.RunExpireDataCleanupBackgroundTask(100)
 
-	// Give the task some time to run
-	time.Sleep(time.Duration(time.Millisecond * 500))
+	// The task waits for the next run once it's done clearing the data
+	timeSource.BlockUntil(1)
 
 	// Avoid calling any public cache methods since they will check for expiration of the data and clear it if it's expired
-	_, ok := cache.lookupTable["test"]
+	ok := hasKeyIgnoringExpiration(cache, "test")
 
 	if ok {
 		t.Error("Data should have been deleted")
@@ -32,46 +35,77 @@ func TestActiveExpirationCleanup(t *testing.T) {
 }
 
 func TestActiveExpirationCleanupNoExpiredRecords(t *testing.T) {
-	cache := New(-1)
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
 
 	defer cache.stopCleanupBackgroundTask()
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
-		ExpiresAt: time.Now().Add(time.Minute * time.Duration(1)),
+		ExpiresAt: timeSource.Now().Add(time.Minute * time.Duration(1)),
 	})
 
 	cache.RunExpireDataCleanupBackgroundTask(100)
 
-	// Give the task some time to run
-	time.Sleep(time.Duration(time.Millisecond * 500))
+	timeSource.BlockUntil(1)
 
 	// Avoid calling any public cache methods since they will check for expiration of the data and clear it if it's expired
-	_, ok := cache.lookupTable["test"]
+	ok := hasKeyIgnoringExpiration(cache, "test")
 
 	if !ok {
 		t.Error("Should not have been deleted")
 	}
 }
 
+func TestActiveExpirationCleanupRunsPeriodically(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	defer cache.stopCleanupBackgroundTask()
+
+	cache.Set("test", Data{
+		Value:     []byte("hello"),
+		ByteCount: 5,
+		ExpiresAt: timeSource.Now().Add(time.Millisecond * 50),
+	})
+
+	cache.RunExpireDataCleanupBackgroundTask(100)
+
+	timeSource.BlockUntil(1)
+
+	if !hasKeyIgnoringExpiration(cache, "test") {
+		t.Fatal("Should not have been deleted before it expired")
+	}
+
+	timeSource.Advance(time.Millisecond * 100)
+	timeSource.BlockUntil(1)
+
+	if hasKeyIgnoringExpiration(cache, "test") {
+		t.Error("Data should have been deleted on the next run")
+	}
+}
+
 func TestActiveExpirationCleanupStops(t *testing.T) {
-	cache := New(-1)
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
 
 	cache.RunExpireDataCleanupBackgroundTask(100)
 
-	time.Sleep(time.Duration(time.Millisecond * 500))
+	timeSource.BlockUntil(1)
 
 	cache.stopCleanupBackgroundTask()
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
-		ExpiresAt: time.Now(),
+		ExpiresAt: timeSource.Now(),
 	})
 
+	timeSource.Advance(time.Second)
+
 	// Avoid calling any public cache methods since they will check for expiration of the data and clear it if it's expired
-	_, ok := cache.lookupTable["test"]
+	ok := hasKeyIgnoringExpiration(cache, "test")
 
 	if !ok {
 		t.Error("Should not have been deleted")
@@ -79,6 +113,10 @@ func TestActiveExpirationCleanupStops(t *testing.T) {
 }
 
 func TestCleanupStressTest_AllItemsExpired(t *testing.T) {
+	// The next collection would otherwise wait for the heap to double the size of the items of this test, which is
+	// more than some machines have for the next stress test
+	t.Cleanup(runtime.GC)
+
 	cache := New(-1)
 	numEntries := 10_000_000
 
@@ -108,12 +146,16 @@ func TestCleanupStressTest_AllItemsExpired(t *testing.T) {
 		t.Errorf("Expected cache to be empty. Got size = %d\n", cache.Size())
 	}
 
-	if cache.accessList.Len() != 0 {
-		t.Errorf("Expected access list to be empty. Got size %d\n", cache.accessList.Len())
+	if evictionPolicyLength(cache) != 0 {
+		t.Errorf("Expected eviction policy to be empty. Got size %d\n", evictionPolicyLength(cache))
 	}
 }
 
 func TestCleanupStressTest_NoItemsExpired(t *testing.T) {
+	// The next collection would otherwise wait for the heap to double the size of the items of this test, which is
+	// more than some machines have for the next stress test
+	t.Cleanup(runtime.GC)
+
 	cache := New(-1)
 	numEntries := 10_000_000
 
@@ -140,7 +182,50 @@ func TestCleanupStressTest_NoItemsExpired(t *testing.T) {
 		t.Errorf("No entries should have been deleted. %d were deleted", numEntries-cache.Size())
 	}
 
-	if cache.accessList.Len() != numEntries {
-		t.Errorf("No entries should have been removed from the access list. %d were deleted", numEntries-cache.accessList.Len())
+	if evictionPolicyLength(cache) != numEntries {
+		t.Errorf("No entries should have been removed from the eviction policy. %d were deleted", numEntries-evictionPolicyLength(cache))
+	}
+}
+
+// hasKeyIgnoringExpiration returns whether the key is stored in the cache, even if it's expired
+func hasKeyIgnoringExpiration(cache *Cache, key string) bool {
+	s := cache.shardFor(key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.hasKey(key)
+}
+
+// evictionPolicyLength returns the total number of keys tracked by the eviction policies of all shards
+func evictionPolicyLength(cache *Cache) int {
+	length := 0
+
+	for _, s := range cache.shards {
+		s.mutex.Lock()
+		length += s.policy.Len()
+		s.mutex.Unlock()
+	}
+
+	return length
+}
+
+func TestCloseStopsCleanupTask(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.RunExpireDataCleanupBackgroundTask(100)
+	timeSource.BlockUntil(1)
+
+	cache.Close()
+	// Closing twice is a no-op
+	cache.Close()
+
+	if cache.stopCleanupTask != nil {
+		t.Fatalf("Unexpected cleanup task still running\n")
 	}
+
+	// The task can be started again after it's stopped
+	cache.RunExpireDataCleanupBackgroundTask(100)
+	cache.Close()
 }
@@ -3,7 +3,10 @@ package cache
 import (
 	"errors"
 	"math"
+	"memcached-server/utils"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -43,9 +46,9 @@ func TestGetEmptyKey(t *testing.T) {
 func TestSetEmptyKey(t *testing.T) {
 	cache := New(1)
 
-	err := cache.Set("", Data{
+	_, err := cache.Set("", Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	})
 
@@ -63,7 +66,7 @@ func TestSet(t *testing.T) {
 	cache := New(10)
 	cache.Set("test", Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	})
 
@@ -75,13 +78,13 @@ func TestSet(t *testing.T) {
 func TestSetOverrideExistingValue(t *testing.T) {
 	data1 := Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	}
 
 	data2 := Data{
 		Flags:     uint16(13),
-		Value:     "hi",
+		Value:     []byte("hi"),
 		ByteCount: 2,
 	}
 
@@ -91,6 +94,9 @@ func TestSetOverrideExistingValue(t *testing.T) {
 
 	val, _ := cache.Get("test")
 
+	// CAS unique values are assigned by the cache every time data is stored
+	data2.CasUnique = 2
+
 	if !reflect.DeepEqual(val, data2) {
 		t.Errorf("Unexpected value. Expected '%v', got '%v'\n", data2, val)
 	}
@@ -99,7 +105,7 @@ func TestSetOverrideExistingValue(t *testing.T) {
 func TestSetAndGet(t *testing.T) {
 	data := Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	}
 	cache := New(10)
@@ -110,6 +116,8 @@ func TestSetAndGet(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	data.CasUnique = 1
+
 	if !reflect.DeepEqual(value, data) {
 		t.Fatalf("Incorrect value. Expected '%s', got '%s'\n", "test", "val")
 	}
@@ -119,7 +127,7 @@ func TestBasicDelete(t *testing.T) {
 	cache := New(10)
 	cache.Set("test", Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	})
 
@@ -138,14 +146,16 @@ func TestDeleteNoMatch(t *testing.T) {
 	cache := New(10)
 	cache.Set("key", Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	})
 
 	deleteErr := cache.Delete("key1")
 
-	if deleteErr != nil {
-		t.Fatal(deleteErr)
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(deleteErr, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(deleteErr))
 	}
 
 	if cache.Size() != 1 {
@@ -182,22 +192,22 @@ func TestUnboundedCapacity(t *testing.T) {
 func TestEviction(t *testing.T) {
 	data1 := Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 	}
 	data2 := Data{
 		Flags:     uint16(13),
-		Value:     "hi",
+		Value:     []byte("hi"),
 		ByteCount: 2,
 	}
 	data3 := Data{
 		Flags:     uint16(9),
-		Value:     "hey",
+		Value:     []byte("hey"),
 		ByteCount: 3,
 	}
 	data4 := Data{
 		Flags:     uint16(999),
-		Value:     "yes",
+		Value:     []byte("yes"),
 		ByteCount: 3,
 	}
 
@@ -217,6 +227,8 @@ func TestEviction(t *testing.T) {
 	val4, _ := cache.Get("key4")
 
 	expectedErr := &KeyNotFoundError{}
+	data3.CasUnique = 3
+	data4.CasUnique = 4
 
 	if !errors.As(err1, &expectedErr) {
 		t.Errorf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err1))
@@ -238,12 +250,12 @@ func TestEviction(t *testing.T) {
 func TestAdd(t *testing.T) {
 	cache := New(-1)
 	data := Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 	}
 
-	err := cache.Add("test", data)
+	_, err := cache.Add("test", data)
 
 	if err != nil {
 		t.Fatal(err)
@@ -255,6 +267,8 @@ func TestAdd(t *testing.T) {
 		t.Fatalf("Error getting stored value: %v\n", err)
 	}
 
+	data.CasUnique = 1
+
 	if !reflect.DeepEqual(data, cachedData) {
 		t.Errorf("Unexpected value. Expected '%v', got '%v'\n", data, cachedData)
 	}
@@ -264,7 +278,7 @@ func TestAdd_KeyAlreadyExists(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{})
-	err := cache.Add("test", Data{})
+	_, err := cache.Add("test", Data{})
 
 	expectedErr := &KeyAlreadyExistsError{}
 
@@ -276,13 +290,13 @@ func TestAdd_KeyAlreadyExists(t *testing.T) {
 func TestReplace(t *testing.T) {
 	cache := New(-1)
 	data := Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 	}
 
 	cache.Set("test", Data{})
-	err := cache.Replace("test", data)
+	_, err := cache.Replace("test", data)
 
 	if err != nil {
 		t.Fatal(err)
@@ -294,6 +308,8 @@ func TestReplace(t *testing.T) {
 		t.Fatalf("Error getting stored value: %v\n", err)
 	}
 
+	data.CasUnique = 2
+
 	if !reflect.DeepEqual(data, cachedData) {
 		t.Errorf("Unexpected value. Expected '%v', got '%v'\n", data, cachedData)
 	}
@@ -302,7 +318,7 @@ func TestReplace(t *testing.T) {
 func TestReplace_KeyDoesntExist(t *testing.T) {
 	cache := New(-1)
 
-	err := cache.Replace("test", Data{})
+	_, err := cache.Replace("test", Data{})
 
 	expectedErr := &KeyNotFoundError{}
 
@@ -315,13 +331,13 @@ func TestAppend(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 	})
 
-	err := cache.Append("test", Data{
-		Value:     ", world!",
+	_, err := cache.Append("test", Data{
+		Value:     []byte(", world!"),
 		ByteCount: 8,
 		Flags:     uint16(2),
 	})
@@ -337,9 +353,10 @@ func TestAppend(t *testing.T) {
 	}
 
 	expected := Data{
-		Value:     "hello, world!",
+		Value:     []byte("hello, world!"),
 		ByteCount: 13,
 		Flags:     uint16(1),
+		CasUnique: 2,
 	}
 
 	if !reflect.DeepEqual(expected, cachedData) {
@@ -350,7 +367,7 @@ func TestAppend(t *testing.T) {
 func TestAppend_KeyDoesntExist(t *testing.T) {
 	cache := New(-1)
 
-	err := cache.Append("test", Data{})
+	_, err := cache.Append("test", Data{})
 
 	expectedErr := &KeyNotFoundError{}
 
@@ -363,13 +380,13 @@ func TestPrepend(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{
-		Value:     "hi!",
+		Value:     []byte("hi!"),
 		ByteCount: 3,
 		Flags:     uint16(1),
 	})
 
-	err := cache.Prepend("test", Data{
-		Value:     "hello ",
+	_, err := cache.Prepend("test", Data{
+		Value:     []byte("hello "),
 		ByteCount: 6,
 		Flags:     uint16(2),
 	})
@@ -385,9 +402,10 @@ func TestPrepend(t *testing.T) {
 	}
 
 	expected := Data{
-		Value:     "hello hi!",
+		Value:     []byte("hello hi!"),
 		ByteCount: 9,
 		Flags:     uint16(1),
+		CasUnique: 2,
 	}
 
 	if !reflect.DeepEqual(expected, cachedData) {
@@ -398,7 +416,7 @@ func TestPrepend(t *testing.T) {
 func TestPrepend_KeyDoesntExist(t *testing.T) {
 	cache := New(-1)
 
-	err := cache.Prepend("test", Data{})
+	_, err := cache.Prepend("test", Data{})
 
 	expectedErr := &KeyNotFoundError{}
 
@@ -411,13 +429,13 @@ func TestKeyExpiration_Get(t *testing.T) {
 	cache := New(-1)
 	key := "test"
 	data := Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(0),
 		ExpiresAt: time.UnixMilli(1),
 	}
 
-	err := cache.Set(key, data)
+	_, err := cache.Set(key, data)
 
 	if err != nil {
 		t.Fatal(err)
@@ -440,19 +458,19 @@ func TestKeyExpiration_Add(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 		ExpiresAt: time.UnixMilli(1),
 	})
 
 	data := Data{
-		Value:     "hi",
+		Value:     []byte("hi"),
 		ByteCount: 2,
 		Flags:     uint16(2),
 	}
 
-	err := cache.Add("test", data)
+	_, err := cache.Add("test", data)
 
 	if err != nil {
 		t.Fatal(err)
@@ -464,6 +482,8 @@ func TestKeyExpiration_Add(t *testing.T) {
 		t.Fatalf("Error getting stored value: %v\n", err)
 	}
 
+	data.CasUnique = 2
+
 	if !reflect.DeepEqual(data, cachedData) {
 		t.Errorf("Unexpected value. Expected '%v', got '%v'\n", data, cachedData)
 	}
@@ -472,14 +492,14 @@ func TestKeyExpiration_Append(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 		ExpiresAt: time.UnixMilli(1),
 	})
 
-	err := cache.Append("test", Data{
-		Value:     ", world!",
+	_, err := cache.Append("test", Data{
+		Value:     []byte(", world!"),
 		ByteCount: 8,
 		Flags:     uint16(2),
 	})
@@ -499,14 +519,14 @@ func TestKeyExpiration_Prepend(t *testing.T) {
 	cache := New(-1)
 
 	cache.Set("test", Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 		ExpiresAt: time.UnixMilli(1),
 	})
 
-	err := cache.Prepend("test", Data{
-		Value:     ", world!",
+	_, err := cache.Prepend("test", Data{
+		Value:     []byte(", world!"),
 		ByteCount: 8,
 		Flags:     uint16(2),
 	})
@@ -525,14 +545,14 @@ func TestKeyExpiration_Prepend(t *testing.T) {
 func TestKeyExpiration_Replace(t *testing.T) {
 	cache := New(-1)
 	data := Data{
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		Flags:     uint16(1),
 		ExpiresAt: time.UnixMilli(1),
 	}
 
 	cache.Set("test", data)
-	err := cache.Replace("test", data)
+	_, err := cache.Replace("test", data)
 
 	if err == nil {
 		t.Fatal("Expected error")
@@ -549,18 +569,802 @@ func TestKeyExpiration_Delete(t *testing.T) {
 	cache := New(10)
 	cache.Set("test", Data{
 		Flags:     uint16(32),
-		Value:     "hello",
+		Value:     []byte("hello"),
 		ByteCount: 5,
 		ExpiresAt: time.UnixMilli(1),
 	})
 
+	// Expired keys are deleted, but reported as not found same as memcached
 	err := cache.Delete("test")
 
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	if cache.Size() != 0 {
+		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 0, cache.Size())
+	}
+}
+
+func TestCasUniqueIncreases(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+	first, _ := cache.Get("key1")
+
+	cache.Set("key2", Data{Value: []byte("hi"), ByteCount: 2})
+	cache.Append("key1", Data{Value: []byte("!"), ByteCount: 1})
+	second, _ := cache.Get("key1")
+
+	if first.CasUnique == 0 {
+		t.Error("Expected CAS unique to be assigned")
+	}
+
+	if second.CasUnique <= first.CasUnique {
+		t.Errorf("Expected CAS unique to increase. First: %d, second: %d\n", first.CasUnique, second.CasUnique)
+	}
+}
+
+func TestStoreReturnsCasUnique(t *testing.T) {
+	cache := New(-1)
+
+	stores := []func() (uint64, error){
+		func() (uint64, error) { return cache.Set("counter", Data{Value: []byte("1"), ByteCount: 1}) },
+		func() (uint64, error) { return cache.Append("counter", Data{Value: []byte("0"), ByteCount: 1}) },
+		func() (uint64, error) { return cache.Replace("counter", Data{Value: []byte("2"), ByteCount: 1}) },
+		func() (uint64, error) {
+			_, casUnique, err := cache.Increment("counter", 1)
+			return casUnique, err
+		},
+	}
+
+	for i, store := range stores {
+		casUnique, err := store()
+
+		if err != nil {
+			t.Fatalf("Store %d failed: %v\n", i, err)
+		}
+
+		cachedData, _ := cache.Peek("counter")
+
+		if casUnique == 0 || casUnique != cachedData.CasUnique {
+			t.Errorf("Store %d returned CAS unique %d, cached data has %d\n", i, casUnique, cachedData.CasUnique)
+		}
+	}
+}
+
+func TestCas(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+	cachedData, _ := cache.Get("test")
+
+	_, err := cache.Cas("test", Data{Value: []byte("hi"), ByteCount: 2}, cachedData.CasUnique)
+
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	cachedData, _ = cache.Get("test")
+
+	if string(cachedData.Value) != "hi" {
+		t.Errorf("Unexpected value: '%s'\n", cachedData.Value)
+	}
+}
+
+func TestCas_Mismatch(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+	cachedData, _ := cache.Get("test")
+	cache.Set("test", Data{Value: []byte("hey"), ByteCount: 3})
+
+	_, err := cache.Cas("test", Data{Value: []byte("hi"), ByteCount: 2}, cachedData.CasUnique)
+
+	expectedErr := &CasMismatchError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	cachedData, _ = cache.Get("test")
+
+	if string(cachedData.Value) != "hey" {
+		t.Errorf("Value should not have been updated. Got '%s'\n", cachedData.Value)
+	}
+}
+
+func TestCas_KeyDoesntExist(t *testing.T) {
+	cache := New(-1)
+
+	_, err := cache.Cas("test", Data{}, 1)
+
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+	cachedData, _ := cache.Get("test")
+
+	if err := cache.CompareAndDelete("test", cachedData.CasUnique); err != nil {
+		t.Fatal(err)
+	}
+
 	if cache.Size() != 0 {
-		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 0, cache.Size())
+		t.Errorf("Expected key to be deleted. Got size = %d\n", cache.Size())
+	}
+}
+
+func TestCompareAndDelete_Mismatch(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+	cachedData, _ := cache.Get("test")
+	cache.Set("test", Data{Value: []byte("hey"), ByteCount: 3})
+
+	err := cache.CompareAndDelete("test", cachedData.CasUnique)
+
+	expectedErr := &CasMismatchError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	if _, err := cache.Get("test"); err != nil {
+		t.Errorf("Key should not have been deleted. Got %v\n", err)
+	}
+}
+
+func TestCompareAndDelete_KeyDoesntExist(t *testing.T) {
+	cache := New(-1)
+
+	err := cache.CompareAndDelete("test", 1)
+
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{
+		Value:     []byte("10"),
+		ByteCount: 2,
+		Flags:     uint16(3),
+	})
+
+	value, _, err := cache.Increment("counter", 95)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 105 {
+		t.Errorf("Unexpected value. Expected %d, got %d\n", 105, value)
+	}
+
+	cachedData, _ := cache.Get("counter")
+
+	if string(cachedData.Value) != "105" || cachedData.ByteCount != 3 || cachedData.Flags != 3 {
+		t.Errorf("Invalid data: %v\n", cachedData)
+	}
+}
+
+func TestIncrement_Overflow(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{Value: []byte("18446744073709551615"), ByteCount: 20})
+
+	value, _, err := cache.Increment("counter", 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 1 {
+		t.Errorf("Expected value to wrap around to %d, got %d\n", 1, value)
+	}
+}
+
+func TestIncrement_KeyDoesntExist(t *testing.T) {
+	cache := New(-1)
+
+	_, _, err := cache.Increment("counter", 1)
+
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+}
+
+func TestIncrement_NonNumericValue(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{Value: []byte("hello"), ByteCount: 5})
+
+	_, _, err := cache.Increment("counter", 1)
+
+	expectedErr := &NonNumericValueError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+}
+
+func TestDecrement(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{Value: []byte("100"), ByteCount: 3})
+
+	value, _, err := cache.Decrement("counter", 95)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 5 {
+		t.Errorf("Unexpected value. Expected %d, got %d\n", 5, value)
+	}
+
+	cachedData, _ := cache.Get("counter")
+
+	if string(cachedData.Value) != "5" || cachedData.ByteCount != 1 {
+		t.Errorf("Invalid data: %v\n", cachedData)
+	}
+}
+
+func TestDecrement_Underflow(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{Value: []byte("5"), ByteCount: 1})
+
+	value, _, err := cache.Decrement("counter", 6)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 0 {
+		t.Errorf("Expected value to be %d, got %d\n", 0, value)
+	}
+}
+
+func TestKeyExpiration_Increment(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("counter", Data{
+		Value:     []byte("1"),
+		ByteCount: 1,
+		ExpiresAt: time.UnixMilli(1),
+	})
+
+	_, _, err := cache.Increment("counter", 1)
+
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	cache := New(-1, WithShards(8))
+
+	if len(cache.shards) != 8 {
+		t.Fatalf("Expected %d shards, got %d\n", 8, len(cache.shards))
+	}
+
+	for i := range 100 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte(strconv.Itoa(i))})
+	}
+
+	if cache.Size() != 100 {
+		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 100, cache.Size())
+	}
+
+	for i := range 100 {
+		data, err := cache.Get(strconv.Itoa(i))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data.Value) != strconv.Itoa(i) {
+			t.Errorf("Unexpected value. Expected '%d', got '%s'\n", i, data.Value)
+		}
+	}
+
+	for _, s := range cache.shards {
+		if s.Size() == 0 {
+			t.Error("Expected keys to be distributed across all shards")
+		}
+	}
+}
+
+func TestShardedCacheCapacity(t *testing.T) {
+	cache := New(10, WithShards(4))
+
+	if cache.Capacity != 10 {
+		t.Fatalf("Expected capacity to be %d, got capacity = %d\n", 10, cache.Capacity)
+	}
+
+	totalCapacity := 0
+	for _, s := range cache.shards {
+		totalCapacity += s.capacity
+	}
+
+	if totalCapacity != 10 {
+		t.Errorf("Expected shard capacities to add up to %d, got %d\n", 10, totalCapacity)
+	}
+
+	for i := range 100 {
+		cache.Set(strconv.Itoa(i), Data{})
+	}
+
+	if cache.Size() > 10 {
+		t.Errorf("Cache size exceeds capacity. Expected at most %d, got %d\n", 10, cache.Size())
+	}
+}
+
+func TestNumberOfShardsLimitedByCapacity(t *testing.T) {
+	cache := New(2, WithShards(8))
+
+	if len(cache.shards) != 2 {
+		t.Errorf("Expected %d shards, got %d\n", 2, len(cache.shards))
+	}
+}
+
+func TestCasUniqueSharedAcrossShards(t *testing.T) {
+	cache := New(-1, WithShards(8))
+	seen := map[uint64]bool{}
+
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		cache.Set(key, Data{})
+		data, _ := cache.Get(key)
+
+		if seen[data.CasUnique] {
+			t.Fatalf("CAS unique %d assigned more than once\n", data.CasUnique)
+		}
+
+		seen[data.CasUnique] = true
+	}
+}
+
+func TestMemoryLimitEviction(t *testing.T) {
+	// Enough memory for 3 items with 10 byte values and 4 byte keys
+	cache := New(-1, WithMemoryLimit(3*(4+10+itemOverhead)))
+	value := "0123456789"
+
+	cache.Set("key1", Data{Value: []byte(value), ByteCount: 10})
+	cache.Set("key2", Data{Value: []byte(value), ByteCount: 10})
+	cache.Set("key3", Data{Value: []byte(value), ByteCount: 10})
+	cache.Get("key1")
+	cache.Set("key4", Data{Value: []byte(value), ByteCount: 10}) // key2 should be evicted after this operation
+
+	if cache.Size() != 3 {
+		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 3, cache.Size())
+	}
+
+	_, err := cache.Get("key2")
+
+	expectedErr := &KeyNotFoundError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Errorf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	for _, key := range []string{"key1", "key3", "key4"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Errorf("Expected %s to be cached. Got error: %v\n", key, err)
+		}
+	}
+}
+
+func TestMemoryLimitEvictsMultipleItems(t *testing.T) {
+	cache := New(-1, WithMemoryLimit(3*(4+10+itemOverhead)))
+
+	cache.Set("key1", Data{Value: []byte("0123456789"), ByteCount: 10})
+	cache.Set("key2", Data{Value: []byte("0123456789"), ByteCount: 10})
+	cache.Set("key3", Data{Value: []byte("0123456789"), ByteCount: 10})
+
+	// Takes up the space of two of the other items
+	largeValue := strings.Repeat("a", 10+4+itemOverhead+10)
+	cache.Set("key4", Data{Value: []byte(largeValue), ByteCount: len(largeValue)})
+
+	if cache.Size() != 2 {
+		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 2, cache.Size())
+	}
+
+	if _, err := cache.Get("key3"); err != nil {
+		t.Errorf("Expected key3 to be cached. Got error: %v\n", err)
+	}
+}
+
+func TestMemoryLimitOverwriteGrowsItem(t *testing.T) {
+	cache := New(-1, WithMemoryLimit(2*(4+10+itemOverhead)))
+
+	cache.Set("key1", Data{Value: []byte("0123456789"), ByteCount: 10})
+	cache.Set("key2", Data{Value: []byte("0123456789"), ByteCount: 10})
+	cache.Append("key2", Data{Value: []byte("a"), ByteCount: 1}) // key1 should be evicted to make room
+
+	if cache.Size() != 1 {
+		t.Fatalf("Incorrect cache size. Expected: %d, got: %d\n", 1, cache.Size())
+	}
+
+	data, err := cache.Get("key2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data.Value) != "0123456789a" {
+		t.Errorf("Unexpected value: '%s'\n", data.Value)
+	}
+}
+
+func TestMemoryLimitOverwriteEvictsItself(t *testing.T) {
+	cache := New(-1, WithEvictionPolicy(EvictionPolicyLFU), WithMemoryLimit(2*(4+10+itemOverhead)))
+	var mutations []Mutation
+
+	cache.Set("key1", Data{Value: []byte("0123456789"), ByteCount: 10})
+	cache.Set("key2", Data{Value: []byte("0123456789"), ByteCount: 10})
+
+	for range 5 {
+		cache.Get("key1")
+	}
+
+	cache.AddMutationListener(func(mutation Mutation) {
+		mutations = append(mutations, mutation)
+	})
+
+	// key2 is still the least frequently used key, so it's evicted instead of key1
+	_, err := cache.Append("key2", Data{Value: []byte("a"), ByteCount: 1})
+
+	expectedErr := &ItemTooLargeError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	if _, err := cache.Get("key2"); err == nil {
+		t.Error("Expected key2 to be evicted")
+	}
+
+	if len(mutations) != 1 || mutations[0].Type != MutationDelete || mutations[0].Key != "key2" {
+		t.Errorf("Expected only the eviction of key2 to be sent to listeners. Got %+v\n", mutations)
+	}
+}
+
+func TestMemoryUsageTracking(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("hello")})
+	cache.Set("key2", Data{Value: []byte("hi")})
+	cache.Set("key1", Data{Value: []byte("hey")})
+	cache.Delete("key2")
+
+	expected := int64(len("key1") + len("hey") + itemOverhead)
+
+	if cache.shards[0].usedBytes != expected {
+		t.Errorf("Unexpected memory usage. Expected %d bytes, got %d bytes\n", expected, cache.shards[0].usedBytes)
+	}
+}
+
+func TestItemTooLarge(t *testing.T) {
+	cache := New(-1, WithMemoryLimit(100))
+
+	cache.Set("test", Data{Value: []byte("hello"), ByteCount: 5})
+
+	value := strings.Repeat("a", 100)
+	_, err := cache.Set("test", Data{Value: []byte(value), ByteCount: len(value)})
+
+	expectedErr := &ItemTooLargeError{}
+
+	if !errors.As(err, &expectedErr) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(expectedErr), reflect.TypeOf(err))
+	}
+
+	// The previous value is removed, same as memcached
+	if cache.Size() != 0 {
+		t.Errorf("Incorrect cache size. Expected: %d, got: %d\n", 0, cache.Size())
+	}
+}
+
+func TestShardedMemoryLimit(t *testing.T) {
+	cache := New(-1, WithShards(4), WithMemoryLimit(4_000))
+
+	for _, s := range cache.shards {
+		if s.memoryLimit != 1_000 {
+			t.Errorf("Expected shard memory limit to be %d, got %d\n", 1_000, s.memoryLimit)
+		}
+	}
+
+	for i := range 1_000 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("0123456789"), ByteCount: 10})
+	}
+
+	for _, s := range cache.shards {
+		if s.usedBytes > s.memoryLimit {
+			t.Errorf("Shard exceeds memory limit. Limit: %d, used: %d\n", s.memoryLimit, s.usedBytes)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache := New(2)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+	cache.Set("key2", Data{Value: []byte("hello"), ByteCount: 5})
+	cache.Set("key2", Data{Value: []byte("hi"), ByteCount: 2})
+	cache.Get("key1")
+	cache.Set("key3", Data{Value: []byte("hey"), ByteCount: 3}) // key2 is evicted without being fetched
+	cache.Set("key4", Data{Value: []byte("hey"), ByteCount: 3}) // key1 is evicted after being fetched
+
+	expected := Stats{
+		CurrItems:        2,
+		TotalItems:       5,
+		Bytes:            itemSize("key3", Data{Value: []byte("hey")}) * 2,
+		Evictions:        2,
+		EvictedUnfetched: 1,
+	}
+
+	if !reflect.DeepEqual(cache.Stats(), expected) {
+		t.Fatalf("Unexpected stats. Expected %+v, got %+v\n", expected, cache.Stats())
+	}
+}
+
+func TestStats_ExpiredUnfetched(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithShards(4), WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Second)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ExpiresAt: expiresAt})
+	cache.Set("key2", Data{Value: []byte("hello"), ExpiresAt: expiresAt})
+	cache.Get("key1")
+
+	timeSource.Advance(time.Second * 2)
+
+	cache.clearExpiredData()
+
+	if cache.Stats().ExpiredUnfetched != 1 {
+		t.Fatalf("Unexpected expired unfetched count. Expected 1, got %d\n", cache.Stats().ExpiredUnfetched)
+	}
+}
+
+func TestPeek_NotCountedAsFetch(t *testing.T) {
+	cache := New(1)
+
+	cache.Set("key1", Data{Value: []byte("hello")})
+	cache.Peek("key1")
+	cache.Set("key2", Data{Value: []byte("hello")})
+
+	if cache.Stats().EvictedUnfetched != 1 {
+		t.Fatalf("Unexpected evicted unfetched count. Expected 1, got %d\n", cache.Stats().EvictedUnfetched)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	cache.Set("test", Data{Value: []byte("hello"), ExpiresAt: timeSource.Now().Add(time.Second)})
+	casUnique := getCasUnique(t, cache, "test")
+
+	if err := cache.Touch("test", time.UnixMilli(0)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	timeSource.Advance(time.Second * 2)
+
+	data, err := cache.Get("test")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if data.CasUnique != casUnique {
+		t.Errorf("CAS unique should not change when touching a key. Expected %d, got %d\n", casUnique, data.CasUnique)
+	}
+}
+
+func TestTouch_KeyDoesntExist(t *testing.T) {
+	cache := New(-1)
+
+	err := cache.Touch("test", time.UnixMilli(0))
+
+	target := &KeyNotFoundError{}
+	if !errors.As(err, &target) {
+		t.Fatalf("Unexpected error type. Expected %v, got %v\n", reflect.TypeOf(target), reflect.TypeOf(err))
+	}
+}
+
+func TestGetAndTouch(t *testing.T) {
+	cache := New(-1)
+	cache.Set("test", Data{Value: []byte("hello")})
+	expiresAt := time.UnixMilli(1)
+
+	data, err := cache.GetAndTouch("test", expiresAt)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if string(data.Value) != "hello" || !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected data: %+v\n", data)
+	}
+
+	if _, err := cache.Get("test"); err == nil {
+		t.Fatal("Expected key to be expired")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithShards(4), WithTimeSource(timeSource))
+
+	for i := range 10 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello")})
+	}
+
+	cache.Flush(0)
+
+	for i := range 10 {
+		if _, err := cache.Get(strconv.Itoa(i)); err == nil {
+			t.Fatalf("Expected key %d to be flushed\n", i)
+		}
+	}
+
+	// Keys stored at the same time as the flush are flushed too
+	timeSource.Advance(time.Nanosecond)
+	cache.Set("new", Data{Value: []byte("hello")})
+
+	if _, err := cache.Get("new"); err != nil {
+		t.Fatalf("Keys stored after a flush should not be affected. Got error: %v\n", err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithShards(4), WithTimeSource(timeSource))
+
+	for i := range 10 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello")})
+	}
+
+	cache.Clear()
+
+	if cache.Size() != 0 || cache.Stats().Bytes != 0 {
+		t.Fatalf("Expected cache to be empty. Got size = %d\n", cache.Size())
+	}
+
+	// Unlike Flush, keys stored at the same time are not affected
+	cache.Set("new", Data{Value: []byte("hello")})
+
+	if _, err := cache.Get("new"); err != nil {
+		t.Fatalf("Keys stored after clearing the cache should not be affected. Got error: %v\n", err)
+	}
+}
+
+func TestFlush_Delayed(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	cache.Set("before", Data{Value: []byte("hello")})
+
+	cache.Flush(time.Second)
+	timeSource.Advance(time.Millisecond * 500)
+	cache.Set("during", Data{Value: []byte("hello")})
+
+	if _, err := cache.Get("before"); err != nil {
+		t.Fatalf("Keys should not be flushed before the delay. Got error: %v\n", err)
+	}
+
+	timeSource.Advance(time.Millisecond * 500)
+
+	for _, key := range []string{"before", "during"} {
+		if _, err := cache.Get(key); err == nil {
+			t.Fatalf("Expected key %s to be flushed\n", key)
+		}
+	}
+
+	if _, err := cache.Add("before", Data{Value: []byte("hello")}); err != nil {
+		t.Fatalf("Flushed keys should be treated as missing. Got error: %v\n", err)
+	}
+}
+
+func TestPendingFlush(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	if _, pending := cache.PendingFlush(); pending {
+		t.Fatal("Unexpected pending flush before flushing")
+	}
+
+	cache.Flush(time.Second)
+	flushAt, pending := cache.PendingFlush()
+
+	if !pending || !flushAt.Equal(timeSource.Now().Add(time.Second)) {
+		t.Fatalf("Unexpected pending flush: %v, %v\n", flushAt, pending)
+	}
+
+	timeSource.Advance(time.Second)
+
+	if _, pending := cache.PendingFlush(); pending {
+		t.Fatal("Flush should no longer be pending once it took effect")
+	}
+}
+
+func newFakeTimeSource() *utils.FakeTimeSource {
+	return &utils.FakeTimeSource{FixedTime: time.Unix(1_700_000_000, 0)}
+}
+
+func getCasUnique(t *testing.T, cache *Cache, key string) uint64 {
+	data, err := cache.Peek(key)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	return data.CasUnique
+}
+
+func TestExpirationTime(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cache := New(-1, WithTimeSource(&utils.FakeTimeSource{FixedTime: now}))
+
+	testCases := map[int]time.Time{
+		0:             time.UnixMilli(0),
+		-1:            time.UnixMilli(1),
+		100:           now.Add(time.Second * 100),
+		2_592_000:     now.Add(time.Second * 2_592_000),
+		2_592_001:     time.Unix(2_592_001, 0),
+		1_700_000_100: time.Unix(1_700_000_100, 0),
+	}
+
+	for exptime, expected := range testCases {
+		if actual := cache.ExpirationTime(exptime); !actual.Equal(expected) {
+			t.Errorf("Unexpected expiration time for %d. Expected %v, got %v\n", exptime, expected, actual)
+		}
+	}
+}
+
+func TestExpirationTime_Expiry(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.Set("negative", Data{Value: []byte("hello"), ExpiresAt: cache.ExpirationTime(-1)})
+	cache.Set("absolutePast", Data{Value: []byte("hello"), ExpiresAt: cache.ExpirationTime(1_699_999_999)})
+	cache.Set("absoluteFuture", Data{Value: []byte("hello"), ExpiresAt: cache.ExpirationTime(1_700_000_100)})
+	cache.Set("relative", Data{Value: []byte("hello"), ExpiresAt: cache.ExpirationTime(100)})
+
+	for _, key := range []string{"negative", "absolutePast"} {
+		if _, err := cache.Get(key); err == nil {
+			t.Errorf("Expected key %s to be expired\n", key)
+		}
+	}
+
+	for _, key := range []string{"absoluteFuture", "relative"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Errorf("Unexpected error for key %s: %v\n", key, err)
+		}
+	}
+
+	timeSource.Advance(time.Second * 101)
+
+	for _, key := range []string{"absoluteFuture", "relative"} {
+		if _, err := cache.Get(key); err == nil {
+			t.Errorf("Expected key %s to be expired\n", key)
+		}
 	}
 }
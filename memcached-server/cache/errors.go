@@ -23,3 +23,52 @@ type EmptyKeyError struct{}
 func (e *EmptyKeyError) Error() string {
 	return "key must have a length greater than 0"
 }
+
+type CasMismatchError struct {
+	Key string
+}
+
+func (e *CasMismatchError) Error() string {
+	return fmt.Sprintf("data has been modified since it was fetched: %s", e.Key)
+}
+
+type NonNumericValueError struct {
+	Key string
+}
+
+func (e *NonNumericValueError) Error() string {
+	return fmt.Sprintf("cannot increment or decrement non-numeric value: %s", e.Key)
+}
+
+type InvalidSnapshotError struct {
+	Reason string
+}
+
+func (e *InvalidSnapshotError) Error() string {
+	return fmt.Sprintf("invalid snapshot: %s", e.Reason)
+}
+
+type UnsupportedSnapshotVersionError struct {
+	Version uint16
+}
+
+func (e *UnsupportedSnapshotVersionError) Error() string {
+	return fmt.Sprintf("unsupported snapshot version: %d", e.Version)
+}
+
+type InvalidAppendOnlyLogError struct {
+	Reason string
+}
+
+func (e *InvalidAppendOnlyLogError) Error() string {
+	return fmt.Sprintf("invalid append-only log: %s", e.Reason)
+}
+
+type ItemTooLargeError struct {
+	Key  string
+	Size int64
+}
+
+func (e *ItemTooLargeError) Error() string {
+	return fmt.Sprintf("object too large for cache: %s (%d bytes)", e.Key, e.Size)
+}
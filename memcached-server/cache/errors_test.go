@@ -23,3 +23,51 @@ func TestEmptyKeyError(t *testing.T) {
 		t.Errorf("Unexpected error message: '%s'\n", err.Error())
 	}
 }
+
+func TestCasMismatchError(t *testing.T) {
+	err := CasMismatchError{Key: "key1"}
+
+	if err.Error() != "data has been modified since it was fetched: key1" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
+
+func TestNonNumericValueError(t *testing.T) {
+	err := NonNumericValueError{Key: "key1"}
+
+	if err.Error() != "cannot increment or decrement non-numeric value: key1" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
+
+func TestItemTooLargeError(t *testing.T) {
+	err := ItemTooLargeError{Key: "key1", Size: 2048}
+
+	if err.Error() != "object too large for cache: key1 (2048 bytes)" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
+
+func TestInvalidSnapshotError(t *testing.T) {
+	err := InvalidSnapshotError{Reason: "missing header"}
+
+	if err.Error() != "invalid snapshot: missing header" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
+
+func TestUnsupportedSnapshotVersionError(t *testing.T) {
+	err := UnsupportedSnapshotVersionError{Version: 2}
+
+	if err.Error() != "unsupported snapshot version: 2" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
+
+func TestInvalidAppendOnlyLogError(t *testing.T) {
+	err := InvalidAppendOnlyLogError{Reason: "missing header"}
+
+	if err.Error() != "invalid append-only log: missing header" {
+		t.Errorf("Unexpected error message: '%s'\n", err.Error())
+	}
+}
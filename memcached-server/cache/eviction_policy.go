@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+)
+
+var InvalidEvictionPolicyError = errors.New("invalid eviction policy")
+
+// EvictionPolicy algorithm used to pick which keys are evicted when the cache is full
+type EvictionPolicy string
+
+const EvictionPolicyLRU EvictionPolicy = "lru"
+const EvictionPolicyLFU EvictionPolicy = "lfu"
+const EvictionPolicyARC EvictionPolicy = "arc"
+const EvictionPolicyTinyLFU EvictionPolicy = "tinylfu"
+
+// ParseEvictionPolicy returns the eviction policy matching name (case-insensitive). Returns InvalidEvictionPolicyError if
+// there's no such policy
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	policy := EvictionPolicy(strings.ToLower(name))
+
+	switch policy {
+	case EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyARC, EvictionPolicyTinyLFU:
+		return policy, nil
+	}
+
+	return "", InvalidEvictionPolicyError
+}
+
+// evictionPolicy keeps track of the keys stored in a shard and decides which one to evict when the shard is full.
+// Implementations aren't safe for concurrent use. They're guarded by the lock of the shard that owns them
+type evictionPolicy interface {
+	// Insert records a key that was added to the shard
+	Insert(key string)
+	// Access records a read or update of a key that's already in the shard
+	Access(key string)
+	// Remove forgets a key that is no longer in the shard, either because it was deleted or evicted
+	Remove(key string)
+	// Victim returns the key that should be evicted next. Returns false if there are no keys
+	Victim() (string, bool)
+	// Len returns the number of keys tracked
+	Len() int
+}
+
+// newEvictionPolicy creates an instance of the policy for a shard that holds up to capacity keys
+func newEvictionPolicy(policy EvictionPolicy, capacity int) evictionPolicy {
+	switch policy {
+	case EvictionPolicyLFU:
+		return newLFUEvictionPolicy()
+	case EvictionPolicyARC:
+		return newARCEvictionPolicy(capacity)
+	case EvictionPolicyTinyLFU:
+		return newTinyLFUEvictionPolicy(capacity)
+	default:
+		return newLRUEvictionPolicy()
+	}
+}
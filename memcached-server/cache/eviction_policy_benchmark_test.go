@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Run with `go test -bench HitRatio -run ^$ ./cache/` to compare the hit ratio of each eviction policy. Each request
+// reads a key and sets it on a miss, like a read-through cache would
+
+const numHitRatioKeys = 100_000
+
+const hitRatioCapacity = 1_000
+
+// Exponents of the Zipfian distributions. Higher values make the most popular keys account for more of the requests
+var zipfExponents = []float64{1.01, 1.2}
+
+// BenchmarkHitRatio replays the same Zipfian trace against each policy and reports the share of reads that were hits
+func BenchmarkHitRatio(b *testing.B) {
+	for _, s := range zipfExponents {
+		for _, policy := range allEvictionPolicies {
+			b.Run(fmt.Sprintf("zipf=%.2f/policy=%s", s, policy), func(b *testing.B) {
+				zipf := rand.NewZipf(rand.New(rand.NewSource(1)), s, 1, numHitRatioKeys-1)
+				cache := New(hitRatioCapacity, WithEvictionPolicy(policy))
+				hits := 0
+
+				b.ResetTimer()
+
+				for range b.N {
+					key := strconv.FormatUint(zipf.Uint64(), 10)
+
+					if _, err := cache.Get(key); err == nil {
+						hits++
+					} else {
+						cache.Set(key, Data{Value: []byte("value"), ByteCount: 5})
+					}
+				}
+
+				b.ReportMetric(float64(hits)/float64(b.N)*100, "hit%")
+			})
+		}
+	}
+}
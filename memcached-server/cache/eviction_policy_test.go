@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+var allEvictionPolicies = []EvictionPolicy{EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyARC, EvictionPolicyTinyLFU}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	policy, err := ParseEvictionPolicy("TinyLFU")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if policy != EvictionPolicyTinyLFU {
+		t.Fatalf("Unexpected policy. Expected %s, got %s\n", EvictionPolicyTinyLFU, policy)
+	}
+}
+
+func TestParseEvictionPolicy_Invalid(t *testing.T) {
+	_, err := ParseEvictionPolicy("fifo")
+
+	if !errors.Is(err, InvalidEvictionPolicyError) {
+		t.Fatalf("Unexpected error. Expected %v, got %v\n", InvalidEvictionPolicyError, err)
+	}
+}
+
+func TestEvictionPolicyEmpty(t *testing.T) {
+	for _, name := range allEvictionPolicies {
+		policy := newEvictionPolicy(name, 2)
+
+		if _, ok := policy.Victim(); ok {
+			t.Fatalf("%s: Expected no victim for an empty policy\n", name)
+		}
+	}
+}
+
+func TestEvictionPolicyRemove(t *testing.T) {
+	for _, name := range allEvictionPolicies {
+		policy := newEvictionPolicy(name, 2)
+		policy.Insert("key1")
+		policy.Insert("key2")
+		policy.Remove("key1")
+
+		if policy.Len() != 1 {
+			t.Fatalf("%s: Unexpected length. Expected 1, got %d\n", name, policy.Len())
+		}
+
+		victim, _ := policy.Victim()
+		if victim != "key2" {
+			t.Fatalf("%s: Unexpected victim. Expected key2, got %s\n", name, victim)
+		}
+	}
+}
+
+func TestLRUEvictionPolicy(t *testing.T) {
+	policy := newLRUEvictionPolicy()
+	policy.Insert("key1")
+	policy.Insert("key2")
+	policy.Insert("key3")
+	policy.Access("key1")
+
+	victim, _ := policy.Victim()
+	if victim != "key2" {
+		t.Fatalf("Unexpected victim. Expected key2, got %s\n", victim)
+	}
+}
+
+func TestLFUEvictionPolicy(t *testing.T) {
+	policy := newLFUEvictionPolicy()
+	policy.Insert("key1")
+	policy.Insert("key2")
+	policy.Insert("key3")
+	policy.Access("key1")
+	policy.Access("key1")
+	policy.Access("key2")
+	policy.Access("key3")
+
+	// key2 and key3 have the same frequency, so the least recently used one is evicted
+	victim, _ := policy.Victim()
+	if victim != "key2" {
+		t.Fatalf("Unexpected victim. Expected key2, got %s\n", victim)
+	}
+
+	policy.Remove("key2")
+	policy.Remove("key3")
+
+	victim, _ = policy.Victim()
+	if victim != "key1" {
+		t.Fatalf("Unexpected victim. Expected key1, got %s\n", victim)
+	}
+}
+
+func TestARCEvictionPolicy_ScanResistance(t *testing.T) {
+	policy := newARCEvictionPolicy(3)
+	policy.Insert("hot")
+	policy.Access("hot")
+
+	// Keys only seen once are evicted before keys seen twice, even if they were used more recently
+	for i := range 10 {
+		key := "scan" + strconv.Itoa(i)
+
+		if policy.Len() == 3 {
+			victim, _ := policy.Victim()
+
+			if victim == "hot" {
+				t.Fatalf("Frequently used key was evicted by a scan\n")
+			}
+		}
+
+		policy.Insert(key)
+	}
+}
+
+func TestARCEvictionPolicy_GhostHit(t *testing.T) {
+	policy := newARCEvictionPolicy(2)
+	policy.Insert("key1")
+	policy.Insert("key2")
+
+	victim, _ := policy.Victim()
+	if victim != "key1" {
+		t.Fatalf("Unexpected victim. Expected key1, got %s\n", victim)
+	}
+
+	policy.Insert("key1")
+
+	// key1 was evicted recently, so it's treated as frequently used when inserted again
+	if policy.target != 1 {
+		t.Fatalf("Unexpected target. Expected 1, got %d\n", policy.target)
+	}
+
+	if policy.lookupTable["key1"].Value.(*arcEntry).list != policy.t2 {
+		t.Fatalf("Expected key1 to be in the frequency list\n")
+	}
+}
+
+func TestTinyLFUEvictionPolicy_Admission(t *testing.T) {
+	policy := newTinyLFUEvictionPolicy(100)
+
+	for i := range 100 {
+		key := "key" + strconv.Itoa(i)
+		policy.Insert(key)
+
+		for range 3 {
+			policy.Access(key)
+		}
+	}
+
+	policy.Insert("new")
+
+	// The new key hasn't been seen as often as any key in the main space, so it isn't admitted
+	victim, _ := policy.Victim()
+	if victim != "new" {
+		t.Fatalf("Unexpected victim. Expected new, got %s\n", victim)
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	sketch := newCountMinSketch(64)
+
+	for range 5 {
+		sketch.Increment("key")
+	}
+
+	if sketch.Estimate("key") != 5 {
+		t.Fatalf("Unexpected estimate. Expected 5, got %d\n", sketch.Estimate("key"))
+	}
+
+	for range 2 * sketchMaxCount {
+		sketch.Increment("other")
+	}
+
+	if sketch.Estimate("other") != sketchMaxCount {
+		t.Fatalf("Unexpected estimate. Expected %d, got %d\n", sketchMaxCount, sketch.Estimate("other"))
+	}
+}
+
+func TestCountMinSketch_Reset(t *testing.T) {
+	sketch := newCountMinSketch(64)
+
+	for range 10 {
+		sketch.Increment("key")
+	}
+
+	for range sketch.sampleSize - 10 {
+		sketch.Increment("other")
+	}
+
+	if sketch.Estimate("key") != 5 {
+		t.Fatalf("Expected counters to be halved. Got estimate %d\n", sketch.Estimate("key"))
+	}
+}
+
+func TestCacheWithEvictionPolicy(t *testing.T) {
+	for _, name := range allEvictionPolicies {
+		cache := New(2, WithEvictionPolicy(name))
+
+		for i := range 10 {
+			key := strconv.Itoa(i)
+
+			if _, err := cache.Set(key, Data{Value: []byte(key), ByteCount: len(key)}); err != nil {
+				t.Fatalf("%s: Unexpected error: %v\n", name, err)
+			}
+		}
+
+		if cache.Size() != 2 {
+			t.Fatalf("%s: Unexpected size. Expected 2, got %d\n", name, cache.Size())
+		}
+
+		if evictionPolicyLength(cache) != 2 {
+			t.Fatalf("%s: Eviction policy is out of sync with the lookup table. Got length %d\n", name, evictionPolicyLength(cache))
+		}
+	}
+}
+
+func TestCacheWithEvictionPolicy_MemoryLimit(t *testing.T) {
+	for _, name := range allEvictionPolicies {
+		cache := New(-1, WithEvictionPolicy(name), WithMemoryLimit(int64(3*itemSize("k0", Data{Value: []byte("value")}))))
+
+		for i := range 10 {
+			cache.Set("k"+strconv.Itoa(i), Data{Value: []byte("value"), ByteCount: 5})
+		}
+
+		if cache.Size() != 3 {
+			t.Fatalf("%s: Unexpected size. Expected 3, got %d\n", name, cache.Size())
+		}
+
+		if cache.shards[0].usedBytes > cache.shards[0].memoryLimit {
+			t.Fatalf("%s: Memory limit exceeded. Used %d bytes\n", name, cache.shards[0].usedBytes)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+// expiryHeap min-heap of the entries that have an expiration time, ordered by it. Lets the cleanup task find the
+// entries that are due without going through the rest. Implements heap.Interface, so it should only be modified
+// through the functions of `container/heap`
+type expiryHeap []*entry
+
+// Entries that aren't in the heap have this index
+const notInExpiryHeap = -1
+
+// Number of entries sampled to estimate how many of the entries in the heap are due (see shard.mostlyDue)
+const expirySampleSize = 32
+
+func (receiver expiryHeap) Len() int {
+	return len(receiver)
+}
+
+func (receiver expiryHeap) Less(i, j int) bool {
+	return receiver[i].data.ExpiresAt.UnixMilli() < receiver[j].data.ExpiresAt.UnixMilli()
+}
+
+func (receiver expiryHeap) Swap(i, j int) {
+	receiver[i], receiver[j] = receiver[j], receiver[i]
+	receiver[i].expiryIndex = i
+	receiver[j].expiryIndex = j
+}
+
+func (receiver *expiryHeap) Push(x any) {
+	e := x.(*entry)
+	e.expiryIndex = len(*receiver)
+	*receiver = append(*receiver, e)
+}
+
+func (receiver *expiryHeap) Pop() any {
+	old := *receiver
+	last := len(old) - 1
+	e := old[last]
+	// Cleared so the entry can be garbage collected
+	old[last] = nil
+	e.expiryIndex = notInExpiryHeap
+	*receiver = old[:last]
+
+	return e
+}
+
+// next returns the entry that expires first, if any
+func (receiver expiryHeap) next() (*entry, bool) {
+	if len(receiver) == 0 {
+		return nil, false
+	}
+
+	return receiver[0], true
+}
+
+// removeDetached removes the entries whose index was reset to notInExpiryHeap, e.g., because they were deleted during a
+// sweep, and updates the index of the rest. The heap order must be restored with heap.Init afterward
+func (receiver *expiryHeap) removeDetached() {
+	kept := (*receiver)[:0]
+
+	for _, e := range *receiver {
+		if e.expiryIndex == notInExpiryHeap {
+			continue
+		}
+
+		e.expiryIndex = len(kept)
+		kept = append(kept, e)
+	}
+
+	// Cleared so the removed entries can be garbage collected
+	clear((*receiver)[len(kept):])
+	*receiver = kept
+}
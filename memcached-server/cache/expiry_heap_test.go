@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClearExpiredData_OnlyDueEntries(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	now := timeSource.Now()
+
+	cache.Set("never", Data{Value: []byte("hello")})
+
+	for i := range 10 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Duration(i+1) * time.Second)})
+	}
+
+	timeSource.Advance(time.Millisecond * 3500)
+	cache.clearExpiredData()
+
+	if size := cache.Size(); size != 8 {
+		t.Fatalf("Unexpected size: %d\n", size)
+	}
+
+	// Entries that never expire aren't tracked
+	if length := len(cache.shards[0].expiries); length != 7 {
+		t.Fatalf("Unexpected number of entries in the expiry heap: %d\n", length)
+	}
+
+	next, _ := cache.shards[0].expiries.next()
+
+	if next.key != "3" {
+		t.Fatalf("Unexpected next entry to expire: '%s'\n", next.key)
+	}
+}
+
+func TestClearExpiredData_MostEntriesDue(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	now := timeSource.Now()
+
+	// 3 out of every 4 entries are due, so the shard is swept rather than going through the heap
+	for i := range 400 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Duration(i%4+1) * time.Second)})
+	}
+
+	timeSource.Advance(time.Millisecond * 3500)
+
+	if !cache.shards[0].mostlyDue(timeSource.Now()) {
+		t.Fatal("Expected most entries to be due")
+	}
+
+	cache.clearExpiredData()
+
+	if size := cache.Size(); size != 100 {
+		t.Fatalf("Unexpected size: %d\n", size)
+	}
+
+	if length := len(cache.shards[0].expiries); length != 100 {
+		t.Fatalf("Unexpected number of entries in the expiry heap: %d\n", length)
+	}
+
+	// The rebuilt heap still works for the entries left
+	timeSource.Advance(time.Second)
+	cache.clearExpiredData()
+
+	if size := cache.Size(); size != 0 {
+		t.Fatalf("Expected cache to be empty. Got size = %d\n", size)
+	}
+
+	if stats := cache.Stats(); stats.ExpiredProactively != 400 {
+		t.Fatalf("Unexpected stats: %+v\n", stats)
+	}
+}
+
+func TestClearExpiredData_UpdatedExpirationTime(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	now := timeSource.Now()
+
+	cache.Set("touched", Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Second)})
+	cache.Set("updated", Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Second)})
+	cache.Set("persisted", Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Second)})
+	cache.Set("shortened", Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Minute)})
+
+	cache.Touch("touched", now.Add(time.Minute))
+	cache.Set("updated", Data{Value: []byte("hello"), ExpiresAt: now.Add(time.Minute)})
+	cache.Set("persisted", Data{Value: []byte("hello")})
+	cache.Touch("shortened", now.Add(time.Second))
+
+	timeSource.Advance(time.Second * 2)
+	cache.clearExpiredData()
+
+	for _, key := range []string{"touched", "updated", "persisted"} {
+		if _, err := cache.Peek(key); err != nil {
+			t.Fatalf("Unexpected error for %s: %v\n", key, err)
+		}
+	}
+
+	if _, err := cache.Peek("shortened"); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if length := len(cache.shards[0].expiries); length != 2 {
+		t.Fatalf("Unexpected number of entries in the expiry heap: %d\n", length)
+	}
+}
+
+func TestClearExpiredData_DeletedEntries(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(2, WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Second)
+
+	cache.Set("deleted", Data{Value: []byte("hello"), ExpiresAt: expiresAt})
+	cache.Set("evicted", Data{Value: []byte("hello"), ExpiresAt: expiresAt})
+	cache.Delete("deleted")
+	cache.Set("key1", Data{Value: []byte("hello")})
+	cache.Set("key2", Data{Value: []byte("hello")})
+
+	if length := len(cache.shards[0].expiries); length != 0 {
+		t.Fatalf("Unexpected number of entries in the expiry heap: %d\n", length)
+	}
+}
+
+func TestClearExpiredData_Flushed(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithShards(4), WithTimeSource(timeSource))
+
+	for i := range 10 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello")})
+	}
+
+	cache.Flush(time.Second)
+	cache.clearExpiredData()
+
+	if size := cache.Size(); size != 10 {
+		t.Fatalf("Keys should not be flushed before the delay. Got size = %d\n", size)
+	}
+
+	timeSource.Advance(time.Second)
+	cache.clearExpiredData()
+
+	if size := cache.Size(); size != 0 {
+		t.Fatalf("Expected cache to be empty. Got size = %d\n", size)
+	}
+
+	// Flushed entries aren't counted as expired
+	if stats := cache.Stats(); stats.ExpiredProactively != 0 {
+		t.Fatalf("Unexpected stats: %+v\n", stats)
+	}
+}
+
+func TestStats_ExpiredProactivelyAndLazily(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithShards(4), WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Second)
+
+	for i := range 3 {
+		cache.Set(strconv.Itoa(i), Data{Value: []byte("hello"), ExpiresAt: expiresAt})
+	}
+
+	timeSource.Advance(time.Second * 2)
+	cache.Get("0")
+	cache.clearExpiredData()
+
+	stats := cache.Stats()
+
+	if stats.ExpiredLazily != 1 || stats.ExpiredProactively != 2 {
+		t.Fatalf("Unexpected stats: %+v\n", stats)
+	}
+}
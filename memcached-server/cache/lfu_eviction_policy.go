@@ -0,0 +1,98 @@
+package cache
+
+import "container/list"
+
+// lfuEvictionPolicy evicts the least frequently used key. Ties are broken by evicting the least recently used of those
+// keys. All operations are O(1)
+type lfuEvictionPolicy struct {
+	// Keys with the same access count, grouped by count. Least recently used keys are in the front of each list
+	frequencyLists map[int]*list.List
+	lookupTable    map[string]*list.Element
+	minFrequency   int
+}
+
+type lfuEntry struct {
+	key       string
+	frequency int
+}
+
+func newLFUEvictionPolicy() *lfuEvictionPolicy {
+	return &lfuEvictionPolicy{
+		frequencyLists: make(map[int]*list.List),
+		lookupTable:    make(map[string]*list.Element),
+	}
+}
+
+func (receiver *lfuEvictionPolicy) Insert(key string) {
+	receiver.lookupTable[key] = receiver.frequencyList(1).PushBack(&lfuEntry{key: key, frequency: 1})
+	receiver.minFrequency = 1
+}
+
+func (receiver *lfuEvictionPolicy) Access(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok {
+		return
+	}
+
+	entry := element.Value.(*lfuEntry)
+	receiver.removeFromFrequencyList(element)
+
+	if entry.frequency == receiver.minFrequency && receiver.frequencyLists[entry.frequency] == nil {
+		receiver.minFrequency++
+	}
+
+	entry.frequency++
+	receiver.lookupTable[key] = receiver.frequencyList(entry.frequency).PushBack(entry)
+}
+
+func (receiver *lfuEvictionPolicy) Remove(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok {
+		return
+	}
+
+	receiver.removeFromFrequencyList(element)
+	delete(receiver.lookupTable, key)
+}
+
+func (receiver *lfuEvictionPolicy) Victim() (string, bool) {
+	if len(receiver.lookupTable) == 0 {
+		return "", false
+	}
+
+	// minFrequency isn't updated when keys are removed, so it might be lower than the actual minimum
+	for receiver.frequencyLists[receiver.minFrequency] == nil {
+		receiver.minFrequency++
+	}
+
+	return receiver.frequencyLists[receiver.minFrequency].Front().Value.(*lfuEntry).key, true
+}
+
+func (receiver *lfuEvictionPolicy) Len() int {
+	return len(receiver.lookupTable)
+}
+
+func (receiver *lfuEvictionPolicy) frequencyList(frequency int) *list.List {
+	frequencyList, ok := receiver.frequencyLists[frequency]
+
+	if !ok {
+		frequencyList = list.New()
+		receiver.frequencyLists[frequency] = frequencyList
+	}
+
+	return frequencyList
+}
+
+// removeFromFrequencyList removes the element from its frequency list. Empty lists are deleted
+func (receiver *lfuEvictionPolicy) removeFromFrequencyList(element *list.Element) {
+	frequency := element.Value.(*lfuEntry).frequency
+	frequencyList := receiver.frequencyLists[frequency]
+
+	frequencyList.Remove(element)
+
+	if frequencyList.Len() == 0 {
+		delete(receiver.frequencyLists, frequency)
+	}
+}
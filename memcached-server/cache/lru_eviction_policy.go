@@ -0,0 +1,48 @@
+package cache
+
+import "container/list"
+
+// lruEvictionPolicy evicts the least recently used key
+type lruEvictionPolicy struct {
+	// Least recently used keys are in the front
+	accessList  *list.List
+	lookupTable map[string]*list.Element
+}
+
+func newLRUEvictionPolicy() *lruEvictionPolicy {
+	return &lruEvictionPolicy{
+		accessList:  list.New(),
+		lookupTable: make(map[string]*list.Element),
+	}
+}
+
+func (receiver *lruEvictionPolicy) Insert(key string) {
+	receiver.lookupTable[key] = receiver.accessList.PushBack(key)
+}
+
+func (receiver *lruEvictionPolicy) Access(key string) {
+	if element, ok := receiver.lookupTable[key]; ok {
+		receiver.accessList.MoveToBack(element)
+	}
+}
+
+func (receiver *lruEvictionPolicy) Remove(key string) {
+	if element, ok := receiver.lookupTable[key]; ok {
+		receiver.accessList.Remove(element)
+		delete(receiver.lookupTable, key)
+	}
+}
+
+func (receiver *lruEvictionPolicy) Victim() (string, bool) {
+	front := receiver.accessList.Front()
+
+	if front == nil {
+		return "", false
+	}
+
+	return front.Value.(string), true
+}
+
+func (receiver *lruEvictionPolicy) Len() int {
+	return receiver.accessList.Len()
+}
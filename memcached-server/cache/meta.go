@@ -0,0 +1,382 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// MetaItem data of an item along with the metadata used by the meta commands of the memcached protocol
+type MetaItem struct {
+	Data
+	// Whether the item was read since it was stored, not counting the operation that returned it
+	Fetched bool
+	// Time at which the item was last stored or read, not counting the operation that returned it
+	LastAccessedAt time.Time
+	// Whether the item was marked as stale (see MetaDeleteOptions.Invalidate). Stale items are still returned, so
+	// clients can serve them while one of them recaches the item
+	Stale bool
+	// Whether a client was already told to recache the item, not counting the operation that returned it
+	WinTokenSent bool
+	// Whether the caller should recache the item. Only one caller gets the win token of an item until it's stored again
+	Won bool
+	// ID of the slab class holding the item (see SlabClassStats). 1 if the slab allocator is disabled
+	SlabClass int
+}
+
+// MetaGetOptions settings of Cache.MetaGet. The zero value is the same as Get
+type MetaGetOptions struct {
+	// Leaves the recency, fetched status, and last access time of the item unchanged
+	NoBump bool
+	// Updates the expiration time of the item to ExpiresAt
+	Touch     bool
+	ExpiresAt time.Time
+	// If the key isn't found, stores an empty item expiring at VivifyExpiresAt and gives the win token to the caller,
+	// so only one client recaches a missing item
+	Vivify          bool
+	VivifyExpiresAt time.Time
+	// Gives the win token to the caller if the item expires before RecacheBefore. Ignored if zero
+	RecacheBefore time.Time
+	// CAS unique value of the item stored by Vivify. A new one is assigned if zero
+	CasUnique uint64
+}
+
+// MetaSetMode condition under which Cache.MetaSet stores the data, and how it's combined with the existing data
+type MetaSetMode byte
+
+const (
+	MetaSetModeSet     MetaSetMode = 'S'
+	MetaSetModeAdd     MetaSetMode = 'E'
+	MetaSetModeAppend  MetaSetMode = 'A'
+	MetaSetModePrepend MetaSetMode = 'P'
+	MetaSetModeReplace MetaSetMode = 'R'
+)
+
+// MetaSetOptions settings of Cache.MetaSet. The zero value is the same as Set
+type MetaSetOptions struct {
+	// Defaults to MetaSetModeSet
+	Mode MetaSetMode
+	// Stores the data only if the CAS unique value of the item matches CompareCasUnique
+	CompareCas       bool
+	CompareCasUnique uint64
+	// If CompareCasUnique is older than the CAS unique value of the item, the data is stored anyway but marked as stale
+	Invalidate bool
+	// In append and prepend modes, stores the data as a new item expiring at VivifyExpiresAt if the key isn't found
+	Vivify          bool
+	VivifyExpiresAt time.Time
+	// CAS unique value of the stored item. A new one is assigned if zero
+	CasUnique uint64
+}
+
+// MetaDeleteOptions settings of Cache.MetaDelete. The zero value is the same as Delete
+type MetaDeleteOptions struct {
+	// Deletes the item only if its CAS unique value matches CompareCasUnique
+	CompareCas       bool
+	CompareCasUnique uint64
+	// Marks the item as stale instead of deleting it, so the next MetaGet gives the win token to its caller. The
+	// expiration time of the item is updated to ExpiresAt if Touch is set
+	Invalidate bool
+	Touch      bool
+	ExpiresAt  time.Time
+	// Removes the value of the item but leaves the item in place
+	ClearValue bool
+	// CAS unique value of the item if it's kept. A new one is assigned if zero
+	CasUnique uint64
+}
+
+// MetaArithmeticOptions settings of Cache.MetaArithmetic
+type MetaArithmeticOptions struct {
+	// Decrements the value instead of incrementing it
+	Decrement bool
+	Delta     uint64
+	// Updates the value only if the CAS unique value of the item matches CompareCasUnique
+	CompareCas       bool
+	CompareCasUnique uint64
+	// If the key isn't found, stores InitialValue as a new item expiring at VivifyExpiresAt
+	Vivify          bool
+	VivifyExpiresAt time.Time
+	InitialValue    uint64
+	// Updates the expiration time of the item to ExpiresAt
+	Touch     bool
+	ExpiresAt time.Time
+	// CAS unique value of the updated item. A new one is assigned if zero
+	CasUnique uint64
+}
+
+// MetaGet retrieves the item stored for the key along with its metadata. Returns KeyNotFoundError if the key isn't
+// found, unless options.Vivify is set
+func (receiver *Cache) MetaGet(key string, options MetaGetOptions) (MetaItem, error) {
+	return receiver.shardFor(key).MetaGet(key, options)
+}
+
+// MetaSet stores the data according to options.Mode and returns the stored data. Returns KeyAlreadyExistsError if the
+// key exists in add mode, KeyNotFoundError if it doesn't in the rest of the modes (or when comparing CAS unique
+// values), and CasMismatchError if the CAS unique values don't match
+func (receiver *Cache) MetaSet(key string, data Data, options MetaSetOptions) (Data, error) {
+	return receiver.shardFor(key).MetaSet(key, data, options)
+}
+
+// MetaDelete deletes the item, or modifies it according to options. Returns KeyNotFoundError if the key isn't found,
+// and CasMismatchError if the CAS unique values don't match
+func (receiver *Cache) MetaDelete(key string, options MetaDeleteOptions) error {
+	return receiver.shardFor(key).MetaDelete(key, options)
+}
+
+// MetaArithmetic increments or decrements the numeric value of the key and returns the updated data. Returns the same
+// errors as Increment, and CasMismatchError if the CAS unique values don't match
+func (receiver *Cache) MetaArithmetic(key string, options MetaArithmeticOptions) (Data, error) {
+	return receiver.shardFor(key).MetaArithmetic(key, options)
+}
+
+// Inspect returns the item stored for the key along with its metadata without counting it as an access. Returns the
+// same errors as Get
+func (receiver *Cache) Inspect(key string) (MetaItem, error) {
+	return receiver.shardFor(key).Inspect(key)
+}
+
+func (receiver *shard) MetaGet(key string, options MetaGetOptions) (MetaItem, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	e, now, err := receiver.lookup(key)
+	created := false
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) && options.Vivify {
+		data := Data{Value: []byte{}, ExpiresAt: options.VivifyExpiresAt}
+
+		if _, err := receiver.setWithCas(key, data, options.CasUnique); err != nil {
+			return MetaItem{}, err
+		}
+
+		e, now, err = receiver.lookup(key)
+		created = true
+	}
+
+	if err != nil {
+		return MetaItem{}, err
+	}
+
+	item := receiver.metaItem(e)
+
+	if options.Touch {
+		e.data.ExpiresAt = options.ExpiresAt
+		receiver.scheduleExpiry(e)
+		item.ExpiresAt = options.ExpiresAt
+		receiver.notify(Mutation{Type: MutationSet, Key: key, Data: receiver.export(e)})
+	}
+
+	// Items that are about to expire are recached by a single client, same as the ones that are stale or missing
+	expiresSoon := !options.RecacheBefore.IsZero() && e.data.ExpiresAt.UnixMilli() > 0 &&
+		e.data.ExpiresAt.Before(options.RecacheBefore)
+
+	item.Won = created || (!e.winTokenSent && (expiresSoon || e.invalidated))
+	e.winTokenSent = e.winTokenSent || item.Won
+
+	if !options.NoBump {
+		e.fetched = true
+		receiver.access(e, now)
+	}
+
+	return item, nil
+}
+
+func (receiver *shard) MetaSet(key string, data Data, options MetaSetOptions) (Data, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	current, err := receiver.peek(key)
+	found := err == nil
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if err != nil && !errors.As(err, &keyNotFoundError) {
+		return Data{}, err
+	}
+
+	invalidate := false
+
+	if options.CompareCas {
+		if !found {
+			return Data{}, err
+		}
+
+		if current.CasUnique != options.CompareCasUnique {
+			if !options.Invalidate || options.CompareCasUnique > current.CasUnique {
+				return Data{}, &CasMismatchError{Key: key}
+			}
+
+			invalidate = true
+		}
+	}
+
+	switch options.Mode {
+	case MetaSetModeAdd:
+		if found {
+			return Data{}, &KeyAlreadyExistsError{Key: key}
+		}
+	case MetaSetModeReplace:
+		if !found {
+			return Data{}, err
+		}
+	case MetaSetModeAppend, MetaSetModePrepend:
+		if !found && !options.Vivify {
+			return Data{}, err
+		}
+
+		if !found {
+			data.ExpiresAt = options.VivifyExpiresAt
+			break
+		}
+
+		value := concat(current.Value, data.Value)
+
+		if options.Mode == MetaSetModePrepend {
+			value = concat(data.Value, current.Value)
+		}
+
+		// Same as Append and Prepend, the flags and expiration time of the existing data are kept
+		data = Data{Value: value, ByteCount: len(value), Flags: current.Flags, ExpiresAt: current.ExpiresAt}
+	}
+
+	data, err = receiver.storeMeta(key, data, options.CasUnique)
+
+	if err == nil {
+		receiver.markInvalidated(key, invalidate)
+	}
+
+	return data, err
+}
+
+func (receiver *shard) MetaDelete(key string, options MetaDeleteOptions) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	current, err := receiver.peek(key)
+
+	if err != nil {
+		return err
+	}
+
+	if options.CompareCas && current.CasUnique != options.CompareCasUnique {
+		return &CasMismatchError{Key: key}
+	}
+
+	if !options.Invalidate && !options.ClearValue {
+		return receiver.delete(key)
+	}
+
+	if options.ClearValue {
+		current.Value = []byte{}
+		current.ByteCount = 0
+	}
+
+	if options.Invalidate && options.Touch {
+		current.ExpiresAt = options.ExpiresAt
+	}
+
+	if _, err := receiver.storeMeta(key, current, options.CasUnique); err != nil {
+		return err
+	}
+
+	receiver.markInvalidated(key, options.Invalidate)
+
+	return nil
+}
+
+func (receiver *shard) MetaArithmetic(key string, options MetaArithmeticOptions) (Data, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	current, err := receiver.peek(key)
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) && options.Vivify {
+		value := []byte(strconv.FormatUint(options.InitialValue, 10))
+		current = Data{Value: value, ByteCount: len(value), ExpiresAt: options.VivifyExpiresAt}
+
+		return receiver.storeMeta(key, current, options.CasUnique)
+	}
+
+	if err != nil {
+		return Data{}, err
+	}
+
+	if options.CompareCas && current.CasUnique != options.CompareCasUnique {
+		return Data{}, &CasMismatchError{Key: key}
+	}
+
+	value, parseErr := strconv.ParseUint(string(current.Value), 10, 64)
+
+	if parseErr != nil {
+		return Data{}, &NonNumericValueError{Key: key}
+	}
+
+	switch {
+	case !options.Decrement:
+		value += options.Delta
+	case options.Delta > value:
+		value = 0
+	default:
+		value -= options.Delta
+	}
+
+	current.Value = []byte(strconv.FormatUint(value, 10))
+	current.ByteCount = len(current.Value)
+
+	if options.Touch {
+		current.ExpiresAt = options.ExpiresAt
+	}
+
+	return receiver.storeMeta(key, current, options.CasUnique)
+}
+
+func (receiver *shard) Inspect(key string) (MetaItem, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	e, _, err := receiver.lookup(key)
+
+	if err != nil {
+		return MetaItem{}, err
+	}
+
+	return receiver.metaItem(e), nil
+}
+
+// storeMeta stores the data and returns it with the CAS unique value it was assigned. Assumes the caller holds the
+// mutex
+func (receiver *shard) storeMeta(key string, data Data, casUnique uint64) (Data, error) {
+	if casUnique == 0 {
+		casUnique = receiver.lastCasUnique.Add(1)
+	}
+
+	data.CasUnique = casUnique
+	_, err := receiver.setWithCas(key, data, casUnique)
+
+	return data, err
+}
+
+// markInvalidated sets whether the entry of the key is stale. The entry might not exist even right after storing it,
+// since the eviction policy can reject it. Assumes the caller holds the mutex
+func (receiver *shard) markInvalidated(key string, invalidated bool) {
+	if element, ok := receiver.lookupTable[key]; ok {
+		element.Value.(*entry).invalidated = invalidated
+	}
+}
+
+func (receiver *shard) metaItem(e *entry) MetaItem {
+	slabClass := 1
+
+	if receiver.slabs != nil {
+		slabClass = receiver.slabs.classOf(e.chunk) + 1
+	}
+
+	return MetaItem{
+		Data:           receiver.export(e),
+		Fetched:        e.fetched,
+		LastAccessedAt: time.Unix(0, e.lastAccessedAt),
+		Stale:          e.invalidated,
+		WinTokenSent:   e.winTokenSent,
+		SlabClass:      slabClass,
+	}
+}
@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMetaGet(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	storedAt := timeSource.Now()
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+	timeSource.Advance(time.Second)
+
+	item, err := cache.MetaGet("key1", MetaGetOptions{})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if string(item.Value) != "hello" || item.Fetched || !item.LastAccessedAt.Equal(storedAt) || item.Won {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	timeSource.Advance(time.Second)
+	item, _ = cache.MetaGet("key1", MetaGetOptions{})
+
+	if !item.Fetched || !item.LastAccessedAt.Equal(storedAt.Add(time.Second)) {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	_, err = cache.MetaGet("key2", MetaGetOptions{})
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestMetaGet_NoBump(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+	cache.MetaGet("key1", MetaGetOptions{NoBump: true})
+
+	if item, _ := cache.Inspect("key1"); item.Fetched {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+}
+
+func TestMetaGet_Touch(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Minute)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+
+	item, _ := cache.MetaGet("key1", MetaGetOptions{Touch: true, ExpiresAt: expiresAt})
+
+	if !item.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected expiration time: %v\n", item.ExpiresAt)
+	}
+
+	if data, _ := cache.Peek("key1"); !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected expiration time: %v\n", data.ExpiresAt)
+	}
+}
+
+func TestMetaGet_Vivify(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	options := MetaGetOptions{Vivify: true, VivifyExpiresAt: timeSource.Now().Add(time.Second * 30)}
+
+	// The first client gets the win token of the missing item
+	item, err := cache.MetaGet("key1", options)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if len(item.Value) != 0 || !item.Won || item.WinTokenSent {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	// And the rest are told that another client is recaching it
+	item, _ = cache.MetaGet("key1", options)
+
+	if item.Won || !item.WinTokenSent {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	// Until it's stored
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5})
+	item, _ = cache.MetaGet("key1", options)
+
+	if string(item.Value) != "hello" || item.Won || item.WinTokenSent {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+}
+
+func TestMetaGet_Recache(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	options := MetaGetOptions{RecacheBefore: timeSource.Now().Add(time.Second * 30)}
+
+	cache.Set("key1", Data{Value: []byte("1"), ByteCount: 1, ExpiresAt: timeSource.Now().Add(time.Minute)})
+	cache.Set("key2", Data{Value: []byte("2"), ByteCount: 1, ExpiresAt: timeSource.Now().Add(time.Second * 10)})
+	cache.Set("key3", Data{Value: []byte("3"), ByteCount: 1})
+
+	if item, _ := cache.MetaGet("key1", options); item.Won {
+		t.Fatalf("Unexpected win for an item that doesn't expire soon\n")
+	}
+
+	if item, _ := cache.MetaGet("key2", options); !item.Won {
+		t.Fatalf("Expected win for an item that expires soon\n")
+	}
+
+	if item, _ := cache.MetaGet("key2", options); item.Won || !item.WinTokenSent {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	if item, _ := cache.MetaGet("key3", options); item.Won {
+		t.Fatalf("Unexpected win for an item that never expires\n")
+	}
+}
+
+func TestMetaSet_Modes(t *testing.T) {
+	cache := New(-1)
+	keyNotFoundError := &KeyNotFoundError{}
+	keyAlreadyExistsError := &KeyAlreadyExistsError{}
+
+	if _, err := cache.MetaSet("key1", testData("1"), MetaSetOptions{Mode: MetaSetModeReplace}); !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if _, err := cache.MetaSet("key1", testData("1"), MetaSetOptions{Mode: MetaSetModeAppend}); !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if _, err := cache.MetaSet("key1", testData("1"), MetaSetOptions{Mode: MetaSetModeAdd}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if _, err := cache.MetaSet("key1", testData("2"), MetaSetOptions{Mode: MetaSetModeAdd}); !errors.As(err, &keyAlreadyExistsError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	cache.MetaSet("key1", testData("2"), MetaSetOptions{Mode: MetaSetModeAppend})
+	cache.MetaSet("key1", testData("0"), MetaSetOptions{Mode: MetaSetModePrepend})
+	data, err := cache.MetaSet("key1", testData("012"), MetaSetOptions{Mode: MetaSetModeReplace})
+
+	if err != nil || string(data.Value) != "012" || data.CasUnique != getCasUnique(t, cache, "key1") {
+		t.Fatalf("Unexpected result: %+v, %v\n", data, err)
+	}
+}
+
+func TestMetaSet_AppendVivify(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Minute)
+
+	data, err := cache.MetaSet("key1", testData("1"), MetaSetOptions{
+		Mode:            MetaSetModeAppend,
+		Vivify:          true,
+		VivifyExpiresAt: expiresAt,
+	})
+
+	if err != nil || string(data.Value) != "1" || !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected result: %+v, %v\n", data, err)
+	}
+}
+
+func TestMetaSet_CompareCas(t *testing.T) {
+	cache := New(-1)
+
+	_, err := cache.MetaSet("key1", testData("1"), MetaSetOptions{CompareCas: true, CompareCasUnique: 1})
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	cache.Set("key1", testData("1"))
+	casUnique := getCasUnique(t, cache, "key1")
+
+	_, err = cache.MetaSet("key1", testData("2"), MetaSetOptions{CompareCas: true, CompareCasUnique: casUnique + 1})
+
+	casMismatchError := &CasMismatchError{}
+	if !errors.As(err, &casMismatchError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if _, err := cache.MetaSet("key1", testData("2"), MetaSetOptions{CompareCas: true, CompareCasUnique: casUnique}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestMetaSet_Invalidate(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", testData("1"))
+	oldCasUnique := getCasUnique(t, cache, "key1")
+	cache.Set("key1", testData("2"))
+
+	// Data based on an older version of the item is stored, but marked as stale
+	options := MetaSetOptions{CompareCas: true, CompareCasUnique: oldCasUnique, Invalidate: true}
+
+	if _, err := cache.MetaSet("key1", testData("3"), options); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if item, _ := cache.MetaGet("key1", MetaGetOptions{}); string(item.Value) != "3" || !item.Stale || !item.Won {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+}
+
+func TestMetaSet_CasUnique(t *testing.T) {
+	cache := New(-1)
+
+	data, _ := cache.MetaSet("key1", testData("1"), MetaSetOptions{CasUnique: 12345})
+
+	if data.CasUnique != 12345 || getCasUnique(t, cache, "key1") != 12345 {
+		t.Fatalf("Unexpected CAS unique: %d\n", data.CasUnique)
+	}
+}
+
+func TestMetaDelete(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", testData("1"))
+	casUnique := getCasUnique(t, cache, "key1")
+
+	casMismatchError := &CasMismatchError{}
+	if err := cache.MetaDelete("key1", MetaDeleteOptions{CompareCas: true, CompareCasUnique: casUnique + 1}); !errors.As(err, &casMismatchError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := cache.MetaDelete("key1", MetaDeleteOptions{CompareCas: true, CompareCasUnique: casUnique}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if err := cache.MetaDelete("key1", MetaDeleteOptions{}); !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestMetaDelete_Invalidate(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Second * 30)
+
+	cache.Set("key1", testData("1"))
+	casUnique := getCasUnique(t, cache, "key1")
+
+	if err := cache.MetaDelete("key1", MetaDeleteOptions{Invalidate: true, Touch: true, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	// The first client to read the stale item recaches it
+	item, _ := cache.MetaGet("key1", MetaGetOptions{})
+
+	if string(item.Value) != "1" || !item.Stale || !item.Won || item.CasUnique == casUnique || !item.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+
+	if item, _ := cache.MetaGet("key1", MetaGetOptions{}); !item.Stale || item.Won || !item.WinTokenSent {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+}
+
+func TestMetaDelete_ClearValue(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5, Flags: 3})
+	cache.MetaDelete("key1", MetaDeleteOptions{ClearValue: true})
+
+	if data, err := cache.Get("key1"); err != nil || len(data.Value) != 0 || data.ByteCount != 0 || data.Flags != 3 {
+		t.Fatalf("Unexpected result: %+v, %v\n", data, err)
+	}
+}
+
+func TestMetaArithmetic(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.Set("key1", testData("10"))
+
+	data, err := cache.MetaArithmetic("key1", MetaArithmeticOptions{Delta: 5})
+
+	if err != nil || string(data.Value) != "15" || data.CasUnique != getCasUnique(t, cache, "key1") {
+		t.Fatalf("Unexpected result: %+v, %v\n", data, err)
+	}
+
+	expiresAt := timeSource.Now().Add(time.Minute)
+	data, _ = cache.MetaArithmetic("key1", MetaArithmeticOptions{Decrement: true, Delta: 20, Touch: true, ExpiresAt: expiresAt})
+
+	if string(data.Value) != "0" || !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected result: %+v\n", data)
+	}
+
+	casMismatchError := &CasMismatchError{}
+	if _, err := cache.MetaArithmetic("key1", MetaArithmeticOptions{Delta: 1, CompareCas: true}); !errors.As(err, &casMismatchError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	cache.Set("key2", testData("hello"))
+
+	nonNumericValueError := &NonNumericValueError{}
+	if _, err := cache.MetaArithmetic("key2", MetaArithmeticOptions{Delta: 1}); !errors.As(err, &nonNumericValueError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestMetaArithmetic_Vivify(t *testing.T) {
+	cache := New(-1)
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if _, err := cache.MetaArithmetic("key1", MetaArithmeticOptions{Delta: 1}); !errors.As(err, &keyNotFoundError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	options := MetaArithmeticOptions{Delta: 1, Vivify: true, InitialValue: 10}
+
+	// The initial value is stored as is, and later calls update it
+	if data, _ := cache.MetaArithmetic("key1", options); string(data.Value) != "10" {
+		t.Fatalf("Unexpected value: %s\n", data.Value)
+	}
+
+	if data, _ := cache.MetaArithmetic("key1", options); string(data.Value) != "11" {
+		t.Fatalf("Unexpected value: %s\n", data.Value)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", testData("1"))
+	cache.Inspect("key1")
+
+	if item, err := cache.Inspect("key1"); err != nil || item.Fetched || string(item.Value) != "1" {
+		t.Fatalf("Unexpected result: %+v, %v\n", item, err)
+	}
+}
+
+func testData(value string) Data {
+	return Data{Value: []byte(value), ByteCount: len(value)}
+}
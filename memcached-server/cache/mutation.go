@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MutationType kind of change made to the cache
+type MutationType byte
+
+const (
+	// MutationSet a key was stored or updated by any command, e.g., set, append, incr, or touch
+	MutationSet MutationType = iota + 1
+	// MutationDelete a key was removed, either by a client or because it was evicted, expired, or flushed
+	MutationDelete
+	// MutationFlush all the items stored before FlushAt are invalidated once it's reached
+	MutationFlush
+)
+
+// Mutation change made to the cache. Set mutations carry the data stored as a result of the command, so applying the
+// mutations in order (see Cache.Apply) reproduces the contents of the cache regardless of the command that made them
+type Mutation struct {
+	Type MutationType
+	Key  string
+	Data Data
+	// Time at which the items are invalidated. Only used by flush mutations
+	FlushAt time.Time
+}
+
+// MutationListener receives every change made to the cache. It's called while the shard of the key is locked, so
+// mutations of a key are received in the order they were made. Listeners must be fast and must not call the cache
+type MutationListener func(mutation Mutation)
+
+// AddMutationListener registers a listener called after every change made to the cache
+func (receiver *Cache) AddMutationListener(listener MutationListener) {
+	for {
+		current := receiver.listeners.Load()
+		updated := append(append([]MutationListener{}, *current...), listener)
+
+		if receiver.listeners.CompareAndSwap(current, &updated) {
+			return
+		}
+	}
+}
+
+// Apply makes a change received from a mutation listener, e.g., to restore the contents of the cache or to keep a copy
+// of it. Stored data gets a new CAS unique value, and expired data is deleted rather than stored
+func (receiver *Cache) Apply(mutation Mutation) error {
+	switch mutation.Type {
+	case MutationSet:
+		if isExpired(mutation.Data, receiver.timeSource.Now()) {
+			return receiver.discard(mutation.Key)
+		}
+
+		_, err := receiver.Set(mutation.Key, mutation.Data)
+
+		return err
+	case MutationDelete:
+		return receiver.discard(mutation.Key)
+	case MutationFlush:
+		receiver.Flush(max(mutation.FlushAt.Sub(receiver.timeSource.Now()), 0))
+		return nil
+	}
+
+	return errors.New("unknown mutation type")
+}
+
+// discard deletes the key if it exists. Deleting a missing key isn't an error when applying mutations, e.g., the key
+// might have already expired in this cache
+func (receiver *Cache) discard(key string) error {
+	err := receiver.Delete(key)
+
+	keyNotFoundError := &KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return nil
+	}
+
+	return err
+}
+
+// WriteMutation writes the mutation in the format used by the append-only log and replication
+func WriteMutation(writer io.Writer, mutation Mutation) error {
+	if _, err := writer.Write([]byte{byte(mutation.Type)}); err != nil {
+		return err
+	}
+
+	switch mutation.Type {
+	case MutationSet:
+		return writeItem(writer, mutation.Key, mutation.Data)
+	case MutationDelete:
+		return writeKey(writer, mutation.Key)
+	case MutationFlush:
+		return binary.Write(writer, binary.BigEndian, mutation.FlushAt.UnixMilli())
+	}
+
+	return fmt.Errorf("unknown mutation type %d", mutation.Type)
+}
+
+// ReadMutation reads a mutation written by WriteMutation. Returns io.EOF if there are no more mutations, and
+// io.ErrUnexpectedEOF if the mutation is incomplete
+func ReadMutation(reader io.Reader) (Mutation, error) {
+	mutationType := make([]byte, 1)
+
+	if _, err := io.ReadFull(reader, mutationType); err != nil {
+		return Mutation{}, err
+	}
+
+	mutation := Mutation{Type: MutationType(mutationType[0])}
+
+	switch mutation.Type {
+	case MutationSet:
+		key, data, err := readItem(reader)
+		mutation.Key = key
+		mutation.Data = data
+
+		return mutation, unexpectedEOF(err)
+	case MutationDelete:
+		key, err := readKey(reader)
+		mutation.Key = key
+
+		return mutation, unexpectedEOF(err)
+	case MutationFlush:
+		var flushAt int64
+		err := binary.Read(reader, binary.BigEndian, &flushAt)
+		mutation.FlushAt = time.UnixMilli(flushAt)
+
+		return mutation, unexpectedEOF(err)
+	}
+
+	return Mutation{}, fmt.Errorf("unknown mutation type %d", mutation.Type)
+}
+
+// unexpectedEOF reports reaching the end of the file in the middle of a mutation as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// notifyListeners calls the mutation listeners
+func notifyListeners(listeners []MutationListener, mutation Mutation) {
+	for _, listener := range listeners {
+		listener(mutation)
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMutationListener(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(1, WithTimeSource(timeSource))
+	var mutations []Mutation
+
+	cache.AddMutationListener(func(mutation Mutation) {
+		mutations = append(mutations, mutation)
+	})
+
+	cache.Set("key1", Data{Value: []byte("hello")})
+	cache.Append("key1", Data{Value: []byte(" world")})
+	// Evicts key1
+	cache.Set("key2", Data{Value: []byte("1")})
+	cache.Flush(time.Second)
+
+	expected := []struct {
+		mutationType MutationType
+		key          string
+		value        string
+	}{
+		{MutationSet, "key1", "hello"},
+		{MutationSet, "key1", "hello world"},
+		{MutationDelete, "key1", ""},
+		{MutationSet, "key2", "1"},
+		{MutationFlush, "", ""},
+	}
+
+	if len(mutations) != len(expected) {
+		t.Fatalf("Unexpected number of mutations. Expected %d, got %d: %+v\n", len(expected), len(mutations), mutations)
+	}
+
+	for i, e := range expected {
+		if mutations[i].Type != e.mutationType || mutations[i].Key != e.key || string(mutations[i].Data.Value) != e.value {
+			t.Errorf("Unexpected mutation %d: %+v\n", i, mutations[i])
+		}
+	}
+
+	if !mutations[4].FlushAt.Equal(timeSource.Now().Add(time.Second)) {
+		t.Fatalf("Unexpected flush time: %v\n", mutations[4].FlushAt)
+	}
+}
+
+func TestApply(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.Apply(Mutation{Type: MutationSet, Key: "key1", Data: Data{Value: []byte("1")}})
+	cache.Apply(Mutation{Type: MutationSet, Key: "key2", Data: Data{Value: []byte("2")}})
+	cache.Apply(Mutation{Type: MutationDelete, Key: "key2"})
+
+	data, err := cache.Get("key1")
+
+	if err != nil || !reflect.DeepEqual(data.Value, []byte("1")) {
+		t.Fatalf("Unexpected data: %+v, %v\n", data, err)
+	}
+
+	if _, err := cache.Get("key2"); err == nil {
+		t.Fatalf("Unexpected key2 not deleted\n")
+	}
+
+	// Expired data replaces the existing data
+	cache.Apply(Mutation{Type: MutationSet, Key: "key1", Data: Data{Value: []byte("1"), ExpiresAt: timeSource.Now().Add(-time.Second)}})
+
+	if _, err := cache.Get("key1"); err == nil {
+		t.Fatalf("Unexpected expired data stored\n")
+	}
+
+	// Flushes in the past take effect right away
+	cache.Set("key3", Data{Value: []byte("3")})
+	cache.Apply(Mutation{Type: MutationFlush, FlushAt: timeSource.Now().Add(-time.Hour)})
+
+	if _, err := cache.Get("key3"); err == nil {
+		t.Fatalf("Unexpected key3 not flushed\n")
+	}
+}
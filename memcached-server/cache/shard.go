@@ -0,0 +1,805 @@
+package cache
+
+import (
+	"bytes"
+	"container/heap"
+	"container/list"
+	"math"
+	"memcached-server/utils"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry struct {
+	key  string
+	data Data
+	// Whether the data has been read by a client since it was stored. Used for stats
+	fetched bool
+	// Time at which the data was stored, in Unix nanoseconds. Used to find the entries invalidated by a flush
+	storedAt int64
+	// Time at which the data was last stored or read, in Unix nanoseconds. Reported by the meta commands
+	lastAccessedAt int64
+	// Whether the entry was marked as stale by a meta command (see MetaDeleteOptions.Invalidate). Unlike expired
+	// entries, they're still returned
+	invalidated bool
+	// Whether a client was given the win token of the entry, i.e., told to recache it (see MetaItem.Won)
+	winTokenSent bool
+	// Chunk holding the key and value. Only used by the slab allocator, in which case the value of data is nil
+	chunk slabChunk
+	// Position of the entry in the expiry heap of the shard, or notInExpiryHeap if the data never expires
+	expiryIndex int
+}
+
+// shard independent cache holding a subset of the keys of a Cache. Each shard has its own lock, so operations on
+// keys in different shards don't block each other
+type shard struct {
+	// Entries from least to most recently used. Eviction order is tracked by the policy, this is only used to iterate
+	// through the entries and to save them in recency order (see Cache.WriteSnapshot)
+	entries     *list.List
+	lookupTable map[string]*list.Element
+	// Decides which keys are evicted when the shard is full
+	policy   evictionPolicy
+	capacity int
+	// Maximum number of bytes used by the items in the shard. See itemSize
+	memoryLimit int64
+	usedBytes   int64
+	// Stores the keys and values of the entries. Nil if the slab allocator is disabled, in which case values are
+	// referenced directly
+	slabs *slabAllocator
+	// Entries that have an expiration time, ordered by it, so the cleanup task only goes through the ones that are due
+	expiries expiryHeap
+	// Latest flush whose entries were all deleted by the cleanup task, in Unix nanoseconds
+	clearedFlushAt int64
+	// Shared by all shards of the cache so CAS unique values are never reused across keys
+	lastCasUnique *atomic.Uint64
+	// Entries stored at or before this time (in Unix nanoseconds) are invalid once it's reached. Zero if the cache was
+	// never flushed. Shared by all shards of the cache
+	flushAt *atomic.Int64
+	// Clock used to decide whether data expired or was flushed
+	timeSource utils.TimeSource
+	// Called after every change made to the shard. Shared by all shards of the cache
+	listeners *atomic.Pointer[[]MutationListener]
+	// Counters for the items in the shard. CurrItems and Bytes are computed when the stats are read
+	stats Stats
+	// Guards lookupTable, expiries, policy, and stats. Reads also need an exclusive lock since they update the policy
+	mutex *sync.Mutex
+}
+
+func newShard(
+	capacity int,
+	memoryLimit int64,
+	policy EvictionPolicy,
+	lastCasUnique *atomic.Uint64,
+	flushAt *atomic.Int64,
+	timeSource utils.TimeSource,
+	listeners *atomic.Pointer[[]MutationListener],
+	slabGrowthFactor float64,
+) *shard {
+	s := &shard{
+		entries:       list.New(),
+		lookupTable:   make(map[string]*list.Element),
+		policy:        newEvictionPolicy(policy, capacity),
+		capacity:      capacity,
+		memoryLimit:   memoryLimit,
+		lastCasUnique: lastCasUnique,
+		flushAt:       flushAt,
+		timeSource:    timeSource,
+		listeners:     listeners,
+		mutex:         &sync.Mutex{},
+	}
+
+	// Memory is bounded by the number of pages of the allocator instead
+	if slabGrowthFactor > 0 {
+		s.slabs = newSlabAllocator(memoryLimit, slabGrowthFactor)
+		s.memoryLimit = math.MaxInt64
+	}
+
+	return s
+}
+
+func (receiver *shard) Size() int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return receiver.size()
+}
+
+func (receiver *shard) Set(key string, data Data) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return receiver.set(key, data)
+}
+
+func (receiver *shard) Get(key string) (Data, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	data, err := receiver.get(key)
+
+	if err == nil {
+		receiver.lookupTable[key].Value.(*entry).fetched = true
+	}
+
+	return data, err
+}
+
+func (receiver *shard) Touch(key string, expiresAt time.Time) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	_, err := receiver.touch(key, expiresAt)
+
+	return err
+}
+
+func (receiver *shard) GetAndTouch(key string, expiresAt time.Time) (Data, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	data, err := receiver.touch(key, expiresAt)
+
+	if err == nil {
+		receiver.lookupTable[key].Value.(*entry).fetched = true
+	}
+
+	return data, err
+}
+
+func (receiver *shard) Peek(key string) (Data, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return receiver.peek(key)
+}
+
+func (receiver *shard) Stats() Stats {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	stats := receiver.stats
+	stats.CurrItems = receiver.size()
+	stats.Bytes = receiver.usedBytes
+
+	return stats
+}
+
+// SlabStats returns the stats of each slab class. Nil if the slab allocator is disabled
+func (receiver *shard) SlabStats() []SlabClassStats {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.slabs == nil {
+		return nil
+	}
+
+	return receiver.slabs.Stats()
+}
+
+func (receiver *shard) Delete(key string) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if _, _, err := receiver.lookup(key); err != nil {
+		return err
+	}
+
+	return receiver.delete(key)
+}
+
+func (receiver *shard) CompareAndDelete(key string, casUnique uint64) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	e, _, err := receiver.lookup(key)
+
+	if err != nil {
+		return err
+	}
+
+	if e.data.CasUnique != casUnique {
+		return &CasMismatchError{Key: key}
+	}
+
+	return receiver.delete(key)
+}
+
+func (receiver *shard) Add(key string, data Data) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.hasKey(key) && !receiver.isKeyExpired(key) {
+		return 0, &KeyAlreadyExistsError{Key: key}
+	}
+
+	return receiver.set(key, data)
+}
+
+func (receiver *shard) Replace(key string, data Data) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if !receiver.hasKey(key) || receiver.isKeyExpired(key) {
+		return 0, &KeyNotFoundError{Key: key}
+	}
+
+	return receiver.set(key, data)
+}
+
+func (receiver *shard) Cas(key string, data Data, casUnique uint64) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	cachedData, err := receiver.get(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if cachedData.CasUnique != casUnique {
+		return 0, &CasMismatchError{Key: key}
+	}
+
+	return receiver.set(key, data)
+}
+
+func (receiver *shard) Append(key string, data Data) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	cachedData, err := receiver.get(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return receiver.set(key, Data{
+		Value:     concat(cachedData.Value, data.Value),
+		ByteCount: cachedData.ByteCount + data.ByteCount,
+		// There's no requirements in the project regarding the handling of these fields, so
+		// just leave it as it is
+		Flags:     cachedData.Flags,
+		ExpiresAt: cachedData.ExpiresAt,
+	})
+}
+
+func (receiver *shard) Prepend(key string, data Data) (uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	cachedData, err := receiver.get(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return receiver.set(key, Data{
+		Value:     concat(data.Value, cachedData.Value),
+		ByteCount: cachedData.ByteCount + data.ByteCount,
+		// There's no requirements in the project regarding the handling of these fields, so
+		// just leave it as it is
+		Flags:     cachedData.Flags,
+		ExpiresAt: cachedData.ExpiresAt,
+	})
+}
+
+// UpdateCounter replaces the numeric value of the key with the result of update. Returns the new value and its CAS unique
+func (receiver *shard) UpdateCounter(key string, update func(value uint64) uint64) (uint64, uint64, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	cachedData, err := receiver.get(key)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, parseErr := strconv.ParseUint(string(cachedData.Value), 10, 64)
+
+	if parseErr != nil {
+		return 0, 0, &NonNumericValueError{Key: key}
+	}
+
+	newValue := update(value)
+	cachedData.Value = []byte(strconv.FormatUint(newValue, 10))
+	cachedData.ByteCount = len(cachedData.Value)
+
+	casUnique, err := receiver.set(key, cachedData)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return newValue, casUnique, nil
+}
+
+// ClearExpiredData deletes all expired data and returns the number of records deleted. Only the entries that are due
+// are visited, unless most of the shard is due or it's the first run after a flush, in which case all of them are
+// swept at once
+func (receiver *shard) ClearExpiredData() int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	sizeBefore := receiver.size()
+	// Reading the clock and the flush time for every entry would take longer than the rest of the checks
+	now := receiver.timeSource.Now()
+	flushAt := receiver.flushAt.Load()
+	flushed := flushAt > receiver.clearedFlushAt && flushAt <= now.UnixNano()
+
+	// Removing an entry from the heap takes O(log n), so it's faster to go through every entry once and rebuild the
+	// heap when most of them are deleted anyway
+	if flushed || receiver.mostlyDue(now) {
+		receiver.sweep(now, flushAt)
+
+		if flushed {
+			receiver.clearedFlushAt = flushAt
+		}
+
+		return sizeBefore - receiver.size()
+	}
+
+	for e, ok := receiver.expiries.next(); ok && isExpired(e.data, now); e, ok = receiver.expiries.next() {
+		receiver.expire(e, now, true)
+	}
+
+	return sizeBefore - receiver.size()
+}
+
+// Clear deletes all the entries
+func (receiver *shard) Clear() {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	for node := receiver.entries.Front(); node != nil; {
+		next := node.Next()
+		receiver.delete(node.Value.(*entry).key)
+		node = next
+	}
+}
+
+// Items returns the keys and data of the entries that haven't expired or been flushed, from least to most recently used
+func (receiver *shard) Items() []snapshotItem {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	items := make([]snapshotItem, 0, receiver.size())
+
+	for node := receiver.entries.Front(); node != nil; node = node.Next() {
+		e := node.Value.(*entry)
+
+		if !receiver.isStale(e) {
+			items = append(items, snapshotItem{key: e.key, data: receiver.export(e)})
+		}
+	}
+
+	return items
+}
+
+// The functions below assume the caller holds the mutex
+
+func (receiver *shard) size() int {
+	return len(receiver.lookupTable)
+}
+
+// set stores the data and returns the CAS unique value assigned to it
+func (receiver *shard) set(key string, data Data) (uint64, error) {
+	return receiver.setWithCas(key, data, 0)
+}
+
+// setWithCas is the same as set, but the data gets casUnique rather than a new CAS unique value, unless it's zero
+func (receiver *shard) setWithCas(key string, data Data, casUnique uint64) (uint64, error) {
+	if len(key) < 1 {
+		return 0, &EmptyKeyError{}
+	}
+
+	size := itemSize(key, data)
+	// Allocating a chunk might evict other items, so it's only done if the item is within the memory limit
+	allocated := size <= receiver.memoryLimit
+	var chunk slabChunk
+
+	if allocated {
+		chunk, allocated = receiver.allocateChunk(key, data)
+	}
+
+	if !allocated {
+		// Same as memcached, the existing data is removed so clients don't keep reading stale data after a failed update
+		receiver.delete(key)
+
+		return 0, &ItemTooLargeError{Key: key, Size: size}
+	}
+
+	if casUnique == 0 {
+		casUnique = receiver.lastCasUnique.Add(1)
+	}
+
+	data.CasUnique = casUnique
+	stored := data
+	now := receiver.timeSource.Now().UnixNano()
+
+	if receiver.slabs != nil {
+		receiver.slabs.write(chunk, key, data.Value)
+		stored.Value = nil
+	}
+
+	// Allocating the chunk might have evicted the key, in which case it's stored as a new one
+	if element, exists := receiver.lookupTable[key]; exists {
+		e := element.Value.(*entry)
+		receiver.usedBytes += size - receiver.entrySize(e)
+		receiver.freeChunk(e)
+		e.chunk = chunk
+		e.data = stored
+		receiver.scheduleExpiry(e)
+		e.fetched = false
+		e.storedAt = now
+		e.lastAccessedAt = now
+		e.invalidated = false
+		e.winTokenSent = false
+		receiver.stats.TotalItems++
+		receiver.entries.MoveToBack(element)
+		receiver.policy.Access(key)
+
+		// The policy might pick the updated key itself as the victim (e.g., with LFU if it's still the least frequently
+		// used key), in which case the update is rejected. Listeners are only notified once the update is kept, so they
+		// never store data the shard dropped
+		receiver.evictWhile(func() bool {
+			return receiver.usedBytes > receiver.memoryLimit
+		})
+
+		if !receiver.hasKey(key) {
+			return 0, &ItemTooLargeError{Key: key, Size: size}
+		}
+
+		receiver.notify(Mutation{Type: MutationSet, Key: key, Data: data})
+
+		return casUnique, nil
+	}
+
+	receiver.evictWhile(func() bool {
+		return receiver.size() >= receiver.capacity || receiver.usedBytes+size > receiver.memoryLimit
+	})
+
+	e := &entry{
+		key:            key,
+		data:           stored,
+		storedAt:       now,
+		lastAccessedAt: now,
+		chunk:          chunk,
+		expiryIndex:    notInExpiryHeap,
+	}
+	receiver.lookupTable[key] = receiver.entries.PushBack(e)
+	receiver.scheduleExpiry(e)
+	receiver.usedBytes += size
+	receiver.stats.TotalItems++
+	receiver.policy.Insert(key)
+	receiver.notify(Mutation{Type: MutationSet, Key: key, Data: data})
+
+	return casUnique, nil
+}
+
+// evictWhile evicts keys picked by the eviction policy until the condition is no longer met or the shard is empty
+func (receiver *shard) evictWhile(condition func() bool) {
+	for condition() {
+		victim, ok := receiver.policy.Victim()
+
+		if !ok {
+			return
+		}
+
+		receiver.evict(receiver.lookupTable[victim].Value.(*entry))
+	}
+}
+
+// evict deletes the entry to free space for other entries
+func (receiver *shard) evict(e *entry) {
+	receiver.stats.Evictions++
+
+	if !e.fetched {
+		receiver.stats.EvictedUnfetched++
+	}
+
+	if receiver.slabs != nil {
+		class := receiver.slabs.classes[receiver.slabs.classOf(e.chunk)]
+		class.evictions++
+
+		if !e.fetched {
+			class.evictedUnfetched++
+		}
+	}
+
+	receiver.delete(e.key)
+}
+
+// allocateChunk returns a chunk of the slab allocator for the item. If there's no free chunk of the right size, the
+// least recently used items of the slab class are evicted. If the class has no items, the items in a page of another
+// class are evicted and the page is reassigned. Returns false if the item doesn't fit in a page. Always succeeds if
+// the slab allocator is disabled
+func (receiver *shard) allocateChunk(key string, data Data) (slabChunk, bool) {
+	if receiver.slabs == nil {
+		return slabChunk{}, true
+	}
+
+	class, fits := receiver.slabs.classFor(key, data.Value)
+
+	if !fits {
+		return slabChunk{}, false
+	}
+
+	for {
+		if chunk, ok := receiver.slabs.allocate(class); ok {
+			return chunk, true
+		}
+
+		if chunk, ok := receiver.slabs.leastRecentlyUsed(class); ok {
+			receiver.evict(receiver.lookupTable[receiver.slabs.key(chunk)].Value.(*entry))
+			continue
+		}
+
+		// There's always a page to reassign, since the allocator holds at least one page and none of them is in this
+		// class
+		page, ok := receiver.slabs.donorPage(class)
+
+		if !ok {
+			return slabChunk{}, false
+		}
+
+		for _, key := range receiver.slabs.keysInPage(page) {
+			receiver.evict(receiver.lookupTable[key].Value.(*entry))
+		}
+
+		receiver.slabs.reassignPage(page, class)
+	}
+}
+
+// freeChunk returns the chunk of the entry to the slab allocator. No effect if the slab allocator is disabled
+func (receiver *shard) freeChunk(e *entry) {
+	if receiver.slabs != nil {
+		receiver.slabs.free(e.chunk)
+	}
+}
+
+// export returns the data of the entry. Values stored by the slab allocator are copied out of their chunk, since it's
+// overwritten once it's reused
+func (receiver *shard) export(e *entry) Data {
+	data := e.data
+
+	if receiver.slabs != nil {
+		data.Value = bytes.Clone(receiver.slabs.value(e.chunk))
+	}
+
+	return data
+}
+
+// entrySize returns the number of bytes accounted for the entry (see itemSize)
+func (receiver *shard) entrySize(e *entry) int64 {
+	if receiver.slabs != nil {
+		return int64(len(e.key) + receiver.slabs.valueLength(e.chunk) + itemOverhead)
+	}
+
+	return itemSize(e.key, e.data)
+}
+
+func (receiver *shard) get(key string) (Data, error) {
+	e, now, err := receiver.lookup(key)
+
+	if err != nil {
+		return Data{}, err
+	}
+
+	receiver.access(e, now)
+
+	return receiver.export(e), nil
+}
+
+// peek is the same as get, but the access isn't recorded by the eviction policy
+func (receiver *shard) peek(key string) (Data, error) {
+	e, _, err := receiver.lookup(key)
+
+	if err != nil {
+		return Data{}, err
+	}
+
+	return receiver.export(e), nil
+}
+
+// lookup returns the entry of the key along with the current time. Entries that expired or were flushed are deleted
+// and reported as not found
+func (receiver *shard) lookup(key string) (*entry, time.Time, error) {
+	if len(key) < 1 {
+		return nil, time.Time{}, &EmptyKeyError{}
+	}
+
+	element, exists := receiver.lookupTable[key]
+
+	if !exists {
+		return nil, time.Time{}, &KeyNotFoundError{key}
+	}
+
+	e := element.Value.(*entry)
+	now := receiver.timeSource.Now()
+
+	if isStale(e, now, receiver.flushAt.Load()) {
+		receiver.expire(e, now, false)
+
+		return nil, time.Time{}, &KeyNotFoundError{key}
+	}
+
+	return e, now, nil
+}
+
+// access records a read of the entry, e.g., for the eviction policy
+func (receiver *shard) access(e *entry, now time.Time) {
+	e.lastAccessedAt = now.UnixNano()
+	receiver.entries.MoveToBack(receiver.lookupTable[e.key])
+	receiver.policy.Access(e.key)
+
+	if receiver.slabs != nil {
+		receiver.slabs.touch(e.chunk)
+	}
+}
+
+// touch updates the expiration time of the key without assigning a new CAS unique value. Returns the updated data
+func (receiver *shard) touch(key string, expiresAt time.Time) (Data, error) {
+	if _, err := receiver.get(key); err != nil {
+		return Data{}, err
+	}
+
+	e := receiver.lookupTable[key].Value.(*entry)
+	e.data.ExpiresAt = expiresAt
+	receiver.scheduleExpiry(e)
+	data := receiver.export(e)
+	receiver.notify(Mutation{Type: MutationSet, Key: key, Data: data})
+
+	return data, nil
+}
+
+// expire deletes an entry that is expired or flushed by now. Flushed entries aren't counted as expired. Proactive
+// expirations are the ones made by the cleanup task rather than when the entry is accessed
+func (receiver *shard) expire(e *entry, now time.Time, proactive bool) {
+	expired := isExpired(e.data, now)
+
+	switch {
+	case expired && proactive:
+		receiver.stats.ExpiredProactively++
+	case expired:
+		receiver.stats.ExpiredLazily++
+	}
+
+	if expired && !e.fetched {
+		receiver.stats.ExpiredUnfetched++
+
+		if receiver.slabs != nil {
+			receiver.slabs.classes[receiver.slabs.classOf(e.chunk)].expiredUnfetched++
+		}
+	}
+
+	receiver.delete(e.key)
+}
+
+// mostlyDue estimates whether most of the entries of the shard are due by sampling the expiry heap evenly
+func (receiver *shard) mostlyDue(now time.Time) bool {
+	length := len(receiver.expiries)
+
+	// Small heaps are cheap to go through either way
+	if length < expirySampleSize {
+		return false
+	}
+
+	due := 0
+
+	for i := range expirySampleSize {
+		if isExpired(receiver.expiries[i*length/expirySampleSize].data, now) {
+			due++
+		}
+	}
+
+	return 2*due*length/expirySampleSize > receiver.size()
+}
+
+// sweep deletes every entry that is expired or flushed by now in a single pass, then rebuilds the expiry heap
+func (receiver *shard) sweep(now time.Time, flushAt int64) {
+	// Iterating through the list is much faster (10-20x) than iterating through keys of the lookupTable.
+	for node := receiver.entries.Front(); node != nil; {
+		next := node.Next()
+		e := node.Value.(*entry)
+
+		if isStale(e, now, flushAt) {
+			// Detached so deleting the entry doesn't update the heap. It's removed along with the rest below
+			e.expiryIndex = notInExpiryHeap
+			receiver.expire(e, now, true)
+		}
+
+		node = next
+	}
+
+	receiver.expiries.removeDetached()
+	heap.Init(&receiver.expiries)
+}
+
+// scheduleExpiry adds, moves, or removes the entry in the expiry heap after its expiration time changed
+func (receiver *shard) scheduleExpiry(e *entry) {
+	expires := e.data.ExpiresAt.UnixMilli() > 0
+
+	switch {
+	case e.expiryIndex != notInExpiryHeap && expires:
+		heap.Fix(&receiver.expiries, e.expiryIndex)
+	case e.expiryIndex != notInExpiryHeap:
+		heap.Remove(&receiver.expiries, e.expiryIndex)
+	case expires:
+		heap.Push(&receiver.expiries, e)
+	}
+}
+
+// unscheduleExpiry removes the entry from the expiry heap, if it's there
+func (receiver *shard) unscheduleExpiry(e *entry) {
+	if e.expiryIndex != notInExpiryHeap {
+		heap.Remove(&receiver.expiries, e.expiryIndex)
+	}
+}
+
+// isStale returns whether the entry expired or was invalidated by a flush
+func (receiver *shard) isStale(e *entry) bool {
+	return isStale(e, receiver.timeSource.Now(), receiver.flushAt.Load())
+}
+
+func (receiver *shard) delete(key string) error {
+	element, exists := receiver.lookupTable[key]
+
+	if !exists {
+		return nil
+	}
+
+	receiver.usedBytes -= receiver.entrySize(element.Value.(*entry))
+	receiver.freeChunk(element.Value.(*entry))
+	receiver.unscheduleExpiry(element.Value.(*entry))
+	receiver.entries.Remove(element)
+	receiver.policy.Remove(key)
+	delete(receiver.lookupTable, key)
+	receiver.notify(Mutation{Type: MutationDelete, Key: key})
+
+	return nil
+}
+
+func (receiver *shard) notify(mutation Mutation) {
+	notifyListeners(*receiver.listeners.Load(), mutation)
+}
+
+func (receiver *shard) hasKey(key string) bool {
+	_, ok := receiver.lookupTable[key]
+
+	return ok
+}
+
+func (receiver *shard) isKeyExpired(key string) bool {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok {
+		return false
+	}
+
+	return receiver.isStale(element.Value.(*entry))
+}
+
+// isStale returns whether the entry is expired or invalidated by a flush at flushAt (in Unix nanoseconds) by now
+func isStale(e *entry, now time.Time, flushAt int64) bool {
+	return isExpired(e.data, now) || (flushAt > 0 && flushAt <= now.UnixNano() && e.storedAt <= flushAt)
+}
+
+// Approximate number of bytes used by each item on top of the key and value, e.g., for the list elements, map entry
+// and the rest of the fields of Data. Similar to the item header overhead in memcached
+const itemOverhead = 64
+
+// itemSize number of bytes accounted for an item when enforcing the memory limit
+func itemSize(key string, data Data) int64 {
+	return int64(len(key) + len(data.Value) + itemOverhead)
+}
+
+// concat returns a new slice with the contents of a followed by b. Stored values are never modified in place, since
+// they might still be referenced by callers of Get
+func concat(a []byte, b []byte) []byte {
+	result := make([]byte, 0, len(a)+len(b))
+	result = append(result, a...)
+
+	return append(result, b...)
+}
@@ -0,0 +1,384 @@
+package cache
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Size of the pages allocated by the slab allocator. Same as memcached, it's also the size of the largest item
+const slabPageSize = 1024 * 1024
+
+// Size of the chunks of the smallest slab class
+const minSlabChunkSize = 64
+
+const defaultSlabGrowthFactor = 1.25
+
+// Each chunk starts with a header holding the length of the key and value stored in it, followed by the previous and
+// next chunks in the LRU of its class. Free chunks have a key length of 0, since keys can't be empty
+const (
+	slabKeyLengthOffset   = 0
+	slabValueLengthOffset = 4
+	slabPreviousOffset    = 8
+	slabNextOffset        = 16
+	slabChunkHeaderSize   = 24
+)
+
+// slabAllocator stores the keys and values of a shard in chunks carved out of large pages, similar to memcached.
+// Chunks are grouped by size into slab classes, each growing by a constant factor, and items are stored in the
+// smallest chunk they fit in.
+//
+// Pages don't contain pointers, so the garbage collector doesn't need to scan them, and values don't need a heap
+// object each. The trade-off is that values have to be copied when they leave the cache, and that memory is wasted
+// when items are much smaller than their chunks.
+//
+// Not safe for concurrent use. It's guarded by the lock of the shard that owns it
+type slabAllocator struct {
+	classes []*slabClass
+	// Every page allocated so far, along with the index of the class it's assigned to
+	pages     [][]byte
+	pageClass []int
+	// Maximum number of pages the allocator can hold
+	maxPages int
+}
+
+// slabClass pages split into chunks of the same size
+type slabClass struct {
+	chunkSize     int
+	chunksPerPage int
+	numPages      int
+	// Chunks of the pages of the class that don't hold an item
+	freeChunks []slabChunk
+	// Least and most recently used chunks holding an item. Items are evicted from the class they need a chunk of, since
+	// evicting items of any other class wouldn't free a chunk of the right size
+	front slabChunk
+	back  slabChunk
+	// Number of bytes requested by the items stored in the class, not counting the unused space of their chunks
+	requestedBytes   int64
+	evictions        uint64
+	evictedUnfetched uint64
+	expiredUnfetched uint64
+}
+
+// slabChunk location of a chunk
+type slabChunk struct {
+	page   int32
+	offset int32
+}
+
+// Marks the ends of the LRU of a class
+var noSlabChunk = slabChunk{page: -1, offset: -1}
+
+// SlabClassStats counters for a slab class of the slab allocator (see WithSlabAllocator). Names follow the equivalent
+// memcached stats
+type SlabClassStats struct {
+	// Starts at 1, same as memcached
+	ID            int
+	ChunkSize     int
+	ChunksPerPage int
+	TotalPages    int
+	UsedChunks    int
+	FreeChunks    int
+	// Number of bytes requested by the items in the class, not counting the unused space of their chunks
+	RequestedBytes   int64
+	Evictions        uint64
+	EvictedUnfetched uint64
+	ExpiredUnfetched uint64
+}
+
+// newSlabAllocator creates an allocator that holds up to memoryLimit bytes of pages, but at least one page. Each
+// slab class has chunks growthFactor times larger than the previous one
+func newSlabAllocator(memoryLimit int64, growthFactor float64) *slabAllocator {
+	maxPages := math.MaxInt
+
+	if memoryLimit != math.MaxInt64 {
+		maxPages = int(max(memoryLimit/slabPageSize, 1))
+	}
+
+	allocator := &slabAllocator{maxPages: maxPages}
+
+	for chunkSize := minSlabChunkSize; ; {
+		allocator.classes = append(allocator.classes, &slabClass{
+			chunkSize:     chunkSize,
+			chunksPerPage: slabPageSize / chunkSize,
+			front:         noSlabChunk,
+			back:          noSlabChunk,
+		})
+
+		if chunkSize == slabPageSize {
+			return allocator
+		}
+
+		// Chunks are aligned to 8 bytes, and every class is at least 8 bytes larger than the previous one
+		next := (int(float64(chunkSize)*growthFactor) + 7) &^ 7
+		chunkSize = min(max(next, chunkSize+8), slabPageSize)
+
+		// Classes that would fit the same number of chunks per page as the largest one are skipped
+		if slabPageSize/chunkSize == 1 {
+			chunkSize = slabPageSize
+		}
+	}
+}
+
+// classFor returns the index of the smallest class with chunks large enough for the item. Returns false if the item
+// is larger than a page
+func (receiver *slabAllocator) classFor(key string, value []byte) (int, bool) {
+	size := slabChunkHeaderSize + len(key) + len(value)
+
+	for i, class := range receiver.classes {
+		if size <= class.chunkSize {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// classOf returns the index of the class of the chunk
+func (receiver *slabAllocator) classOf(chunk slabChunk) int {
+	return receiver.pageClass[chunk.page]
+}
+
+// allocate returns a free chunk of the class, allocating a new page if needed. Returns false if the class has no free
+// chunks and there's no room for more pages
+func (receiver *slabAllocator) allocate(classIndex int) (slabChunk, bool) {
+	class := receiver.classes[classIndex]
+
+	if len(class.freeChunks) == 0 {
+		if len(receiver.pages) >= receiver.maxPages {
+			return slabChunk{}, false
+		}
+
+		receiver.pages = append(receiver.pages, make([]byte, slabPageSize))
+		receiver.pageClass = append(receiver.pageClass, classIndex)
+		receiver.assignPage(len(receiver.pages)-1, classIndex)
+	}
+
+	last := len(class.freeChunks) - 1
+	chunk := class.freeChunks[last]
+	class.freeChunks = class.freeChunks[:last]
+
+	return chunk, true
+}
+
+// write stores the item in the chunk and makes it the most recently used item of its class
+func (receiver *slabAllocator) write(chunk slabChunk, key string, value []byte) {
+	bytes := receiver.bytes(chunk)
+
+	binary.LittleEndian.PutUint32(bytes[slabKeyLengthOffset:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(bytes[slabValueLengthOffset:], uint32(len(value)))
+	copy(bytes[slabChunkHeaderSize:], key)
+	copy(bytes[slabChunkHeaderSize+len(key):], value)
+
+	class := receiver.classes[receiver.classOf(chunk)]
+	class.requestedBytes += int64(slabChunkHeaderSize + len(key) + len(value))
+	receiver.pushBack(class, chunk)
+}
+
+// free returns the chunk to its class. The item stored in it is no longer valid
+func (receiver *slabAllocator) free(chunk slabChunk) {
+	class := receiver.classes[receiver.classOf(chunk)]
+
+	receiver.remove(class, chunk)
+	class.requestedBytes -= int64(slabChunkHeaderSize + receiver.keyLength(chunk) + receiver.valueLength(chunk))
+	binary.LittleEndian.PutUint32(receiver.bytes(chunk)[slabKeyLengthOffset:], 0)
+	class.freeChunks = append(class.freeChunks, chunk)
+}
+
+// key returns the key stored in the chunk
+func (receiver *slabAllocator) key(chunk slabChunk) string {
+	return string(receiver.bytes(chunk)[slabChunkHeaderSize : slabChunkHeaderSize+receiver.keyLength(chunk)])
+}
+
+// value returns the value stored in the chunk. It references the page of the chunk, so it's overwritten once the
+// chunk is reused
+func (receiver *slabAllocator) value(chunk slabChunk) []byte {
+	start := slabChunkHeaderSize + receiver.keyLength(chunk)
+
+	return receiver.bytes(chunk)[start : start+receiver.valueLength(chunk)]
+}
+
+func (receiver *slabAllocator) keyLength(chunk slabChunk) int {
+	return int(binary.LittleEndian.Uint32(receiver.bytes(chunk)[slabKeyLengthOffset:]))
+}
+
+func (receiver *slabAllocator) valueLength(chunk slabChunk) int {
+	return int(binary.LittleEndian.Uint32(receiver.bytes(chunk)[slabValueLengthOffset:]))
+}
+
+// touch makes the item in the chunk the most recently used item of its class
+func (receiver *slabAllocator) touch(chunk slabChunk) {
+	class := receiver.classes[receiver.classOf(chunk)]
+
+	receiver.remove(class, chunk)
+	receiver.pushBack(class, chunk)
+}
+
+// leastRecentlyUsed returns the chunk of the least recently used item of the class. Returns false if the class has no
+// items
+func (receiver *slabAllocator) leastRecentlyUsed(classIndex int) (slabChunk, bool) {
+	front := receiver.classes[classIndex].front
+
+	return front, front != noSlabChunk
+}
+
+// keysInPage returns the keys of the items stored in the page
+func (receiver *slabAllocator) keysInPage(page int) []string {
+	class := receiver.classes[receiver.pageClass[page]]
+	var keys []string
+
+	for offset := 0; offset+class.chunkSize <= slabPageSize; offset += class.chunkSize {
+		chunk := slabChunk{page: int32(page), offset: int32(offset)}
+
+		if receiver.keyLength(chunk) > 0 {
+			keys = append(keys, receiver.key(chunk))
+		}
+	}
+
+	return keys
+}
+
+// reassignPage moves a page without items to another class
+func (receiver *slabAllocator) reassignPage(page int, classIndex int) {
+	donor := receiver.classes[receiver.pageClass[page]]
+	freeChunks := donor.freeChunks[:0]
+
+	for _, chunk := range donor.freeChunks {
+		if int(chunk.page) != page {
+			freeChunks = append(freeChunks, chunk)
+		}
+	}
+
+	donor.freeChunks = freeChunks
+	donor.numPages--
+	receiver.pageClass[page] = classIndex
+	receiver.assignPage(page, classIndex)
+}
+
+// assignPage splits the page into free chunks of the class
+func (receiver *slabAllocator) assignPage(page int, classIndex int) {
+	class := receiver.classes[classIndex]
+	class.numPages++
+
+	// Chunks are added in reverse, so they're handed out from the start of the page
+	for i := class.chunksPerPage - 1; i >= 0; i-- {
+		class.freeChunks = append(class.freeChunks, slabChunk{page: int32(page), offset: int32(i * class.chunkSize)})
+	}
+}
+
+// donorPage returns a page to be reassigned to the class, which is one of the pages of the class with the most pages.
+// The page holding the least recently used item of that class is preferred, since its items are the least likely to
+// be read again. Returns false if no other class has pages
+func (receiver *slabAllocator) donorPage(classIndex int) (int, bool) {
+	donorIndex := -1
+
+	for i, class := range receiver.classes {
+		if i != classIndex && class.numPages > 0 && (donorIndex < 0 || class.numPages > receiver.classes[donorIndex].numPages) {
+			donorIndex = i
+		}
+	}
+
+	if donorIndex < 0 {
+		return 0, false
+	}
+
+	if chunk, ok := receiver.leastRecentlyUsed(donorIndex); ok {
+		return int(chunk.page), true
+	}
+
+	for page, class := range receiver.pageClass {
+		if class == donorIndex {
+			return page, true
+		}
+	}
+
+	return 0, false
+}
+
+func (receiver *slabAllocator) Stats() []SlabClassStats {
+	stats := make([]SlabClassStats, len(receiver.classes))
+
+	for i, class := range receiver.classes {
+		totalChunks := class.numPages * class.chunksPerPage
+
+		stats[i] = SlabClassStats{
+			ID:               i + 1,
+			ChunkSize:        class.chunkSize,
+			ChunksPerPage:    class.chunksPerPage,
+			TotalPages:       class.numPages,
+			UsedChunks:       totalChunks - len(class.freeChunks),
+			FreeChunks:       len(class.freeChunks),
+			RequestedBytes:   class.requestedBytes,
+			Evictions:        class.evictions,
+			EvictedUnfetched: class.evictedUnfetched,
+			ExpiredUnfetched: class.expiredUnfetched,
+		}
+	}
+
+	return stats
+}
+
+func (receiver *slabAllocator) bytes(chunk slabChunk) []byte {
+	return receiver.pages[chunk.page][chunk.offset:]
+}
+
+func (receiver *slabAllocator) pushBack(class *slabClass, chunk slabChunk) {
+	receiver.setLink(chunk, slabPreviousOffset, class.back)
+	receiver.setLink(chunk, slabNextOffset, noSlabChunk)
+
+	if class.back != noSlabChunk {
+		receiver.setLink(class.back, slabNextOffset, chunk)
+	} else {
+		class.front = chunk
+	}
+
+	class.back = chunk
+}
+
+func (receiver *slabAllocator) remove(class *slabClass, chunk slabChunk) {
+	previous := receiver.link(chunk, slabPreviousOffset)
+	next := receiver.link(chunk, slabNextOffset)
+
+	if previous != noSlabChunk {
+		receiver.setLink(previous, slabNextOffset, next)
+	} else {
+		class.front = next
+	}
+
+	if next != noSlabChunk {
+		receiver.setLink(next, slabPreviousOffset, previous)
+	} else {
+		class.back = previous
+	}
+}
+
+// link returns the chunk stored at the offset of the header of the chunk
+func (receiver *slabAllocator) link(chunk slabChunk, offset int) slabChunk {
+	bytes := receiver.bytes(chunk)[offset:]
+
+	return slabChunk{
+		page:   int32(binary.LittleEndian.Uint32(bytes)),
+		offset: int32(binary.LittleEndian.Uint32(bytes[4:])),
+	}
+}
+
+func (receiver *slabAllocator) setLink(chunk slabChunk, offset int, target slabChunk) {
+	bytes := receiver.bytes(chunk)[offset:]
+
+	binary.LittleEndian.PutUint32(bytes, uint32(target.page))
+	binary.LittleEndian.PutUint32(bytes[4:], uint32(target.offset))
+}
+
+func (receiver SlabClassStats) add(other SlabClassStats) SlabClassStats {
+	return SlabClassStats{
+		ID:               receiver.ID,
+		ChunkSize:        receiver.ChunkSize,
+		ChunksPerPage:    receiver.ChunksPerPage,
+		TotalPages:       receiver.TotalPages + other.TotalPages,
+		UsedChunks:       receiver.UsedChunks + other.UsedChunks,
+		FreeChunks:       receiver.FreeChunks + other.FreeChunks,
+		RequestedBytes:   receiver.RequestedBytes + other.RequestedBytes,
+		Evictions:        receiver.Evictions + other.Evictions,
+		EvictedUnfetched: receiver.EvictedUnfetched + other.EvictedUnfetched,
+		ExpiredUnfetched: receiver.ExpiredUnfetched + other.ExpiredUnfetched,
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// Run with `go test -bench Slab -run ^$ ./cache/` to compare the work of the garbage collector and the allocations
+// with and without the slab allocator
+
+const numSlabBenchmarkKeys = 500_000
+
+const slabBenchmarkValueSize = 100
+
+var slabBenchmarkOptions = map[string][]Option{
+	"slabs=off": {WithShards(16)},
+	"slabs=on":  {WithShards(16), WithSlabAllocator(0)},
+}
+
+// BenchmarkSlabGC runs full garbage collections while a large cache is alive, and reports the time each collection
+// takes, the time the program was paused for, and the number of objects in the heap. Values are stored in the pages
+// of the slab allocator rather than in a heap object each
+func BenchmarkSlabGC(b *testing.B) {
+	for _, name := range []string{"slabs=off", "slabs=on"} {
+		b.Run(name, func(b *testing.B) {
+			cache := New(-1, slabBenchmarkOptions[name]...)
+			fillSlabBenchmarkCache(cache)
+
+			runtime.GC()
+			var before runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			b.ResetTimer()
+
+			for range b.N {
+				runtime.GC()
+			}
+
+			b.StopTimer()
+
+			var after runtime.MemStats
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+			b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+			runtime.KeepAlive(cache)
+		})
+	}
+}
+
+// BenchmarkSlabSet updates the keys of a full cache with values read from a client, which are allocated for each
+// request, and reports the number of objects left in the heap afterward
+func BenchmarkSlabSet(b *testing.B) {
+	for _, name := range []string{"slabs=off", "slabs=on"} {
+		b.Run(name, func(b *testing.B) {
+			cache := New(-1, slabBenchmarkOptions[name]...)
+			fillSlabBenchmarkCache(cache)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := range b.N {
+				value := make([]byte, slabBenchmarkValueSize)
+				cache.Set(strconv.Itoa(i%numSlabBenchmarkKeys), Data{Value: value, ByteCount: len(value)})
+			}
+
+			b.StopTimer()
+
+			runtime.GC()
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+
+			b.ReportMetric(float64(stats.HeapObjects), "heap-objects")
+			runtime.KeepAlive(cache)
+		})
+	}
+}
+
+func fillSlabBenchmarkCache(cache *Cache) {
+	for i := range numSlabBenchmarkKeys {
+		value := []byte(fmt.Sprintf("%0*d", slabBenchmarkValueSize, i))
+		cache.Set(strconv.Itoa(i), Data{Value: value, ByteCount: len(value)})
+	}
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewSlabAllocator(t *testing.T) {
+	allocator := newSlabAllocator(slabPageSize, 1.25)
+
+	if first := allocator.classes[0].chunkSize; first != minSlabChunkSize {
+		t.Fatalf("Unexpected chunk size of the first class: %d\n", first)
+	}
+
+	if last := allocator.classes[len(allocator.classes)-1].chunkSize; last != slabPageSize {
+		t.Fatalf("Unexpected chunk size of the last class: %d\n", last)
+	}
+
+	for i := 1; i < len(allocator.classes); i++ {
+		previous, current := allocator.classes[i-1], allocator.classes[i]
+
+		if current.chunkSize <= previous.chunkSize || current.chunkSize%8 != 0 {
+			t.Fatalf("Unexpected chunk size of class %d: %d. Previous: %d\n", i, current.chunkSize, previous.chunkSize)
+		}
+	}
+}
+
+func TestSlabAllocatorSetAndGet(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+
+	if _, err := cache.Set("key", Data{Value: []byte("hello"), ByteCount: 5, Flags: 3}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	data, err := cache.Get("key")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if string(data.Value) != "hello" || data.Flags != 3 || data.ByteCount != 5 {
+		t.Fatalf("Unexpected data: %+v\n", data)
+	}
+}
+
+func TestSlabAllocatorReturnsCopies(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+	cache.Set("key1", testData("hello"))
+
+	data, _ := cache.Get("key1")
+
+	// The chunk of key1 is reused by key2
+	cache.Delete("key1")
+	cache.Set("key2", testData("world"))
+
+	if string(data.Value) != "hello" {
+		t.Fatalf("Unexpected value: '%s'\n", data.Value)
+	}
+
+	data.Value[0] = 'j'
+
+	if data, _ := cache.Get("key2"); string(data.Value) != "world" {
+		t.Fatalf("Unexpected value: '%s'\n", data.Value)
+	}
+}
+
+func TestSlabAllocatorCommands(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+	cache.Set("key", testData("hello"))
+	cache.Append("key", testData(" world"))
+	cache.Prepend("key", testData(strings.Repeat("a", 100)))
+
+	data, _ := cache.Get("key")
+
+	if expected := strings.Repeat("a", 100) + "hello world"; string(data.Value) != expected {
+		t.Fatalf("Unexpected value: '%s'\n", data.Value)
+	}
+
+	cache.Set("counter", testData("41"))
+
+	if value, _, err := cache.Increment("counter", 1); err != nil || value != 42 {
+		t.Fatalf("Unexpected result: %d, %v\n", value, err)
+	}
+
+	if data, _ := cache.Get("counter"); string(data.Value) != "42" {
+		t.Fatalf("Unexpected value: '%s'\n", data.Value)
+	}
+}
+
+func TestSlabAllocatorItemTooLarge(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+	cache.Set("key", testData("hello"))
+
+	_, err := cache.Set("key", testData(strings.Repeat("a", slabPageSize)))
+
+	target := &ItemTooLargeError{}
+	if !errors.As(err, &target) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	// Same as without the slab allocator, the previous data is removed
+	if _, err := cache.Get("key"); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestSlabAllocatorEvictsFromSameClass(t *testing.T) {
+	cache := New(-1, WithMemoryLimit(slabPageSize), WithSlabAllocator(0))
+	value := strings.Repeat("a", 100)
+	class, _ := cache.shards[0].slabs.classFor("key0000", []byte(value))
+	chunksPerPage := cache.shards[0].slabs.classes[class].chunksPerPage
+
+	for i := range chunksPerPage + 1 {
+		if _, err := cache.Set(fmt.Sprintf("key%04d", i), testData(value)); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	if _, err := cache.Get("key0000"); err == nil {
+		t.Fatal("Expected the least recently used key to be evicted")
+	}
+
+	stats := cache.SlabStats()[class]
+
+	if stats.TotalPages != 1 || stats.UsedChunks != chunksPerPage || stats.Evictions != 1 || stats.EvictedUnfetched != 1 {
+		t.Fatalf("Unexpected stats: %+v\n", stats)
+	}
+
+	if evictions := cache.Stats().Evictions; evictions != 1 {
+		t.Fatalf("Unexpected evictions: %d\n", evictions)
+	}
+}
+
+func TestSlabAllocatorReassignsPages(t *testing.T) {
+	cache := New(-1, WithMemoryLimit(slabPageSize), WithSlabAllocator(0))
+
+	for i := range 10 {
+		cache.Set(fmt.Sprintf("small%d", i), testData("hello"))
+	}
+
+	// The only page is assigned to the class of the small items, so it's reassigned to the class of the large one
+	if _, err := cache.Set("large", testData(strings.Repeat("a", slabPageSize/2))); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if size := cache.Size(); size != 1 {
+		t.Fatalf("Unexpected size: %d\n", size)
+	}
+
+	var pages []int
+
+	for _, stats := range cache.SlabStats() {
+		if stats.TotalPages > 0 {
+			pages = append(pages, stats.ChunkSize)
+		}
+	}
+
+	if len(pages) != 1 || pages[0] < slabPageSize/2 {
+		t.Fatalf("Unexpected classes with pages: %v\n", pages)
+	}
+}
+
+func TestSlabAllocatorUpdateChangesClass(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+	cache.Set("key", testData("hello"))
+	cache.Set("key", testData(strings.Repeat("a", 1000)))
+
+	used := 0
+	var requested int64
+
+	for _, stats := range cache.SlabStats() {
+		used += stats.UsedChunks
+		requested += stats.RequestedBytes
+	}
+
+	if used != 1 || requested != int64(slabChunkHeaderSize+len("key")+1000) {
+		t.Fatalf("Unexpected chunks used: %d, bytes requested: %d\n", used, requested)
+	}
+
+	cache.Delete("key")
+
+	for _, stats := range cache.SlabStats() {
+		if stats.UsedChunks != 0 || stats.RequestedBytes != 0 {
+			t.Fatalf("Unexpected stats: %+v\n", stats)
+		}
+	}
+}
+
+func TestSlabAllocatorSnapshot(t *testing.T) {
+	cache := New(-1, WithSlabAllocator(0))
+	cache.Set("key1", testData("hello"))
+	cache.Set("key2", testData("world"))
+
+	var snapshot bytes.Buffer
+
+	if _, err := cache.WriteSnapshot(&snapshot); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	restored := New(-1)
+
+	if _, err := restored.ReadSnapshot(&snapshot); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	for key, expected := range map[string]string{"key1": "hello", "key2": "world"} {
+		data, err := restored.Get(key)
+
+		if err != nil || !reflect.DeepEqual(data.Value, []byte(expected)) {
+			t.Fatalf("Unexpected result for %s: %v, %v\n", key, data, err)
+		}
+	}
+}
+
+func TestSlabStatsDisabled(t *testing.T) {
+	if stats := New(-1).SlabStats(); stats != nil {
+		t.Fatalf("Unexpected stats: %v\n", stats)
+	}
+}
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"memcached-server/utils"
+	"os"
+	"time"
+)
+
+// Snapshot format:
+//
+//	header: magic (6 bytes, "MCSNAP") | version (uint16)
+//	item:   marker (1 byte, 1) | key length (uint32) | key | flags (uint16) | expires at (int64, Unix milliseconds, 0 if
+//	        the item never expires) | value length (uint32) | value
+//	end:    marker (1 byte, 0)
+//
+// Integers are big-endian. Items are written from least to most recently used within each shard, so loading them in
+// order restores their recency
+const snapshotMagic = "MCSNAP"
+const snapshotVersion uint16 = 1
+
+const snapshotItemMarker byte = 1
+const snapshotEndMarker byte = 0
+
+// invalidLengthError key or value length read from a snapshot or mutation that's longer than the cache ever stores.
+// Reported before allocating the key or value, so corrupt or hostile input can't make the cache allocate gigabytes
+type invalidLengthError struct {
+	field  string
+	length uint32
+	max    int
+}
+
+func (e *invalidLengthError) Error() string {
+	return fmt.Sprintf("%s length %d exceeds maximum of %d", e.field, e.length, e.max)
+}
+
+type snapshotItem struct {
+	key  string
+	data Data
+}
+
+// WriteSnapshot writes the items in the cache to w. Expired and flushed items are skipped. Shards are locked one at a
+// time, so the cache remains available while the snapshot is written. Returns the number of items written
+func (receiver *Cache) WriteSnapshot(w io.Writer) (int, error) {
+	writer := bufio.NewWriter(w)
+
+	writer.WriteString(snapshotMagic)
+	binary.Write(writer, binary.BigEndian, snapshotVersion)
+
+	count := 0
+
+	for _, s := range receiver.shards {
+		for _, item := range s.Items() {
+			if err := writeSnapshotItem(writer, item); err != nil {
+				return count, fmt.Errorf("error writing snapshot: %v", err)
+			}
+
+			count++
+		}
+	}
+
+	writer.WriteByte(snapshotEndMarker)
+
+	if err := writer.Flush(); err != nil {
+		return count, fmt.Errorf("error writing snapshot: %v", err)
+	}
+
+	return count, nil
+}
+
+// ReadSnapshot stores the items of a snapshot written by WriteSnapshot. Items that expired since the snapshot was
+// written or don't fit in the cache are skipped, and the items stored get new CAS unique values. Returns the number of items stored, and
+// InvalidSnapshotError or UnsupportedSnapshotVersionError if r doesn't contain a snapshot this version can read
+func (receiver *Cache) ReadSnapshot(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(snapshotMagic)+2)
+
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, &InvalidSnapshotError{Reason: "missing header"}
+	}
+
+	if version := binary.BigEndian.Uint16(header[len(snapshotMagic):]); version != snapshotVersion {
+		return 0, &UnsupportedSnapshotVersionError{Version: version}
+	}
+
+	count := 0
+
+	for {
+		item, ok, err := readSnapshotItem(reader)
+
+		if err != nil {
+			return count, err
+		}
+
+		if !ok {
+			return count, nil
+		}
+
+		if isExpired(item.data, receiver.timeSource.Now()) {
+			continue
+		}
+
+		// Items might not fit if the memory limit was lowered since the snapshot was written
+		itemTooLargeError := &ItemTooLargeError{}
+		if _, err := receiver.Set(item.key, item.data); errors.As(err, &itemTooLargeError) {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+}
+
+// SaveSnapshot writes a snapshot of the cache to the file at path (see WriteSnapshot). The file is replaced
+// atomically, so the previous snapshot is kept if writing fails. Returns the number of items written
+func (receiver *Cache) SaveSnapshot(path string) (int, error) {
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+
+	if err != nil {
+		return 0, fmt.Errorf("error creating snapshot file: %v", err)
+	}
+
+	defer os.Remove(tempPath)
+
+	count, err := receiver.WriteSnapshot(file)
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		return 0, fmt.Errorf("error saving snapshot file: %v", err)
+	}
+
+	return count, nil
+}
+
+// LoadSnapshot stores the items of the snapshot file at path (see ReadSnapshot). Returns the number of items stored
+func (receiver *Cache) LoadSnapshot(path string) (int, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	return receiver.ReadSnapshot(file)
+}
+
+func writeSnapshotItem(writer *bufio.Writer, item snapshotItem) error {
+	writer.WriteByte(snapshotItemMarker)
+
+	return writeItem(writer, item.key, item.data)
+}
+
+// writeItem writes the key and data of an item, in the format used by snapshots and the append-only log
+func writeItem(writer io.Writer, key string, data Data) error {
+	expiresAt := data.ExpiresAt.UnixMilli()
+
+	// Data that never expires might have a zero ExpiresAt rather than `time.UnixMilli(0)`
+	if expiresAt < 0 {
+		expiresAt = 0
+	}
+
+	if err := writeKey(writer, key); err != nil {
+		return err
+	}
+
+	for _, field := range []any{data.Flags, expiresAt, uint32(len(data.Value))} {
+		if err := binary.Write(writer, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.Write(data.Value)
+
+	return err
+}
+
+func writeKey(writer io.Writer, key string) error {
+	if err := binary.Write(writer, binary.BigEndian, uint32(len(key))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(writer, key)
+
+	return err
+}
+
+// readSnapshotItem returns the next item. Returns false once the end marker is read
+func readSnapshotItem(reader *bufio.Reader) (snapshotItem, bool, error) {
+	marker, err := reader.ReadByte()
+
+	if err != nil {
+		return snapshotItem{}, false, snapshotReadError(err)
+	}
+
+	switch marker {
+	case snapshotEndMarker:
+		return snapshotItem{}, false, nil
+	case snapshotItemMarker:
+	default:
+		return snapshotItem{}, false, &InvalidSnapshotError{Reason: fmt.Sprintf("unexpected marker 0x%02x", marker)}
+	}
+
+	key, data, err := readItem(reader)
+
+	if err != nil {
+		return snapshotItem{}, false, snapshotReadError(err)
+	}
+
+	return snapshotItem{key: key, data: data}, true, nil
+}
+
+// readItem reads an item written by writeItem
+func readItem(reader io.Reader) (string, Data, error) {
+	key, err := readKey(reader)
+
+	if err != nil {
+		return "", Data{}, err
+	}
+
+	var flags uint16
+	var expiresAt int64
+	var valueLength uint32
+
+	for _, field := range []any{&flags, &expiresAt, &valueLength} {
+		if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+			return "", Data{}, err
+		}
+	}
+
+	if valueLength > utils.MaxItemSize {
+		return "", Data{}, &invalidLengthError{field: "value", length: valueLength, max: utils.MaxItemSize}
+	}
+
+	value := make([]byte, valueLength)
+
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return "", Data{}, err
+	}
+
+	return key, Data{
+		Value:     value,
+		Flags:     flags,
+		ByteCount: len(value),
+		ExpiresAt: time.UnixMilli(expiresAt),
+	}, nil
+}
+
+func readKey(reader io.Reader) (string, error) {
+	var keyLength uint32
+
+	if err := binary.Read(reader, binary.BigEndian, &keyLength); err != nil {
+		return "", err
+	}
+
+	if keyLength > utils.MaxKeyLength {
+		return "", &invalidLengthError{field: "key", length: keyLength, max: utils.MaxKeyLength}
+	}
+
+	key := make([]byte, keyLength)
+
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+// snapshotReadError reports a snapshot that ends before the end marker or has invalid lengths as invalid
+func snapshotReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &InvalidSnapshotError{Reason: "unexpected end of snapshot"}
+	}
+
+	lengthError := &invalidLengthError{}
+	if errors.As(err, &lengthError) {
+		return &InvalidSnapshotError{Reason: lengthError.Error()}
+	}
+
+	return fmt.Errorf("error reading snapshot: %v", err)
+}
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+	expiresAt := timeSource.Now().Add(time.Minute)
+
+	cache.Set("key1", Data{Value: []byte("hello"), ByteCount: 5, Flags: 12, ExpiresAt: expiresAt})
+	cache.Set("key2", Data{Value: []byte("world"), ByteCount: 5, ExpiresAt: time.UnixMilli(0)})
+	cache.Set("key3", Data{Value: []byte{}, ByteCount: 0})
+
+	var snapshot bytes.Buffer
+	written, err := cache.WriteSnapshot(&snapshot)
+
+	if err != nil || written != 3 {
+		t.Fatalf("Unexpected snapshot result: %d, %v\n", written, err)
+	}
+
+	restored := New(-1, WithTimeSource(timeSource))
+	loaded, err := restored.ReadSnapshot(&snapshot)
+
+	if err != nil || loaded != 3 {
+		t.Fatalf("Unexpected load result: %d, %v\n", loaded, err)
+	}
+
+	data, err := restored.Get("key1")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if !reflect.DeepEqual(data.Value, []byte("hello")) || data.Flags != 12 || data.ByteCount != 5 {
+		t.Fatalf("Unexpected data: %+v\n", data)
+	}
+
+	if !data.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected expiration time. Expected %v, got %v\n", expiresAt, data.ExpiresAt)
+	}
+
+	data, err = restored.Get("key2")
+
+	if err != nil || data.ExpiresAt.UnixMilli() != 0 {
+		t.Fatalf("Unexpected data: %+v, %v\n", data, err)
+	}
+
+	data, err = restored.Get("key3")
+
+	if err != nil || len(data.Value) != 0 {
+		t.Fatalf("Unexpected data: %+v, %v\n", data, err)
+	}
+}
+
+func TestSnapshotRestoresRecency(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("1")})
+	cache.Set("key2", Data{Value: []byte("2")})
+	cache.Set("key3", Data{Value: []byte("3")})
+	cache.Get("key1")
+
+	var snapshot bytes.Buffer
+	cache.WriteSnapshot(&snapshot)
+
+	restored := New(3)
+	restored.ReadSnapshot(&snapshot)
+	restored.Set("key4", Data{Value: []byte("4")})
+
+	// key2 is the least recently used key once key1 was read
+	if _, err := restored.Peek("key2"); err == nil {
+		t.Fatalf("Unexpected key2 not evicted\n")
+	}
+
+	for _, key := range []string{"key1", "key3", "key4"} {
+		if _, err := restored.Peek(key); err != nil {
+			t.Fatalf("Unexpected error for %s: %v\n", key, err)
+		}
+	}
+}
+
+func TestSnapshotSkipsExpiredItems(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.Set("expired", Data{Value: []byte("1"), ExpiresAt: timeSource.Now()})
+	cache.Set("expires_later", Data{Value: []byte("2"), ExpiresAt: timeSource.Now().Add(time.Minute)})
+	cache.Set("never_expires", Data{Value: []byte("3"), ExpiresAt: time.UnixMilli(0)})
+	timeSource.Advance(time.Second)
+
+	var snapshot bytes.Buffer
+	written, _ := cache.WriteSnapshot(&snapshot)
+
+	if written != 2 {
+		t.Fatalf("Unexpected number of items written. Expected 2, got %d\n", written)
+	}
+
+	// Expires while the server is down
+	timeSource.Advance(time.Minute)
+
+	restored := New(-1, WithTimeSource(timeSource))
+	loaded, err := restored.ReadSnapshot(&snapshot)
+
+	if err != nil || loaded != 1 {
+		t.Fatalf("Unexpected load result: %d, %v\n", loaded, err)
+	}
+
+	if _, err := restored.Get("never_expires"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestSnapshotSkipsFlushedItems(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	cache := New(-1, WithTimeSource(timeSource))
+
+	cache.Set("key1", Data{Value: []byte("1")})
+	cache.Flush(0)
+	timeSource.Advance(time.Nanosecond)
+	cache.Set("key2", Data{Value: []byte("2")})
+
+	var snapshot bytes.Buffer
+	written, _ := cache.WriteSnapshot(&snapshot)
+
+	if written != 1 {
+		t.Fatalf("Unexpected number of items written. Expected 1, got %d\n", written)
+	}
+}
+
+func TestSnapshotSkipsItemsTooLarge(t *testing.T) {
+	cache := New(-1)
+
+	cache.Set("small", Data{Value: []byte("1")})
+	cache.Set("large", Data{Value: make([]byte, 1024)})
+
+	var snapshot bytes.Buffer
+	cache.WriteSnapshot(&snapshot)
+
+	restored := New(-1, WithMemoryLimit(512))
+	loaded, err := restored.ReadSnapshot(&snapshot)
+
+	if err != nil || loaded != 1 {
+		t.Fatalf("Unexpected load result: %d, %v\n", loaded, err)
+	}
+}
+
+func TestReadSnapshotInvalid(t *testing.T) {
+	var snapshot bytes.Buffer
+	New(-1).WriteSnapshot(&snapshot)
+	valid := snapshot.Bytes()
+
+	testCases := map[string][]byte{
+		"empty":       {},
+		"wrong magic": append([]byte("NOTSNAP"), valid[len(snapshotMagic):]...),
+		"truncated":   valid[:len(valid)-1],
+		"bad marker":  append(bytes.Clone(valid[:len(valid)-1]), 7),
+		// Lengths that would otherwise make the reader allocate 4GB
+		"key too long": append(bytes.Clone(valid[:len(valid)-1]), snapshotItemMarker, 0xff, 0xff, 0xff, 0xff),
+		"value too long": append(
+			bytes.Clone(valid[:len(valid)-1]),
+			snapshotItemMarker, 0, 0, 0, 1, 'k', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff,
+		),
+	}
+
+	for name, input := range testCases {
+		_, err := New(-1).ReadSnapshot(bytes.NewReader(input))
+		invalidSnapshotError := &InvalidSnapshotError{}
+
+		if !errors.As(err, &invalidSnapshotError) {
+			t.Errorf("Unexpected error for %s. Expected InvalidSnapshotError, got %v\n", name, err)
+		}
+	}
+
+	unsupportedVersion := bytes.Clone(valid)
+	unsupportedVersion[len(snapshotMagic)+1] = 2
+	_, err := New(-1).ReadSnapshot(bytes.NewReader(unsupportedVersion))
+	unsupportedSnapshotVersionError := &UnsupportedSnapshotVersionError{}
+
+	if !errors.As(err, &unsupportedSnapshotVersionError) || unsupportedSnapshotVersionError.Version != 2 {
+		t.Fatalf("Unexpected error. Expected UnsupportedSnapshotVersionError, got %v\n", err)
+	}
+}
+
+func TestSaveAndLoadSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	cache := New(-1)
+
+	cache.Set("key1", Data{Value: []byte("hello")})
+
+	if _, err := cache.SaveSnapshot(path); err != nil {
+		t.Fatalf("Unexpected error saving snapshot: %v\n", err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected temporary file left behind: %v\n", err)
+	}
+
+	restored := New(-1)
+	loaded, err := restored.LoadSnapshot(path)
+
+	if err != nil || loaded != 1 {
+		t.Fatalf("Unexpected load result: %d, %v\n", loaded, err)
+	}
+
+	if _, err := New(-1).LoadSnapshot(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected error. Expected file not to exist, got %v\n", err)
+	}
+}
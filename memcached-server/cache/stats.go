@@ -0,0 +1,34 @@
+package cache
+
+// Stats counters describing the items in the cache. Names follow the equivalent memcached stats
+type Stats struct {
+	// Number of items currently in the cache
+	CurrItems int
+	// Number of items stored since the cache was created, including updates of existing keys
+	TotalItems uint64
+	// Number of bytes used by the items currently in the cache. See itemSize
+	Bytes int64
+	// Number of items removed to free space for new items
+	Evictions uint64
+	// Number of evicted items that were never fetched
+	EvictedUnfetched uint64
+	// Number of expired items that were never fetched
+	ExpiredUnfetched uint64
+	// Number of expired items deleted by the cleanup task (see Cache.RunExpireDataCleanupBackgroundTask)
+	ExpiredProactively uint64
+	// Number of expired items deleted when they were accessed
+	ExpiredLazily uint64
+}
+
+func (receiver Stats) add(other Stats) Stats {
+	return Stats{
+		CurrItems:          receiver.CurrItems + other.CurrItems,
+		TotalItems:         receiver.TotalItems + other.TotalItems,
+		Bytes:              receiver.Bytes + other.Bytes,
+		Evictions:          receiver.Evictions + other.Evictions,
+		EvictedUnfetched:   receiver.EvictedUnfetched + other.EvictedUnfetched,
+		ExpiredUnfetched:   receiver.ExpiredUnfetched + other.ExpiredUnfetched,
+		ExpiredProactively: receiver.ExpiredProactively + other.ExpiredProactively,
+		ExpiredLazily:      receiver.ExpiredLazily + other.ExpiredLazily,
+	}
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"container/list"
+	"math"
+)
+
+// Share of the keys kept in the admission window
+const tinyLFUWindowRatio = 0.01
+
+// Share of the keys in the main space kept in the protected segment
+const tinyLFUProtectedRatio = 0.8
+
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+// tinyLFUEvictionPolicy Window TinyLFU (Einziger, Friedman & Manes). New keys go into a small LRU admission window.
+// The main space is a segmented LRU, where keys accessed while on probation are promoted to the protected segment.
+// When a key needs to be evicted, the oldest key in the window competes against the oldest key in the main space, and
+// the one with the lower estimated access frequency is evicted. Frequencies are estimated with a count-min sketch that
+// also remembers keys that are no longer in the cache
+type tinyLFUEvictionPolicy struct {
+	// Least recently used keys are in the front of every segment
+	window, probation, protected *list.List
+	lookupTable                  map[string]*list.Element
+	sketch                       *countMinSketch
+}
+
+type tinyLFUEntry struct {
+	key     string
+	segment int
+}
+
+func newTinyLFUEvictionPolicy(capacity int) *tinyLFUEvictionPolicy {
+	return &tinyLFUEvictionPolicy{
+		window:      list.New(),
+		probation:   list.New(),
+		protected:   list.New(),
+		lookupTable: make(map[string]*list.Element),
+		sketch:      newCountMinSketch(capacity),
+	}
+}
+
+func (receiver *tinyLFUEvictionPolicy) Insert(key string) {
+	receiver.sketch.Increment(key)
+	receiver.pushBack(segmentWindow, key)
+
+	// Keys that overflow the window move to the main space. They only compete for admission once space is needed
+	for receiver.window.Len() > receiver.windowTarget() {
+		receiver.move(receiver.window.Front(), segmentProbation)
+	}
+}
+
+func (receiver *tinyLFUEvictionPolicy) Access(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok {
+		return
+	}
+
+	receiver.sketch.Increment(key)
+
+	switch element.Value.(*tinyLFUEntry).segment {
+	case segmentWindow:
+		receiver.window.MoveToBack(element)
+	case segmentProtected:
+		receiver.protected.MoveToBack(element)
+	case segmentProbation:
+		receiver.move(element, segmentProtected)
+
+		for receiver.protected.Len() > receiver.protectedTarget() {
+			receiver.move(receiver.protected.Front(), segmentProbation)
+		}
+	}
+}
+
+func (receiver *tinyLFUEvictionPolicy) Remove(key string) {
+	element, ok := receiver.lookupTable[key]
+
+	if !ok {
+		return
+	}
+
+	receiver.segmentList(element.Value.(*tinyLFUEntry).segment).Remove(element)
+	delete(receiver.lookupTable, key)
+}
+
+func (receiver *tinyLFUEvictionPolicy) Victim() (string, bool) {
+	mainVictim := receiver.probation.Front()
+
+	if mainVictim == nil {
+		mainVictim = receiver.protected.Front()
+	}
+
+	candidate := receiver.window.Front()
+
+	if candidate == nil && mainVictim == nil {
+		return "", false
+	}
+
+	if candidate == nil {
+		return mainVictim.Value.(*tinyLFUEntry).key, true
+	}
+
+	if mainVictim == nil {
+		return candidate.Value.(*tinyLFUEntry).key, true
+	}
+
+	candidateKey := candidate.Value.(*tinyLFUEntry).key
+	mainVictimKey := mainVictim.Value.(*tinyLFUEntry).key
+
+	// The candidate is only admitted to the main space if it's more popular than the key it would replace
+	if receiver.sketch.Estimate(candidateKey) > receiver.sketch.Estimate(mainVictimKey) {
+		receiver.move(candidate, segmentProbation)
+		return mainVictimKey, true
+	}
+
+	return candidateKey, true
+}
+
+func (receiver *tinyLFUEvictionPolicy) Len() int {
+	return len(receiver.lookupTable)
+}
+
+func (receiver *tinyLFUEvictionPolicy) windowTarget() int {
+	return max(1, int(float64(receiver.Len())*tinyLFUWindowRatio))
+}
+
+func (receiver *tinyLFUEvictionPolicy) protectedTarget() int {
+	mainSize := receiver.probation.Len() + receiver.protected.Len()
+
+	return int(float64(mainSize) * tinyLFUProtectedRatio)
+}
+
+func (receiver *tinyLFUEvictionPolicy) segmentList(segment int) *list.List {
+	switch segment {
+	case segmentProbation:
+		return receiver.probation
+	case segmentProtected:
+		return receiver.protected
+	default:
+		return receiver.window
+	}
+}
+
+func (receiver *tinyLFUEvictionPolicy) pushBack(segment int, key string) {
+	receiver.lookupTable[key] = receiver.segmentList(segment).PushBack(&tinyLFUEntry{key: key, segment: segment})
+}
+
+// move moves the element to the most recently used end of the segment
+func (receiver *tinyLFUEvictionPolicy) move(element *list.Element, segment int) {
+	entry := element.Value.(*tinyLFUEntry)
+
+	receiver.segmentList(entry.segment).Remove(element)
+	receiver.pushBack(segment, entry.key)
+}
+
+// Number of rows of the sketch. Each row uses a different hash function
+const sketchDepth = 4
+
+// Counters are capped at this value, same as the 4-bit counters in the TinyLFU paper
+const sketchMaxCount = 15
+
+// countMinSketch approximates how many times each key was seen using a fixed amount of memory. Counters are halved
+// periodically so old accesses weigh less than recent ones
+type countMinSketch struct {
+	counters [sketchDepth][]uint8
+	// Width of each row minus 1. The width is a power of 2 so indexes can be computed with a mask
+	mask uint64
+	// Number of increments since the counters were last halved, and how many trigger halving them
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	// Unbounded shards use a fixed size, since the number of keys isn't known in advance
+	if capacity == math.MaxInt {
+		capacity = 1 << 16
+	}
+
+	width := 64
+	for width < capacity && width < 1<<20 {
+		width *= 2
+	}
+
+	sketch := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * width,
+	}
+
+	for i := range sketch.counters {
+		sketch.counters[i] = make([]uint8, width)
+	}
+
+	return sketch
+}
+
+func (receiver *countMinSketch) Increment(key string) {
+	hash := hashKey64(key)
+
+	for i := range receiver.counters {
+		index := receiver.index(hash, i)
+
+		if receiver.counters[i][index] < sketchMaxCount {
+			receiver.counters[i][index]++
+		}
+	}
+
+	receiver.additions++
+
+	if receiver.additions >= receiver.sampleSize {
+		receiver.reset()
+	}
+}
+
+func (receiver *countMinSketch) Estimate(key string) uint8 {
+	hash := hashKey64(key)
+	estimate := uint8(sketchMaxCount)
+
+	for i := range receiver.counters {
+		estimate = min(estimate, receiver.counters[i][receiver.index(hash, i)])
+	}
+
+	return estimate
+}
+
+// index derives the index for each row from a single hash (Kirsch-Mitzenmacher)
+func (receiver *countMinSketch) index(hash uint64, row int) uint64 {
+	h1 := hash & math.MaxUint32
+	h2 := hash >> 32
+
+	return (h1 + uint64(row)*h2) & receiver.mask
+}
+
+func (receiver *countMinSketch) reset() {
+	for i := range receiver.counters {
+		for j := range receiver.counters[i] {
+			receiver.counters[i][j] /= 2
+		}
+	}
+
+	receiver.additions /= 2
+}
+
+// hashKey64 64-bit FNV-1a hash of the key
+func hashKey64(key string) uint64 {
+	const offsetBasis = 14695981039346656037
+	const prime = 1099511628211
+
+	hash := uint64(offsetBasis)
+
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime
+	}
+
+	return hash
+}
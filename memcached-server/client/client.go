@@ -0,0 +1,589 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"memcached-server/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest key accepted by memcached
+const maxKeyLength = 250
+
+const (
+	DefaultTimeout            = 500 * time.Millisecond
+	DefaultMaxIdleConnections = 2
+	DefaultFailureThreshold   = 2
+	DefaultRetryInterval      = 10 * time.Second
+)
+
+type Item struct {
+	Key   string
+	Value []byte
+	Flags uint16
+	// Expiration time following the memcached rules: `0` never expires, values up to 30 days are seconds from now, and
+	// larger values are Unix timestamps
+	Expiration int32
+	// Unique value returned by Get and GetMulti. Used by CompareAndSwap
+	CasUnique uint64
+}
+
+// Client memcached client for a cluster of servers. It speaks the text protocol and distributes keys across the
+// servers with ketama consistent hashing, so adding or removing a server only moves the keys of that server. It's safe
+// for concurrent use.
+//
+// Servers that fail repeatedly because of connection errors are ejected from the ring (see WithFailureThreshold), so
+// their keys are moved to the remaining servers until they're retried (see WithRetryInterval)
+type Client struct {
+	nodes []*node
+	// Ring of the servers that aren't ejected
+	ring *ketamaRing
+	// Guards ring and the failure counters of the nodes
+	mutex            *sync.Mutex
+	timeout          time.Duration
+	failureThreshold int
+	retryInterval    time.Duration
+	timeSource       utils.TimeSource
+}
+
+type config struct {
+	timeout            time.Duration
+	maxIdleConnections int
+	failureThreshold   int
+	retryInterval      time.Duration
+	timeSource         utils.TimeSource
+}
+
+// Option configures optional settings of a Client
+type Option func(*config)
+
+// WithTimeout sets the time allowed to connect to a server and to complete each request. Defaults to DefaultTimeout.
+// Values less than 1 are ignored
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithMaxIdleConnections sets the number of connections kept open to each server for reuse. Defaults to
+// DefaultMaxIdleConnections. Negative values are ignored
+func WithMaxIdleConnections(maxIdleConnections int) Option {
+	return func(c *config) {
+		if maxIdleConnections >= 0 {
+			c.maxIdleConnections = maxIdleConnections
+		}
+	}
+}
+
+// WithFailureThreshold sets the number of consecutive connection errors after which a server is ejected. Defaults to
+// DefaultFailureThreshold. Values less than 1 disable ejection
+func WithFailureThreshold(failureThreshold int) Option {
+	return func(c *config) {
+		c.failureThreshold = failureThreshold
+	}
+}
+
+// WithRetryInterval sets how long an ejected server stays out of the ring before it's retried. A single connection
+// error ejects it again. Defaults to DefaultRetryInterval
+func WithRetryInterval(retryInterval time.Duration) Option {
+	return func(c *config) {
+		c.retryInterval = retryInterval
+	}
+}
+
+// WithTimeSource sets the clock used to decide when ejected servers are retried. Defaults to the system clock
+func WithTimeSource(timeSource utils.TimeSource) Option {
+	return func(c *config) {
+		c.timeSource = timeSource
+	}
+}
+
+// New creates a client for the servers at addresses (`host:port`). No connections are opened until they're needed
+func New(addresses []string, options ...Option) *Client {
+	cfg := config{
+		timeout:            DefaultTimeout,
+		maxIdleConnections: DefaultMaxIdleConnections,
+		failureThreshold:   DefaultFailureThreshold,
+		retryInterval:      DefaultRetryInterval,
+		timeSource:         &utils.RealTimeSource{},
+	}
+
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	nodes := make([]*node, len(addresses))
+
+	for i, address := range addresses {
+		nodes[i] = newNode(address, cfg.maxIdleConnections)
+	}
+
+	return &Client{
+		nodes:            nodes,
+		ring:             newKetamaRing(nodes),
+		mutex:            &sync.Mutex{},
+		timeout:          cfg.timeout,
+		failureThreshold: cfg.failureThreshold,
+		retryInterval:    cfg.retryInterval,
+		timeSource:       cfg.timeSource,
+	}
+}
+
+// Get returns the item stored for the key, or CacheMissError if there's none
+func (receiver *Client) Get(key string) (*Item, error) {
+	items, err := receiver.GetMulti([]string{key})
+
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := items[key]
+
+	if !ok {
+		return nil, &CacheMissError{Key: key}
+	}
+
+	return item, nil
+}
+
+// GetMulti returns the items stored for the keys. Keys that aren't found are omitted. The keys of each server are
+// fetched with a single request, and the servers are queried concurrently. If some of the requests fail, the items
+// returned by the rest are returned along with the errors
+func (receiver *Client) GetMulti(keys []string) (map[string]*Item, error) {
+	keysByNode, err := receiver.pickNodes(keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*Item, len(keys))
+	var errs []error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for n, nodeKeys := range keysByNode {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			nodeItems, err := receiver.getFromNode(n, nodeKeys)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			for key, item := range nodeItems {
+				items[key] = item
+			}
+
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return items, errors.Join(errs...)
+}
+
+// Set stores the item
+func (receiver *Client) Set(item *Item) error {
+	return receiver.store("set", item)
+}
+
+// Add stores the item only if the key doesn't exist. Returns NotStoredError otherwise
+func (receiver *Client) Add(item *Item) error {
+	return receiver.store("add", item)
+}
+
+// Replace stores the item only if the key exists. Returns NotStoredError otherwise
+func (receiver *Client) Replace(item *Item) error {
+	return receiver.store("replace", item)
+}
+
+// Append adds the value of the item to the end of the existing value. The flags and expiration of the item are
+// ignored. Returns NotStoredError if the key doesn't exist
+func (receiver *Client) Append(item *Item) error {
+	return receiver.store("append", item)
+}
+
+// Prepend adds the value of the item to the beginning of the existing value. The flags and expiration of the item are
+// ignored. Returns NotStoredError if the key doesn't exist
+func (receiver *Client) Prepend(item *Item) error {
+	return receiver.store("prepend", item)
+}
+
+// CompareAndSwap stores the item only if it wasn't modified since it was fetched, i.e., if its CasUnique matches the
+// one stored. Returns CasMismatchError if it was modified, and CacheMissError if the key doesn't exist
+func (receiver *Client) CompareAndSwap(item *Item) error {
+	return receiver.store("cas", item)
+}
+
+// Delete removes the key. Returns CacheMissError if it doesn't exist
+func (receiver *Client) Delete(key string) error {
+	return receiver.simpleCommand(key, fmt.Sprintf("delete %s\r\n", key), "DELETED")
+}
+
+// Touch updates the expiration time of the key without fetching it. Returns CacheMissError if it doesn't exist
+func (receiver *Client) Touch(key string, expiration int32) error {
+	return receiver.simpleCommand(key, fmt.Sprintf("touch %s %d\r\n", key, expiration), "TOUCHED")
+}
+
+// Increment adds delta to the numeric value of the key and returns the new value. The value wraps around on overflow.
+// Returns CacheMissError if the key doesn't exist, and ClientError if the value isn't numeric
+func (receiver *Client) Increment(key string, delta uint64) (uint64, error) {
+	return receiver.incrDecr("incr", key, delta)
+}
+
+// Decrement subtracts delta from the numeric value of the key and returns the new value. The value doesn't go below
+// 0. Returns CacheMissError if the key doesn't exist, and ClientError if the value isn't numeric
+func (receiver *Client) Decrement(key string, delta uint64) (uint64, error) {
+	return receiver.incrDecr("decr", key, delta)
+}
+
+// Close closes the idle connections. The client can still be used afterwards, in which case new connections are opened
+func (receiver *Client) Close() {
+	for _, n := range receiver.nodes {
+		n.closeIdleConns()
+	}
+}
+
+func (receiver *Client) getFromNode(n *node, keys []string) (map[string]*Item, error) {
+	items := make(map[string]*Item, len(keys))
+
+	err := receiver.do(n, func(c *conn) error {
+		c.writer.WriteString("gets " + strings.Join(keys, " ") + "\r\n")
+
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+
+		for {
+			line, err := c.readLine()
+
+			if err != nil {
+				return err
+			}
+
+			if line == "END" {
+				return nil
+			}
+
+			item, err := readValue(c, line)
+
+			if err != nil {
+				return err
+			}
+
+			items[item.Key] = item
+		}
+	})
+
+	return items, err
+}
+
+// readValue reads the data block of a `VALUE <key> <flags> <bytes> <cas unique>` line
+func readValue(c *conn, line string) (*Item, error) {
+	fields := strings.Fields(line)
+
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		return nil, unexpectedReply(line)
+	}
+
+	flags, flagsErr := strconv.ParseUint(fields[2], 10, 16)
+	byteCount, byteCountErr := strconv.Atoi(fields[3])
+	casUnique, casUniqueErr := strconv.ParseUint(fields[4], 10, 64)
+
+	if flagsErr != nil || byteCountErr != nil || casUniqueErr != nil || byteCount < 0 {
+		return nil, unexpectedReply(line)
+	}
+
+	value := make([]byte, byteCount+2)
+
+	if _, err := io.ReadFull(c.reader, value); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(value, []byte("\r\n")) {
+		return nil, &UnexpectedReplyError{Reply: line}
+	}
+
+	return &Item{Key: fields[1], Value: value[:byteCount], Flags: uint16(flags), CasUnique: casUnique}, nil
+}
+
+func (receiver *Client) store(command string, item *Item) error {
+	n, err := receiver.pickNode(item.Key)
+
+	if err != nil {
+		return err
+	}
+
+	return receiver.do(n, func(c *conn) error {
+		fmt.Fprintf(c.writer, "%s %s %d %d %d", command, item.Key, item.Flags, item.Expiration, len(item.Value))
+
+		if command == "cas" {
+			fmt.Fprintf(c.writer, " %d", item.CasUnique)
+		}
+
+		c.writer.WriteString("\r\n")
+		c.writer.Write(item.Value)
+		c.writer.WriteString("\r\n")
+
+		reply, err := c.roundTrip()
+
+		if err != nil {
+			return err
+		}
+
+		switch reply {
+		case "STORED":
+			return nil
+		case "NOT_STORED":
+			return &NotStoredError{Key: item.Key}
+		case "EXISTS":
+			return &CasMismatchError{Key: item.Key}
+		case "NOT_FOUND":
+			return &CacheMissError{Key: item.Key}
+		}
+
+		return unexpectedReply(reply)
+	})
+}
+
+// simpleCommand sends a command whose only successful reply is success. `NOT_FOUND` is returned as CacheMissError
+func (receiver *Client) simpleCommand(key string, command string, success string) error {
+	n, err := receiver.pickNode(key)
+
+	if err != nil {
+		return err
+	}
+
+	return receiver.do(n, func(c *conn) error {
+		c.writer.WriteString(command)
+
+		reply, err := c.roundTrip()
+
+		if err != nil {
+			return err
+		}
+
+		switch reply {
+		case success:
+			return nil
+		case "NOT_FOUND":
+			return &CacheMissError{Key: key}
+		}
+
+		return unexpectedReply(reply)
+	})
+}
+
+func (receiver *Client) incrDecr(command string, key string, delta uint64) (uint64, error) {
+	n, err := receiver.pickNode(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+
+	err = receiver.do(n, func(c *conn) error {
+		fmt.Fprintf(c.writer, "%s %s %d\r\n", command, key, delta)
+
+		reply, err := c.roundTrip()
+
+		if err != nil {
+			return err
+		}
+
+		if reply == "NOT_FOUND" {
+			return &CacheMissError{Key: key}
+		}
+
+		value, err = strconv.ParseUint(reply, 10, 64)
+
+		if err != nil {
+			return unexpectedReply(reply)
+		}
+
+		return nil
+	})
+
+	return value, err
+}
+
+// do runs request with a connection to the server. Errors other than replies of the server (see isReplyError) close
+// the connection and count towards ejecting the server
+func (receiver *Client) do(n *node, request func(c *conn) error) error {
+	c, err := n.getConn(receiver.timeout)
+
+	if err != nil {
+		receiver.recordFailure(n)
+		return fmt.Errorf("error connecting to %s: %w", n.address, err)
+	}
+
+	c.SetDeadline(time.Now().Add(receiver.timeout))
+
+	err = request(c)
+
+	if err != nil && !isReplyError(err) {
+		c.Close()
+		receiver.recordFailure(n)
+		return fmt.Errorf("error sending request to %s: %w", n.address, err)
+	}
+
+	n.putConn(c)
+	receiver.recordSuccess(n)
+
+	return err
+}
+
+// pickNode returns the server the key belongs to
+func (receiver *Client) pickNode(key string) (*node, error) {
+	if !isValidKey(key) {
+		return nil, &MalformedKeyError{Key: key}
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.retryEjectedNodes()
+
+	n := receiver.ring.get(key)
+
+	if n == nil {
+		return nil, &NoServersError{}
+	}
+
+	return n, nil
+}
+
+// pickNodes groups the keys by the server they belong to
+func (receiver *Client) pickNodes(keys []string) (map[*node][]string, error) {
+	for _, key := range keys {
+		if !isValidKey(key) {
+			return nil, &MalformedKeyError{Key: key}
+		}
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.retryEjectedNodes()
+
+	keysByNode := make(map[*node][]string)
+
+	for _, key := range keys {
+		n := receiver.ring.get(key)
+
+		if n == nil {
+			return nil, &NoServersError{}
+		}
+
+		keysByNode[n] = append(keysByNode[n], key)
+	}
+
+	return keysByNode, nil
+}
+
+// retryEjectedNodes adds the ejected servers whose retry interval elapsed back to the ring. Assumes the caller holds
+// the mutex
+func (receiver *Client) retryEjectedNodes() {
+	now := receiver.timeSource.Now()
+	retried := false
+
+	for _, n := range receiver.nodes {
+		if !n.ejectedUntil.IsZero() && !now.Before(n.ejectedUntil) {
+			n.ejectedUntil = time.Time{}
+			// A single failure ejects the server again if it's still down
+			n.failures = receiver.failureThreshold - 1
+			retried = true
+		}
+	}
+
+	if retried {
+		receiver.rebuildRing()
+	}
+}
+
+func (receiver *Client) recordFailure(n *node) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	n.failures++
+
+	if receiver.failureThreshold < 1 || n.failures < receiver.failureThreshold || !n.ejectedUntil.IsZero() {
+		return
+	}
+
+	n.ejectedUntil = receiver.timeSource.Now().Add(receiver.retryInterval)
+	n.closeIdleConns()
+	receiver.rebuildRing()
+}
+
+func (receiver *Client) recordSuccess(n *node) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	n.failures = 0
+}
+
+// rebuildRing places the servers that aren't ejected on the ring. Assumes the caller holds the mutex
+func (receiver *Client) rebuildRing() {
+	var alive []*node
+
+	for _, n := range receiver.nodes {
+		if n.ejectedUntil.IsZero() {
+			alive = append(alive, n)
+		}
+	}
+
+	receiver.ring = newKetamaRing(alive)
+}
+
+// isReplyError returns whether the error is a reply of the server, in which case the connection can still be used
+func isReplyError(err error) bool {
+	switch err.(type) {
+	case *CacheMissError, *NotStoredError, *CasMismatchError, *ServerError, *ClientError:
+		return true
+	}
+
+	return false
+}
+
+// unexpectedReply returns ServerError or ClientError for error replies, and UnexpectedReplyError for anything else
+func unexpectedReply(reply string) error {
+	switch {
+	case reply == "ERROR":
+		return &ClientError{Message: "unknown command"}
+	case strings.HasPrefix(reply, "CLIENT_ERROR "):
+		return &ClientError{Message: strings.TrimPrefix(reply, "CLIENT_ERROR ")}
+	case strings.HasPrefix(reply, "SERVER_ERROR "):
+		return &ServerError{Message: strings.TrimPrefix(reply, "SERVER_ERROR ")}
+	}
+
+	return &UnexpectedReplyError{Reply: reply}
+}
+
+// isValidKey returns whether the key can be sent with the text protocol
+func isValidKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,444 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"memcached-server/cache"
+	"memcached-server/server"
+	"memcached-server/utils"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetAndGet(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	if err := client.Set(&Item{Key: "key1", Value: []byte("hello\r\nworld"), Flags: 5}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	item, err := client.Get("key1")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if item.Key != "key1" || string(item.Value) != "hello\r\nworld" || item.Flags != 5 || item.CasUnique == 0 {
+		t.Fatalf("Unexpected item: %+v\n", item)
+	}
+}
+
+func TestGet_Miss(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	_, err := client.Get("key1")
+
+	cacheMissError := &CacheMissError{}
+	if !errors.As(err, &cacheMissError) || cacheMissError.Key != "key1" {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	addresses := startTestServers(t, 3)
+	client := New(addresses)
+	expected := make(map[string]*Item)
+	var keys []string
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+
+		if err := client.Set(&Item{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	items, err := client.GetMulti(append(keys, "missing"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	for _, key := range keys {
+		item, err := client.Get(key)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+
+		expected[key] = item
+	}
+
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("Unexpected items: %v\n", items)
+	}
+}
+
+func TestKeysAreDistributedAcrossServers(t *testing.T) {
+	addresses := startTestServers(t, 3)
+	client := New(addresses)
+	ring := newKetamaRing(testNodes(addresses...))
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		client.Set(&Item{Key: key, Value: []byte("1")})
+
+		// Only the server picked by the ring has the key
+		for _, address := range addresses {
+			_, err := New([]string{address}).Get(key)
+
+			if (err == nil) != (address == ring.get(key).address) {
+				t.Fatalf("Unexpected location of '%s'. Found on %s: %v\n", key, address, err == nil)
+			}
+		}
+	}
+}
+
+func TestAddAndReplace(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	notStoredError := &NotStoredError{}
+	if err := client.Replace(&Item{Key: "key1", Value: []byte("1")}); !errors.As(err, &notStoredError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := client.Add(&Item{Key: "key1", Value: []byte("1")}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := client.Add(&Item{Key: "key1", Value: []byte("2")}); !errors.As(err, &notStoredError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := client.Replace(&Item{Key: "key1", Value: []byte("3")}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	assertValue(t, client, "key1", "3")
+}
+
+func TestAppendAndPrepend(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	notStoredError := &NotStoredError{}
+	if err := client.Append(&Item{Key: "key1", Value: []byte("!")}); !errors.As(err, &notStoredError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	client.Set(&Item{Key: "key1", Value: []byte("hello")})
+	client.Append(&Item{Key: "key1", Value: []byte("!")})
+	client.Prepend(&Item{Key: "key1", Value: []byte("> ")})
+
+	assertValue(t, client, "key1", "> hello!")
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	client.Set(&Item{Key: "key1", Value: []byte("1")})
+	item, _ := client.Get("key1")
+
+	item.Value = []byte("2")
+	if err := client.CompareAndSwap(item); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	// The item was modified since it was fetched
+	item.Value = []byte("3")
+	casMismatchError := &CasMismatchError{}
+	if err := client.CompareAndSwap(item); !errors.As(err, &casMismatchError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	assertValue(t, client, "key1", "2")
+
+	cacheMissError := &CacheMissError{}
+	if err := client.CompareAndSwap(&Item{Key: "key2", CasUnique: 1}); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	client.Set(&Item{Key: "key1", Value: []byte("1")})
+
+	if err := client.Delete("key1"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	cacheMissError := &CacheMissError{}
+	if err := client.Delete("key1"); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestIncrementAndDecrement(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	client.Set(&Item{Key: "counter", Value: []byte("10")})
+
+	if value, err := client.Increment("counter", 5); err != nil || value != 15 {
+		t.Fatalf("Unexpected result: %d, %v\n", value, err)
+	}
+
+	if value, err := client.Decrement("counter", 20); err != nil || value != 0 {
+		t.Fatalf("Unexpected result: %d, %v\n", value, err)
+	}
+
+	cacheMissError := &CacheMissError{}
+	if _, err := client.Increment("missing", 1); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	client.Set(&Item{Key: "key1", Value: []byte("hello")})
+
+	clientError := &ClientError{}
+	if _, err := client.Increment("key1", 1); !errors.As(err, &clientError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	// The connection is still usable after an error reply
+	assertValue(t, client, "counter", "0")
+}
+
+func TestTouch(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	client.Set(&Item{Key: "key1", Value: []byte("1")})
+
+	if err := client.Touch("key1", -1); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	cacheMissError := &CacheMissError{}
+	if _, err := client.Get("key1"); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := client.Touch("key1", 0); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestMalformedKey(t *testing.T) {
+	client := New(startTestServers(t, 1))
+
+	for _, key := range []string{"", "key 1", "key\r\n1", strings.Repeat("k", maxKeyLength+1)} {
+		malformedKeyError := &MalformedKeyError{}
+		if err := client.Set(&Item{Key: key}); !errors.As(err, &malformedKeyError) {
+			t.Fatalf("Unexpected error for %q: %v\n", key, err)
+		}
+
+		if _, err := client.GetMulti([]string{"key1", key}); !errors.As(err, &malformedKeyError) {
+			t.Fatalf("Unexpected error for %q: %v\n", key, err)
+		}
+	}
+}
+
+func TestServerError(t *testing.T) {
+	address := startTestServer(t, "127.0.0.1:0", cache.New(-1, cache.WithMemoryLimit(1024)))
+	client := New([]string{address})
+
+	serverError := &ServerError{}
+	if err := client.Set(&Item{Key: "key1", Value: make([]byte, 2048)}); !errors.As(err, &serverError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestConnectionsAreReused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	counter := &countingListener{Listener: listener, accepted: &atomic.Int32{}}
+	serve(t, counter, cache.New(-1))
+	client := New([]string{listener.Addr().String()}, WithMaxIdleConnections(1))
+
+	for i := 0; i < 10; i++ {
+		client.Set(&Item{Key: "key1", Value: []byte("1")})
+		client.Get("key1")
+	}
+
+	if accepted := counter.accepted.Load(); accepted != 1 {
+		t.Fatalf("Unexpected number of connections. Expected 1, got %d\n", accepted)
+	}
+
+	client.Close()
+	client.Get("key1")
+
+	if accepted := counter.accepted.Load(); accepted != 2 {
+		t.Fatalf("Unexpected number of connections. Expected 2, got %d\n", accepted)
+	}
+}
+
+func TestDeadServersAreEjected(t *testing.T) {
+	addresses := startTestServers(t, 2)
+	deadAddress := reserveAddress(t)
+	timeSource := &utils.FakeTimeSource{FixedTime: time.Now()}
+	client := New(
+		append(addresses, deadAddress),
+		WithFailureThreshold(2),
+		WithRetryInterval(time.Minute),
+		WithTimeSource(timeSource),
+	)
+	key := keyOnServer(t, client, deadAddress)
+
+	// Requests fail until the server is ejected
+	for i := 0; i < 2; i++ {
+		if err := client.Set(&Item{Key: key, Value: []byte("1")}); err == nil {
+			t.Fatalf("Expected error storing data on a dead server\n")
+		}
+	}
+
+	// Then its keys are moved to the other servers
+	if err := client.Set(&Item{Key: key, Value: []byte("1")}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	assertValue(t, client, key, "1")
+
+	// The server is retried once the retry interval elapses
+	startTestServer(t, deadAddress, cache.New(-1))
+	timeSource.Advance(time.Minute)
+
+	cacheMissError := &CacheMissError{}
+	if _, err := client.Get(key); !errors.As(err, &cacheMissError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestRetriedServerIsEjectedAfterOneFailure(t *testing.T) {
+	addresses := startTestServers(t, 1)
+	deadAddress := reserveAddress(t)
+	timeSource := &utils.FakeTimeSource{FixedTime: time.Now()}
+	client := New(
+		append(addresses, deadAddress),
+		WithFailureThreshold(3),
+		WithRetryInterval(time.Minute),
+		WithTimeSource(timeSource),
+	)
+	key := keyOnServer(t, client, deadAddress)
+
+	for i := 0; i < 3; i++ {
+		client.Delete(key)
+	}
+
+	timeSource.Advance(time.Minute)
+
+	if err := client.Set(&Item{Key: key, Value: []byte("1")}); err == nil {
+		t.Fatalf("Expected error storing data on a dead server\n")
+	}
+
+	if err := client.Set(&Item{Key: key, Value: []byte("1")}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestAllServersEjected(t *testing.T) {
+	client := New([]string{reserveAddress(t)}, WithFailureThreshold(1))
+
+	client.Get("key1")
+
+	noServersError := &NoServersError{}
+	if _, err := client.Get("key1"); !errors.As(err, &noServersError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func assertValue(t *testing.T, client *Client, key string, expected string) {
+	item, err := client.Get(key)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if string(item.Value) != expected {
+		t.Fatalf("Unexpected value. Expected '%s', got '%s'\n", expected, item.Value)
+	}
+}
+
+// keyOnServer returns a key that belongs to the server at address
+func keyOnServer(t *testing.T, client *Client, address string) string {
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		if n, _ := client.pickNode(key); n.address == address {
+			return key
+		}
+	}
+
+	t.Fatalf("No key found for %s\n", address)
+	return ""
+}
+
+// startTestServers starts numServers servers on loopback and returns their addresses
+func startTestServers(t *testing.T, numServers int) []string {
+	addresses := make([]string, numServers)
+
+	for i := range addresses {
+		addresses[i] = startTestServer(t, "127.0.0.1:0", cache.New(-1))
+	}
+
+	return addresses
+}
+
+func startTestServer(t *testing.T, address string, c *cache.Cache) string {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	serve(t, listener, c)
+
+	return listener.Addr().String()
+}
+
+func serve(t *testing.T, listener net.Listener, c *cache.Cache) {
+	s := server.New(c)
+	go s.Serve(listener)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		s.Shutdown(ctx)
+	})
+}
+
+// reserveAddress returns a loopback address nothing is listening on
+func reserveAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	listener.Close()
+
+	return listener.Addr().String()
+}
+
+type countingListener struct {
+	net.Listener
+	accepted *atomic.Int32
+}
+
+func (receiver *countingListener) Accept() (net.Conn, error) {
+	conn, err := receiver.Listener.Accept()
+
+	if err == nil {
+		receiver.accepted.Add(1)
+	}
+
+	return conn, err
+}
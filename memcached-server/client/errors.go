@@ -0,0 +1,75 @@
+package client
+
+import "fmt"
+
+type CacheMissError struct {
+	Key string
+}
+
+func (e *CacheMissError) Error() string {
+	return fmt.Sprintf("cache miss: %s", e.Key)
+}
+
+// NotStoredError returned when the condition of a storage command isn't met, e.g., adding a key that already exists or
+// replacing one that doesn't
+type NotStoredError struct {
+	Key string
+}
+
+func (e *NotStoredError) Error() string {
+	return fmt.Sprintf("item not stored: %s", e.Key)
+}
+
+type CasMismatchError struct {
+	Key string
+}
+
+func (e *CasMismatchError) Error() string {
+	return fmt.Sprintf("data has been modified since it was fetched: %s", e.Key)
+}
+
+// MalformedKeyError returned for keys the text protocol can't carry: empty, longer than 250 bytes, or containing
+// whitespace or control characters
+type MalformedKeyError struct {
+	Key string
+}
+
+func (e *MalformedKeyError) Error() string {
+	return fmt.Sprintf("malformed key: %q", e.Key)
+}
+
+// NoServersError returned when every server is ejected (see WithFailureThreshold)
+type NoServersError struct{}
+
+func (e *NoServersError) Error() string {
+	return "no servers available"
+}
+
+// ServerError `SERVER_ERROR` reply, e.g., because the item is too large for the cache or the server is a read-only
+// replica
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error: %s", e.Message)
+}
+
+// ClientError `CLIENT_ERROR` or `ERROR` reply, e.g., because the value isn't numeric for incr/decr
+type ClientError struct {
+	Message string
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("client error: %s", e.Message)
+}
+
+// UnexpectedReplyError returned when the server sends a reply the command doesn't expect. The connection is closed,
+// since it's no longer possible to tell where the next reply starts
+type UnexpectedReplyError struct {
+	Reply string
+}
+
+func (e *UnexpectedReplyError) Error() string {
+	return fmt.Sprintf("unexpected reply: %q", e.Reply)
+}
@@ -0,0 +1,25 @@
+package client
+
+import "testing"
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&CacheMissError{Key: "key1"}, "cache miss: key1"},
+		{&NotStoredError{Key: "key1"}, "item not stored: key1"},
+		{&CasMismatchError{Key: "key1"}, "data has been modified since it was fetched: key1"},
+		{&MalformedKeyError{Key: "key 1"}, "malformed key: \"key 1\""},
+		{&NoServersError{}, "no servers available"},
+		{&ServerError{Message: "out of memory"}, "server error: out of memory"},
+		{&ClientError{Message: "bad data chunk"}, "client error: bad data chunk"},
+		{&UnexpectedReplyError{Reply: "OK"}, "unexpected reply: \"OK\""},
+	}
+
+	for _, test := range tests {
+		if test.err.Error() != test.expected {
+			t.Errorf("Unexpected error message: '%s'\n", test.err.Error())
+		}
+	}
+}
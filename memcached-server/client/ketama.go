@@ -0,0 +1,71 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Number of points each server gets on the ring, same as libmemcached and other ketama implementations. Each MD5
+// digest yields 4 points
+const ketamaPointsPerServer = 160
+
+type ketamaPoint struct {
+	hash uint32
+	node *node
+}
+
+// ketamaRing consistent hashing ring compatible with ketama (libmemcached's MEMCACHED_BEHAVIOR_KETAMA with equal
+// weights). Each server is placed on the ring at the hashes of `<address>-<i>`, and a key belongs to the first server
+// found clockwise from its hash, so adding or removing a server only moves the keys of that server
+type ketamaRing struct {
+	// Sorted by hash
+	points []ketamaPoint
+}
+
+func newKetamaRing(nodes []*node) *ketamaRing {
+	points := make([]ketamaPoint, 0, len(nodes)*ketamaPointsPerServer)
+
+	for _, n := range nodes {
+		for i := 0; i < ketamaPointsPerServer/4; i++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", n.address, i)))
+
+			for j := 0; j < 4; j++ {
+				points = append(points, ketamaPoint{hash: binary.LittleEndian.Uint32(digest[j*4:]), node: n})
+			}
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	return &ketamaRing{points: points}
+}
+
+// get returns the server the key belongs to. Nil if the ring is empty
+func (receiver *ketamaRing) get(key string) *node {
+	if len(receiver.points) == 0 {
+		return nil
+	}
+
+	hash := ketamaHash(key)
+	i := sort.Search(len(receiver.points), func(i int) bool {
+		return receiver.points[i].hash >= hash
+	})
+
+	// Wraps around the ring
+	if i == len(receiver.points) {
+		i = 0
+	}
+
+	return receiver.points[i].node
+}
+
+// ketamaHash first 4 bytes of the MD5 digest of the key, little-endian
+func ketamaHash(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+
+	return binary.LittleEndian.Uint32(digest[:4])
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKetamaHash(t *testing.T) {
+	tests := map[string]uint32{
+		"foo":       3675831724,
+		"bar":       421377335,
+		"memcached": 1357326829,
+	}
+
+	for key, expected := range tests {
+		if hash := ketamaHash(key); hash != expected {
+			t.Fatalf("Unexpected hash for '%s'. Expected %d, got %d\n", key, expected, hash)
+		}
+	}
+}
+
+// Expected servers computed with a reference ketama implementation
+func TestKetamaRing(t *testing.T) {
+	ring := newKetamaRing(testNodes("127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213"))
+	tests := map[string]string{
+		"foo":       "127.0.0.1:11213",
+		"bar":       "127.0.0.1:11212",
+		"baz":       "127.0.0.1:11211",
+		"key1":      "127.0.0.1:11211",
+		"key2":      "127.0.0.1:11213",
+		"memcached": "127.0.0.1:11213",
+	}
+
+	if len(ring.points) != 3*ketamaPointsPerServer {
+		t.Fatalf("Unexpected number of points: %d\n", len(ring.points))
+	}
+
+	for key, expected := range tests {
+		if address := ring.get(key).address; address != expected {
+			t.Fatalf("Unexpected server for '%s'. Expected %s, got %s\n", key, expected, address)
+		}
+	}
+}
+
+func TestKetamaRing_Distribution(t *testing.T) {
+	nodes := testNodes("127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213", "127.0.0.1:11214")
+	ring := newKetamaRing(nodes)
+	counts := make(map[*node]int)
+	numKeys := 10000
+
+	for i := 0; i < numKeys; i++ {
+		counts[ring.get(fmt.Sprintf("key%d", i))]++
+	}
+
+	for _, n := range nodes {
+		// Each server should get roughly a quarter of the keys
+		if counts[n] < numKeys/8 || counts[n] > numKeys*3/8 {
+			t.Fatalf("Unbalanced ring. %s got %d of %d keys\n", n.address, counts[n], numKeys)
+		}
+	}
+}
+
+func TestKetamaRing_RemovingServerOnlyMovesItsKeys(t *testing.T) {
+	nodes := testNodes("127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213", "127.0.0.1:11214")
+	ring := newKetamaRing(nodes)
+	smallerRing := newKetamaRing(nodes[:3])
+
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before := ring.get(key)
+		after := smallerRing.get(key)
+
+		if before != nodes[3] && before != after {
+			t.Fatalf("Unexpected move of '%s' from %s to %s\n", key, before.address, after.address)
+		}
+	}
+}
+
+func TestKetamaRing_Empty(t *testing.T) {
+	if n := newKetamaRing(nil).get("key1"); n != nil {
+		t.Fatalf("Unexpected server: %s\n", n.address)
+	}
+}
+
+func testNodes(addresses ...string) []*node {
+	nodes := make([]*node, len(addresses))
+
+	for i, address := range addresses {
+		nodes[i] = newNode(address, DefaultMaxIdleConnections)
+	}
+
+	return nodes
+}
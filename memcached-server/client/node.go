@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+// node server of the cluster and its pool of idle connections
+type node struct {
+	address string
+	// Connections ready to be reused. Buffered to the maximum number of idle connections
+	idle chan *conn
+	// Number of consecutive requests that failed because of a connection error. Guarded by the mutex of the Client
+	failures int
+	// Time at which the server is added back to the ring after being ejected. Zero if the server isn't ejected. Guarded
+	// by the mutex of the Client
+	ejectedUntil time.Time
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newNode(address string, maxIdleConnections int) *node {
+	return &node{
+		address: address,
+		idle:    make(chan *conn, maxIdleConnections),
+	}
+}
+
+// getConn returns an idle connection, or opens a new one if there are none
+func (receiver *node) getConn(timeout time.Duration) (*conn, error) {
+	select {
+	case c := <-receiver.idle:
+		return c, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", receiver.address, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}, nil
+}
+
+// putConn returns the connection to the pool. It's closed if the pool is full
+func (receiver *node) putConn(c *conn) {
+	select {
+	case receiver.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (receiver *node) closeIdleConns() {
+	for {
+		select {
+		case c := <-receiver.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+// roundTrip sends the buffered command and returns the first line of the reply
+func (receiver *conn) roundTrip() (string, error) {
+	if err := receiver.writer.Flush(); err != nil {
+		return "", err
+	}
+
+	return receiver.readLine()
+}
+
+// readLine returns the next line of the reply without the trailing `\r\n`
+func (receiver *conn) readLine() (string, error) {
+	line, err := receiver.reader.ReadString('\n')
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
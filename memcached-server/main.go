@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/urfave/cli/v2"
 	"log"
 	"memcached-server/cache"
 	"memcached-server/server"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -17,11 +24,130 @@ func main() {
 				Value: 9999,
 				Usage: "Port number to Run the server",
 			},
+			&cli.IntFlag{
+				Name:  "m",
+				Value: 64,
+				Usage: "Maximum memory used by the cache in megabytes. Items are evicted when it's exceeded",
+			},
+			&cli.IntFlag{
+				Name:  "shards",
+				Value: 16,
+				Usage: "Number of independently locked cache shards. Higher values reduce lock contention between concurrent clients",
+			},
+			&cli.StringFlag{
+				Name:  "eviction-policy",
+				Value: string(cache.EvictionPolicyLRU),
+				Usage: "Algorithm used to pick which items are evicted when the cache is full. One of lru, lfu, arc, or tinylfu",
+			},
+			&cli.BoolFlag{
+				Name:  "slabs",
+				Usage: "Store keys and values in preallocated 1MB pages split into slab classes, which reduces the work of the garbage collector. -m bounds the pages; the heap copy of each key kept for lookups isn't counted",
+			},
+			&cli.Float64Flag{
+				Name:  "slab-growth-factor",
+				Value: 1.25,
+				Usage: "Ratio between the chunk sizes of consecutive slab classes. Only used with -slabs",
+			},
+			&cli.IntFlag{
+				Name:  "shutdown-timeout",
+				Value: 10,
+				Usage: "Seconds to wait for commands in progress to finish on SIGINT or SIGTERM before closing the connections",
+			},
+			&cli.StringFlag{
+				Name:  "snapshot-file",
+				Usage: "File the cache is loaded from at startup and saved to on shutdown and on SIGUSR1. Disabled if empty",
+			},
+			&cli.StringFlag{
+				Name:  "aof-file",
+				Usage: "Append-only log every change to the cache is written to and replayed from at startup. Disabled if empty",
+			},
+			&cli.StringFlag{
+				Name:  "aof-fsync",
+				Value: string(cache.FsyncEverySecond),
+				Usage: "How often the append-only log is flushed to disk. One of always, everysec, or no",
+			},
+			&cli.StringFlag{
+				Name:  "replicaof",
+				Usage: "Address (host:port) of a primary server to replicate. The server rejects writes while it's a replica",
+			},
 		},
 		Action: func(context *cli.Context) error {
-			c := cache.New(-1)
+			evictionPolicy, err := cache.ParseEvictionPolicy(context.String("eviction-policy"))
+
+			if err != nil {
+				return err
+			}
+
+			fsyncPolicy, err := cache.ParseFsyncPolicy(context.String("aof-fsync"))
+
+			if err != nil {
+				return err
+			}
+
+			options := []cache.Option{
+				cache.WithShards(context.Int("shards")),
+				cache.WithMemoryLimit(int64(context.Int("m")) * 1024 * 1024),
+				cache.WithEvictionPolicy(evictionPolicy),
+			}
+
+			if context.Bool("slabs") {
+				options = append(options, cache.WithSlabAllocator(context.Float64("slab-growth-factor")))
+			}
+
+			c := cache.New(-1, options...)
+			snapshotFile := context.String("snapshot-file")
+			aofFile := context.String("aof-file")
+
+			// The append-only log is more recent than the snapshot, so the snapshot is only used to create the log
+			if snapshotFile != "" && (aofFile == "" || !fileExists(aofFile)) {
+				loadSnapshot(c, snapshotFile)
+			}
+
+			if aofFile != "" {
+				appendOnlyLog, err := cache.OpenAppendOnlyLog(c, aofFile, fsyncPolicy)
+
+				if err != nil {
+					return err
+				}
+
+				defer appendOnlyLog.Close()
+			}
+
 			c.RunExpireDataCleanupBackgroundTask(1000)
-			return server.New(c).Run(context.Int("p"))
+			defer c.Close()
+
+			s := server.New(c)
+
+			if primaryAddress := context.String("replicaof"); primaryAddress != "" {
+				if err := s.ReplicaOf(primaryAddress); err != nil {
+					return err
+				}
+			}
+
+			signalContext, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if snapshotFile != "" {
+				go saveSnapshotOnSignal(signalContext, c, snapshotFile)
+			}
+
+			shutdownDone := make(chan error, 1)
+			go func() {
+				<-signalContext.Done()
+				shutdownDone <- shutdown(s, time.Duration(context.Int("shutdown-timeout"))*time.Second)
+			}()
+
+			if err := s.Run(context.Int("p")); !errors.Is(err, server.ErrServerClosed) {
+				return err
+			}
+
+			shutdownErr := <-shutdownDone
+
+			if snapshotFile != "" {
+				return errors.Join(shutdownErr, saveSnapshot(c, snapshotFile))
+			}
+
+			return shutdownErr
 		},
 	}
 
@@ -29,3 +155,83 @@ func main() {
 		log.Fatalf("Error running app: %v\n", err)
 	}
 }
+
+// shutdown stops the server gracefully, waiting up to timeout for the commands in progress to finish
+func shutdown(s *server.Server, timeout time.Duration) error {
+	log.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+
+	log.Println("Server stopped")
+
+	return nil
+}
+
+// loadSnapshot loads the snapshot file into the cache. The server starts with an empty cache if the file doesn't exist
+// or can't be loaded, since the cache can always be rebuilt by clients
+func loadSnapshot(c *cache.Cache, path string) {
+	count, err := c.LoadSnapshot(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Snapshot file %s not found. Starting with an empty cache\n", path)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error loading snapshot file %s after %d items: %v\n", path, count, err)
+		return
+	}
+
+	log.Printf("Loaded %d items from snapshot file %s\n", count, path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+// Prevents a snapshot requested with a signal from writing the file at the same time as the one saved on shutdown
+var snapshotMutex sync.Mutex
+
+func saveSnapshot(c *cache.Cache, path string) error {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	count, err := c.SaveSnapshot(path)
+
+	if err != nil {
+		return fmt.Errorf("error saving snapshot: %v", err)
+	}
+
+	log.Printf("Saved %d items to snapshot file %s\n", count, path)
+
+	return nil
+}
+
+// saveSnapshotOnSignal saves a snapshot every time one of snapshotSignals is received, until ctx is done
+func saveSnapshotOnSignal(ctx context.Context, c *cache.Cache, path string) {
+	if len(snapshotSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, snapshotSignals...)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := saveSnapshot(c, path); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
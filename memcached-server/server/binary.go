@@ -0,0 +1,526 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"memcached-server/cache"
+	"net"
+	"strconv"
+)
+
+// Implementation of the memcached binary protocol. See https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+
+const (
+	binaryRequestMagic  = 0x80
+	binaryResponseMagic = 0x81
+	binaryHeaderLength  = 24
+)
+
+const (
+	opGet        byte = 0x00
+	opSet        byte = 0x01
+	opAdd        byte = 0x02
+	opReplace    byte = 0x03
+	opDelete     byte = 0x04
+	opIncrement  byte = 0x05
+	opDecrement  byte = 0x06
+	opQuit       byte = 0x07
+	opGetQ       byte = 0x09
+	opNoop       byte = 0x0a
+	opGetK       byte = 0x0c
+	opGetKQ      byte = 0x0d
+	opAppend     byte = 0x0e
+	opPrepend    byte = 0x0f
+	opSetQ       byte = 0x11
+	opAddQ       byte = 0x12
+	opReplaceQ   byte = 0x13
+	opDeleteQ    byte = 0x14
+	opIncrementQ byte = 0x15
+	opDecrementQ byte = 0x16
+	opQuitQ      byte = 0x17
+	opAppendQ    byte = 0x19
+	opPrependQ   byte = 0x1a
+)
+
+const (
+	statusNoError          uint16 = 0x0000
+	statusKeyNotFound      uint16 = 0x0001
+	statusKeyExists        uint16 = 0x0002
+	statusValueTooLarge    uint16 = 0x0003
+	statusInvalidArguments uint16 = 0x0004
+	statusItemNotStored    uint16 = 0x0005
+	statusNonNumericValue  uint16 = 0x0006
+	statusUnknownCommand   uint16 = 0x0081
+	statusNotSupported     uint16 = 0x0083
+)
+
+// Largest body accepted in a request: a value of the maximum size plus the longest key and extras the header can describe
+const maxBinaryBodyLength = maxDataBlockSize + math.MaxUint16 + math.MaxUint8
+
+var binaryBodyTooLargeError = errors.New("binary request body too large")
+
+// Expiration value for incr/decr requests that indicates the operation should fail if the key doesn't exist instead of
+// creating it with the initial value
+const noAutoCreateExpiration = 0xffffffff
+
+type binaryHeader struct {
+	Magic        byte
+	Opcode       byte
+	KeyLength    uint16
+	ExtrasLength uint8
+	DataType     uint8
+	// vbucket id for requests, status for responses
+	Status          uint16
+	TotalBodyLength uint32
+	Opaque          uint32
+	Cas             uint64
+}
+
+type binaryRequest struct {
+	header binaryHeader
+	extras []byte
+	key    string
+	value  []byte
+}
+
+type binaryResponse struct {
+	status uint16
+	extras []byte
+	key    string
+	value  []byte
+	cas    uint64
+}
+
+// handleBinaryConnection serves binary protocol requests until the client quits, the connection is closed, or the server
+// shuts down
+func (receiver *Server) handleBinaryConnection(conn *connection, reader *bufio.Reader) {
+	for {
+		// The previous request is done, so the connection can be closed if the server is shutting down
+		if err := conn.finishCommand(reader); err != nil {
+			log.Println("Error sending binary response: ", err)
+			return
+		}
+
+		if receiver.shuttingDown.Load() {
+			return
+		}
+
+		request, readErr := readBinaryRequest(reader)
+
+		if errors.Is(readErr, binaryBodyTooLargeError) {
+			// The body is never read, so there's no way to find the start of the next request either
+			writeErr := writeBinaryResponse(conn.writer, request.header, *errorResponse(statusValueTooLarge))
+			if writeErr == nil {
+				writeErr = conn.writer.Flush()
+			}
+			if writeErr != nil {
+				log.Println("Error sending binary response: ", writeErr)
+			}
+			return
+		}
+
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) && !errors.Is(readErr, net.ErrClosed) {
+				log.Println("Error reading binary request: ", readErr)
+			}
+			// There's no way to find the start of the next request once the framing is broken
+			return
+		}
+
+		conn.busy.Store(true)
+
+		log.Printf("Binary request received: opcode=0x%02x key='%s'\n", request.header.Opcode, request.key)
+
+		response := receiver.processBinaryRequest(request)
+
+		if response != nil {
+			writeErr := writeBinaryResponse(conn.writer, request.header, *response)
+			if writeErr != nil {
+				log.Println("Error sending binary response: ", writeErr)
+				return
+			}
+		}
+
+		if request.header.Opcode == opQuit || request.header.Opcode == opQuitQ {
+			return
+		}
+	}
+}
+
+// processBinaryRequest returns the response for the request. Returns nil if no response should be sent (quiet commands)
+func (receiver *Server) processBinaryRequest(request *binaryRequest) *binaryResponse {
+	opcode := request.header.Opcode
+
+	if receiver.isReadOnly() && isBinaryWrite(opcode) {
+		return errorResponse(statusNotSupported)
+	}
+
+	switch opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		return receiver.processBinaryGet(request)
+	case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ:
+		return quietOnSuccess(opcode, receiver.processBinaryStore(request))
+	case opAppend, opAppendQ, opPrepend, opPrependQ:
+		return quietOnSuccess(opcode, receiver.processBinaryConcat(request))
+	case opDelete, opDeleteQ:
+		return quietOnSuccess(opcode, receiver.processBinaryDelete(request))
+	case opIncrement, opIncrementQ, opDecrement, opDecrementQ:
+		return quietOnSuccess(opcode, receiver.processBinaryIncrDecr(request))
+	case opQuit, opNoop:
+		return &binaryResponse{status: statusNoError}
+	case opQuitQ:
+		return nil
+	}
+
+	return errorResponse(statusUnknownCommand)
+}
+
+func (receiver *Server) processBinaryGet(request *binaryRequest) *binaryResponse {
+	opcode := request.header.Opcode
+	quiet := opcode == opGetQ || opcode == opGetKQ
+	includeKey := opcode == opGetK || opcode == opGetKQ
+
+	if len(request.extras) != 0 || len(request.key) == 0 || len(request.value) != 0 {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	receiver.stats.cmdGet.Add(1)
+	data, err := receiver.cache.Get(request.key)
+
+	if err != nil {
+		receiver.stats.getMisses.Add(1)
+
+		if quiet {
+			return nil
+		}
+
+		response := errorResponse(statusKeyNotFound)
+		if includeKey {
+			response.key = request.key
+		}
+
+		return response
+	}
+
+	receiver.stats.getHits.Add(1)
+
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, uint32(data.Flags))
+
+	response := &binaryResponse{
+		status: statusNoError,
+		extras: extras,
+		value:  data.Value,
+		cas:    data.CasUnique,
+	}
+
+	if includeKey {
+		response.key = request.key
+	}
+
+	return response
+}
+
+func (receiver *Server) processBinaryStore(request *binaryRequest) *binaryResponse {
+	if len(request.extras) != 8 || len(request.key) == 0 {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	flags := binary.BigEndian.Uint32(request.extras[0:4])
+	expiration := binary.BigEndian.Uint32(request.extras[4:8])
+
+	data := cache.Data{
+		Value:     request.value,
+		Flags:     uint16(flags),
+		ByteCount: len(request.value),
+		ExpiresAt: receiver.cache.ExpirationTime(int(expiration)),
+	}
+
+	var casUnique uint64
+	var err error
+	receiver.stats.cmdSet.Add(1)
+
+	switch request.header.Opcode {
+	case opSet, opSetQ, opReplace, opReplaceQ:
+		// A non-zero CAS turns the request into a check-and-set, which also implies that the key must exist
+		if request.header.Cas != 0 {
+			casUnique, err = receiver.cache.Cas(request.key, data, request.header.Cas)
+			receiver.stats.countCas(err)
+		} else if request.header.Opcode == opSet || request.header.Opcode == opSetQ {
+			casUnique, err = receiver.cache.Set(request.key, data)
+		} else {
+			casUnique, err = receiver.cache.Replace(request.key, data)
+		}
+	case opAdd, opAddQ:
+		casUnique, err = receiver.cache.Add(request.key, data)
+	}
+
+	return withCas(casUnique, storeErrorResponse(err))
+}
+
+func (receiver *Server) processBinaryConcat(request *binaryRequest) *binaryResponse {
+	if len(request.extras) != 0 || len(request.key) == 0 {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	data := cache.Data{
+		Value:     request.value,
+		ByteCount: len(request.value),
+	}
+
+	var casUnique uint64
+	var err error
+	receiver.stats.cmdSet.Add(1)
+
+	if request.header.Opcode == opAppend || request.header.Opcode == opAppendQ {
+		casUnique, err = receiver.cache.Append(request.key, data)
+	} else {
+		casUnique, err = receiver.cache.Prepend(request.key, data)
+	}
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return errorResponse(statusItemNotStored)
+	}
+
+	return withCas(casUnique, storeErrorResponse(err))
+}
+
+func (receiver *Server) processBinaryDelete(request *binaryRequest) *binaryResponse {
+	if len(request.extras) != 0 || len(request.key) == 0 || len(request.value) != 0 {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	var err error
+
+	// The CAS unique is compared by the cache so the key can't be updated between the check and the delete
+	if request.header.Cas != 0 {
+		err = receiver.cache.CompareAndDelete(request.key, request.header.Cas)
+	} else {
+		err = receiver.cache.Delete(request.key)
+	}
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if err == nil {
+		receiver.stats.deleteHits.Add(1)
+	} else if errors.As(err, &keyNotFoundError) {
+		receiver.stats.deleteMisses.Add(1)
+	}
+
+	return storeErrorResponse(err)
+}
+
+func (receiver *Server) processBinaryIncrDecr(request *binaryRequest) *binaryResponse {
+	if len(request.extras) != 20 || len(request.key) == 0 || len(request.value) != 0 {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	delta := binary.BigEndian.Uint64(request.extras[0:8])
+	initial := binary.BigEndian.Uint64(request.extras[8:16])
+	expiration := binary.BigEndian.Uint32(request.extras[16:20])
+
+	var newValue, casUnique uint64
+	var err error
+
+	opcode := request.header.Opcode
+	if opcode == opIncrement || opcode == opIncrementQ {
+		newValue, casUnique, err = receiver.cache.Increment(request.key, delta)
+	} else {
+		newValue, casUnique, err = receiver.cache.Decrement(request.key, delta)
+	}
+
+	receiver.stats.countIncrDecr(opcode == opIncrement || opcode == opIncrementQ, err)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) && expiration != noAutoCreateExpiration {
+		newValue = initial
+		value := []byte(strconv.FormatUint(initial, 10))
+
+		casUnique, err = receiver.cache.Add(request.key, cache.Data{
+			Value:     value,
+			ByteCount: len(value),
+			ExpiresAt: receiver.cache.ExpirationTime(int(expiration)),
+		})
+	}
+
+	nonNumericValueError := &cache.NonNumericValueError{}
+	if errors.As(err, &nonNumericValueError) {
+		return errorResponse(statusNonNumericValue)
+	}
+
+	if err != nil {
+		return storeErrorResponse(err)
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, newValue)
+
+	return withCas(casUnique, &binaryResponse{status: statusNoError, value: value})
+}
+
+// withCas sets the CAS of successful responses to casUnique, the CAS unique value assigned to the data stored by the
+// request
+func withCas(casUnique uint64, response *binaryResponse) *binaryResponse {
+	if response.status == statusNoError {
+		response.cas = casUnique
+	}
+
+	return response
+}
+
+// storeErrorResponse maps errors returned by the cache for storage commands to a response
+func storeErrorResponse(err error) *binaryResponse {
+	if err == nil {
+		return &binaryResponse{status: statusNoError}
+	}
+
+	keyExistsError := &cache.KeyAlreadyExistsError{}
+	if errors.As(err, &keyExistsError) {
+		return errorResponse(statusKeyExists)
+	}
+
+	casMismatchError := &cache.CasMismatchError{}
+	if errors.As(err, &casMismatchError) {
+		return errorResponse(statusKeyExists)
+	}
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return errorResponse(statusKeyNotFound)
+	}
+
+	itemTooLargeError := &cache.ItemTooLargeError{}
+	if errors.As(err, &itemTooLargeError) {
+		return errorResponse(statusValueTooLarge)
+	}
+
+	emptyKeyError := &cache.EmptyKeyError{}
+	if errors.As(err, &emptyKeyError) {
+		return errorResponse(statusInvalidArguments)
+	}
+
+	log.Println("Unexpected error processing binary request: ", err)
+
+	return errorResponse(statusItemNotStored)
+}
+
+// quietOnSuccess suppresses successful responses for the quiet variants of commands. Errors are always sent
+func quietOnSuccess(opcode byte, response *binaryResponse) *binaryResponse {
+	if response.status != statusNoError {
+		return response
+	}
+
+	switch opcode {
+	case opSetQ, opAddQ, opReplaceQ, opAppendQ, opPrependQ, opDeleteQ, opIncrementQ, opDecrementQ:
+		return nil
+	}
+
+	return response
+}
+
+func errorResponse(status uint16) *binaryResponse {
+	return &binaryResponse{
+		status: status,
+		value:  []byte(binaryStatusMessage(status)),
+	}
+}
+
+func binaryStatusMessage(status uint16) string {
+	switch status {
+	case statusKeyNotFound:
+		return "Not found"
+	case statusKeyExists:
+		return "Data exists for key."
+	case statusValueTooLarge:
+		return "Too large."
+	case statusInvalidArguments:
+		return "Invalid arguments"
+	case statusItemNotStored:
+		return "Not stored."
+	case statusNonNumericValue:
+		return "Non-numeric server-side value for incr or decr"
+	case statusUnknownCommand:
+		return "Unknown command"
+	case statusNotSupported:
+		return "Not supported"
+	}
+
+	return ""
+}
+
+// readBinaryRequest reads the next request. Returns binaryBodyTooLargeError, along with a request holding only the header,
+// if the body is over maxBinaryBodyLength. The body isn't read in that case
+func readBinaryRequest(reader io.Reader) (*binaryRequest, error) {
+	rawHeader := make([]byte, binaryHeaderLength)
+
+	if _, err := io.ReadFull(reader, rawHeader); err != nil {
+		return nil, err
+	}
+
+	header := binaryHeader{
+		Magic:           rawHeader[0],
+		Opcode:          rawHeader[1],
+		KeyLength:       binary.BigEndian.Uint16(rawHeader[2:4]),
+		ExtrasLength:    rawHeader[4],
+		DataType:        rawHeader[5],
+		Status:          binary.BigEndian.Uint16(rawHeader[6:8]),
+		TotalBodyLength: binary.BigEndian.Uint32(rawHeader[8:12]),
+		Opaque:          binary.BigEndian.Uint32(rawHeader[12:16]),
+		Cas:             binary.BigEndian.Uint64(rawHeader[16:24]),
+	}
+
+	if header.Magic != binaryRequestMagic {
+		return nil, fmt.Errorf("invalid magic byte: 0x%02x", header.Magic)
+	}
+
+	if uint32(header.KeyLength)+uint32(header.ExtrasLength) > header.TotalBodyLength {
+		return nil, fmt.Errorf("key and extras length exceed total body length of %d", header.TotalBodyLength)
+	}
+
+	if header.TotalBodyLength > maxBinaryBodyLength {
+		return &binaryRequest{header: header}, binaryBodyTooLargeError
+	}
+
+	body := make([]byte, header.TotalBodyLength)
+
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	keyStart := int(header.ExtrasLength)
+	valueStart := keyStart + int(header.KeyLength)
+
+	return &binaryRequest{
+		header: header,
+		extras: body[:keyStart],
+		key:    string(body[keyStart:valueStart]),
+		value:  body[valueStart:],
+	}, nil
+}
+
+func writeBinaryResponse(writer io.Writer, requestHeader binaryHeader, response binaryResponse) error {
+	bodyLength := len(response.extras) + len(response.key) + len(response.value)
+	message := make([]byte, binaryHeaderLength, binaryHeaderLength+bodyLength)
+
+	message[0] = binaryResponseMagic
+	message[1] = requestHeader.Opcode
+	binary.BigEndian.PutUint16(message[2:4], uint16(len(response.key)))
+	message[4] = uint8(len(response.extras))
+	binary.BigEndian.PutUint16(message[6:8], response.status)
+	binary.BigEndian.PutUint32(message[8:12], uint32(bodyLength))
+	binary.BigEndian.PutUint32(message[12:16], requestHeader.Opaque)
+	binary.BigEndian.PutUint64(message[16:24], response.cas)
+
+	message = append(message, response.extras...)
+	message = append(message, response.key...)
+	message = append(message, response.value...)
+
+	_, err := writer.Write(message)
+
+	return err
+}
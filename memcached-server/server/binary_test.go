@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"memcached-server/cache"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBinarySetAndGet(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(7, 0), "test_key", "hello")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opSet, statusNoError)
+
+	sendBinaryRequest(t, client, opGet, nil, "test_key", "")
+	response := readBinaryResponse(t, client)
+
+	assertBinaryStatus(t, response, opGet, statusNoError)
+
+	if flags := binary.BigEndian.Uint32(response.extras); flags != 7 {
+		t.Errorf("Unexpected flags: %d\n", flags)
+	}
+
+	if string(response.value) != "hello" {
+		t.Errorf("Unexpected value: '%s'\n", response.value)
+	}
+
+	if response.key != "" {
+		t.Errorf("Key should not be included in GET response. Got '%s'\n", response.key)
+	}
+}
+
+func TestBinaryGetK(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hello")
+	readBinaryResponse(t, client)
+
+	sendBinaryRequest(t, client, opGetK, nil, "test_key", "")
+	response := readBinaryResponse(t, client)
+
+	assertBinaryStatus(t, response, opGetK, statusNoError)
+
+	if response.key != "test_key" {
+		t.Errorf("Unexpected key: '%s'\n", response.key)
+	}
+}
+
+func TestBinaryGetKeyNotFound(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opGet, nil, "test_key", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opGet, statusKeyNotFound)
+}
+
+func TestBinaryQuietCommands(t *testing.T) {
+	client := startTestConnection(t)
+
+	// None of these should produce a response
+	sendBinaryRequest(t, client, opSetQ, storeExtras(0, 0), "key1", "hello")
+	sendBinaryRequest(t, client, opGetQ, nil, "missing_key", "")
+	sendBinaryRequest(t, client, opAppendQ, nil, "key1", " world")
+
+	sendBinaryRequest(t, client, opGetKQ, nil, "key1", "")
+	response := readBinaryResponse(t, client)
+
+	assertBinaryStatus(t, response, opGetKQ, statusNoError)
+
+	if string(response.value) != "hello world" {
+		t.Errorf("Unexpected value: '%s'\n", response.value)
+	}
+
+	sendBinaryRequest(t, client, opNoop, nil, "", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opNoop, statusNoError)
+}
+
+func TestBinaryQuietCommandErrorsAreSent(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opReplaceQ, storeExtras(0, 0), "test_key", "hello")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opReplaceQ, statusKeyNotFound)
+}
+
+func TestBinaryAddKeyAlreadyExists(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opAdd, storeExtras(0, 0), "test_key", "hello")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opAdd, statusNoError)
+
+	sendBinaryRequest(t, client, opAdd, storeExtras(0, 0), "test_key", "hello")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opAdd, statusKeyExists)
+}
+
+func TestBinaryAppendPrepend(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opAppend, nil, "test_key", "b")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opAppend, statusItemNotStored)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "test_key", "b")
+	readBinaryResponse(t, client)
+
+	sendBinaryRequest(t, client, opAppend, nil, "test_key", "c")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opAppend, statusNoError)
+
+	sendBinaryRequest(t, client, opPrepend, nil, "test_key", "a")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opPrepend, statusNoError)
+
+	sendBinaryRequest(t, client, opGet, nil, "test_key", "")
+	response := readBinaryResponse(t, client)
+
+	if string(response.value) != "abc" {
+		t.Errorf("Unexpected value: '%s'\n", response.value)
+	}
+}
+
+func TestBinaryDelete(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hello")
+	readBinaryResponse(t, client)
+
+	sendBinaryRequest(t, client, opDelete, nil, "test_key", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opDelete, statusNoError)
+
+	sendBinaryRequest(t, client, opDelete, nil, "test_key", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opDelete, statusKeyNotFound)
+}
+
+func TestBinaryIncrementDecrement(t *testing.T) {
+	client := startTestConnection(t)
+
+	// Key doesn't exist, so it's created with the initial value
+	sendBinaryRequest(t, client, opIncrement, incrDecrExtras(5, 10, 0), "counter", "")
+	assertCounterValue(t, readBinaryResponse(t, client), 10)
+
+	sendBinaryRequest(t, client, opIncrement, incrDecrExtras(5, 10, 0), "counter", "")
+	assertCounterValue(t, readBinaryResponse(t, client), 15)
+
+	sendBinaryRequest(t, client, opDecrement, incrDecrExtras(100, 0, 0), "counter", "")
+	assertCounterValue(t, readBinaryResponse(t, client), 0)
+}
+
+func TestBinaryIncrementNoAutoCreate(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opIncrement, incrDecrExtras(1, 0, noAutoCreateExpiration), "counter", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opIncrement, statusKeyNotFound)
+}
+
+func TestBinaryIncrementNonNumericValue(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "counter", "hello")
+	readBinaryResponse(t, client)
+
+	sendBinaryRequest(t, client, opIncrement, incrDecrExtras(1, 0, 0), "counter", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opIncrement, statusNonNumericValue)
+}
+
+func TestBinaryCas(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hello")
+	setResponse := readBinaryResponse(t, client)
+
+	sendBinaryRequest(t, client, opGet, nil, "test_key", "")
+	getResponse := readBinaryResponse(t, client)
+
+	if setResponse.cas == 0 || setResponse.cas != getResponse.cas {
+		t.Fatalf("Expected matching non-zero CAS. Set: %d, get: %d\n", setResponse.cas, getResponse.cas)
+	}
+
+	sendBinaryCasRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hi", getResponse.cas)
+	casResponse := readBinaryResponse(t, client)
+	assertBinaryStatus(t, casResponse, opSet, statusNoError)
+
+	// The CAS from the first get is now stale
+	sendBinaryCasRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hey", getResponse.cas)
+	assertBinaryStatus(t, readBinaryResponse(t, client), opSet, statusKeyExists)
+
+	sendBinaryCasRequest(t, client, opDelete, nil, "test_key", "", getResponse.cas)
+	assertBinaryStatus(t, readBinaryResponse(t, client), opDelete, statusKeyExists)
+
+	sendBinaryCasRequest(t, client, opDelete, nil, "test_key", "", casResponse.cas)
+	assertBinaryStatus(t, readBinaryResponse(t, client), opDelete, statusNoError)
+}
+
+func TestBinaryCas_KeyDoesntExist(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryCasRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hello", 1)
+	assertBinaryStatus(t, readBinaryResponse(t, client), opSet, statusKeyNotFound)
+}
+
+func TestBinarySetItemTooLarge(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithMemoryLimit(10)))
+
+	sendBinaryRequest(t, client, opSet, storeExtras(0, 0), "test_key", "hello")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opSet, statusValueTooLarge)
+}
+
+func TestBinaryBodyTooLarge(t *testing.T) {
+	client := startTestConnection(t)
+
+	// Only the header is sent. The server shouldn't wait for (or allocate) the 4GB body
+	request := buildBinaryRequest(opSet, storeExtras(0, 0), "test_key", "")
+	binary.BigEndian.PutUint32(request[8:12], 0xffffffff)
+	writeTestBytes(t, client, request[:binaryHeaderLength])
+
+	assertBinaryStatus(t, readBinaryResponse(t, client), opSet, statusValueTooLarge)
+
+	_, err := client.reader.ReadByte()
+
+	if err != io.EOF {
+		t.Errorf("Expected connection to be closed. Got %v\n", err)
+	}
+}
+
+func TestBinaryUnknownCommand(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, 0xf0, nil, "", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), 0xf0, statusUnknownCommand)
+}
+
+func TestBinaryQuit(t *testing.T) {
+	client := startTestConnection(t)
+
+	sendBinaryRequest(t, client, opQuit, nil, "", "")
+	assertBinaryStatus(t, readBinaryResponse(t, client), opQuit, statusNoError)
+
+	_, err := client.reader.ReadByte()
+
+	if err != io.EOF {
+		t.Errorf("Expected connection to be closed. Got %v\n", err)
+	}
+}
+
+func TestBinaryOpaqueIsEchoed(t *testing.T) {
+	client := startTestConnection(t)
+
+	request := buildBinaryRequest(opNoop, nil, "", "")
+	binary.BigEndian.PutUint32(request[12:16], 0xdeadbeef)
+	writeTestBytes(t, client, request)
+
+	rawHeader := make([]byte, binaryHeaderLength)
+	if _, err := io.ReadFull(client.reader, rawHeader); err != nil {
+		t.Fatal(err)
+	}
+
+	if opaque := binary.BigEndian.Uint32(rawHeader[12:16]); opaque != 0xdeadbeef {
+		t.Errorf("Unexpected opaque: 0x%x\n", opaque)
+	}
+}
+
+func TestTextProtocolStillSupported(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test_key 0 0 5\r\nhello\r\n"))
+
+	line, err := client.reader.ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line != "STORED\r\n" {
+		t.Errorf("Unexpected response: '%s'\n", line)
+	}
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type testBinaryResponse struct {
+	opcode byte
+	status uint16
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+func startTestConnection(t *testing.T) *testClient {
+	return startTestConnectionWithCache(t, cache.New(-1))
+}
+
+func startTestConnectionWithCache(t *testing.T, c Storage) *testClient {
+	clientConn, serverConn := net.Pipe()
+	server := New(c)
+
+	go server.handleConnection(serverConn)
+
+	t.Cleanup(func() {
+		clientConn.Close()
+	})
+
+	return &testClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+func buildBinaryRequest(opcode byte, extras []byte, key string, value string) []byte {
+	bodyLength := len(extras) + len(key) + len(value)
+	request := make([]byte, binaryHeaderLength, binaryHeaderLength+bodyLength)
+
+	request[0] = binaryRequestMagic
+	request[1] = opcode
+	binary.BigEndian.PutUint16(request[2:4], uint16(len(key)))
+	request[4] = uint8(len(extras))
+	binary.BigEndian.PutUint32(request[8:12], uint32(bodyLength))
+
+	request = append(request, extras...)
+	request = append(request, key...)
+	request = append(request, value...)
+
+	return request
+}
+
+func sendBinaryRequest(t *testing.T, client *testClient, opcode byte, extras []byte, key string, value string) {
+	writeTestBytes(t, client, buildBinaryRequest(opcode, extras, key, value))
+}
+
+func sendBinaryCasRequest(t *testing.T, client *testClient, opcode byte, extras []byte, key string, value string, cas uint64) {
+	request := buildBinaryRequest(opcode, extras, key, value)
+	binary.BigEndian.PutUint64(request[16:24], cas)
+
+	writeTestBytes(t, client, request)
+}
+
+func writeTestBytes(t *testing.T, client *testClient, message []byte) {
+	client.conn.SetWriteDeadline(time.Now().Add(time.Second))
+
+	if _, err := client.conn.Write(message); err != nil {
+		t.Fatalf("Error writing request: %v\n", err)
+	}
+}
+
+func readBinaryResponse(t *testing.T, client *testClient) testBinaryResponse {
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	rawHeader := make([]byte, binaryHeaderLength)
+	if _, err := io.ReadFull(client.reader, rawHeader); err != nil {
+		t.Fatalf("Error reading response header: %v\n", err)
+	}
+
+	if rawHeader[0] != binaryResponseMagic {
+		t.Fatalf("Unexpected magic byte: 0x%02x\n", rawHeader[0])
+	}
+
+	keyLength := int(binary.BigEndian.Uint16(rawHeader[2:4]))
+	extrasLength := int(rawHeader[4])
+	body := make([]byte, binary.BigEndian.Uint32(rawHeader[8:12]))
+
+	if _, err := io.ReadFull(client.reader, body); err != nil {
+		t.Fatalf("Error reading response body: %v\n", err)
+	}
+
+	return testBinaryResponse{
+		opcode: rawHeader[1],
+		status: binary.BigEndian.Uint16(rawHeader[6:8]),
+		cas:    binary.BigEndian.Uint64(rawHeader[16:24]),
+		extras: body[:extrasLength],
+		key:    string(body[extrasLength : extrasLength+keyLength]),
+		value:  body[extrasLength+keyLength:],
+	}
+}
+
+func storeExtras(flags uint32, expiration uint32) []byte {
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras[0:4], flags)
+	binary.BigEndian.PutUint32(extras[4:8], expiration)
+
+	return extras
+}
+
+func incrDecrExtras(delta uint64, initial uint64, expiration uint32) []byte {
+	extras := make([]byte, 20)
+	binary.BigEndian.PutUint64(extras[0:8], delta)
+	binary.BigEndian.PutUint64(extras[8:16], initial)
+	binary.BigEndian.PutUint32(extras[16:20], expiration)
+
+	return extras
+}
+
+func assertBinaryStatus(t *testing.T, response testBinaryResponse, opcode byte, status uint16) {
+	t.Helper()
+
+	if response.opcode != opcode {
+		t.Errorf("Unexpected opcode. Expected 0x%02x, got 0x%02x\n", opcode, response.opcode)
+	}
+
+	if response.status != status {
+		t.Errorf("Unexpected status. Expected 0x%04x, got 0x%04x\n", status, response.status)
+	}
+}
+
+func assertCounterValue(t *testing.T, response testBinaryResponse, expected uint64) {
+	t.Helper()
+
+	if response.status != statusNoError {
+		t.Fatalf("Unexpected status: 0x%04x\n", response.status)
+	}
+
+	if len(response.value) != 8 {
+		t.Fatalf("Expected 8 byte counter value, got %d bytes\n", len(response.value))
+	}
+
+	if value := binary.BigEndian.Uint64(response.value); value != expected {
+		t.Errorf("Unexpected counter value. Expected %d, got %d\n", expected, value)
+	}
+}
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"memcached-server/cache"
+	"memcached-server/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metaNotSupportedReply = "SERVER_ERROR meta commands not supported by the storage"
+
+// processMeta handles the meta commands: `mg` (get), `ms` (set), `md` (delete), `ma` (arithmetic), `me` (debug), and
+// `mn` (no-op). Replies start with a status code followed by the flags requested, in the order they were sent
+func (receiver *Server) processMeta(command utils.Command, value []byte) (string, error) {
+	if command.Name == "mn" {
+		return "MN", nil
+	}
+
+	storage, ok := receiver.cache.(MetaStorage)
+
+	if !ok {
+		return metaNotSupportedReply, nil
+	}
+
+	switch command.Name {
+	case "mg":
+		return receiver.processMetaGet(storage, command)
+	case "ms":
+		return receiver.processMetaSet(storage, command, value)
+	case "md":
+		return receiver.processMetaDelete(storage, command)
+	case "ma":
+		return receiver.processMetaArithmetic(storage, command)
+	case "me":
+		return receiver.processMetaDebug(storage, command)
+	}
+
+	return "", fmt.Errorf("unexpected command name '%s'", command.Name)
+}
+
+func (receiver *Server) processMetaGet(storage MetaStorage, command utils.Command) (string, error) {
+	options := cache.MetaGetOptions{NoBump: command.HasMetaFlag('u')}
+
+	if token, ok := command.MetaFlag('T'); ok {
+		options.Touch = true
+		options.ExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('N'); ok {
+		options.Vivify = true
+		options.VivifyExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	// Non-positive tokens never win, since no remaining TTL is lower
+	if token, ok := command.MetaFlag('R'); ok && metaNumber(token) > 0 {
+		options.RecacheBefore = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('E'); ok {
+		options.CasUnique = metaUnsignedNumber(token)
+	}
+
+	receiver.stats.cmdGet.Add(1)
+	item, err := storage.MetaGet(command.Key, options)
+
+	if options.Touch {
+		receiver.stats.countTouch(err)
+	}
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		receiver.stats.getMisses.Add(1)
+		return metaReply("EN", metaEchoFlags(command)), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	receiver.stats.getHits.Add(1)
+	now := storage.Now()
+	var flags []string
+
+	for _, flag := range command.MetaFlags {
+		switch flag.Name {
+		case 'c':
+			flags = append(flags, fmt.Sprintf("c%d", item.CasUnique))
+		case 'f':
+			flags = append(flags, fmt.Sprintf("f%d", item.Flags))
+		case 'h':
+			flags = append(flags, "h"+metaBool(item.Fetched))
+		case 'l':
+			flags = append(flags, fmt.Sprintf("l%d", int64(now.Sub(item.LastAccessedAt).Seconds())))
+		case 's':
+			flags = append(flags, fmt.Sprintf("s%d", len(item.Value)))
+		case 't':
+			flags = append(flags, fmt.Sprintf("t%d", timeToLive(item.Data, now)))
+		default:
+			flags = appendEchoFlag(flags, command, flag)
+		}
+	}
+
+	flags = appendBase64Flag(flags, command)
+
+	// Z tells clients that another one is already recaching the item, so they shouldn't. It's never sent along with W
+	if item.WinTokenSent {
+		flags = append(flags, "Z")
+	}
+
+	if item.Stale {
+		flags = append(flags, "X")
+	}
+
+	if item.Won {
+		flags = append(flags, "W")
+	}
+
+	if !command.HasMetaFlag('v') {
+		return metaReply("HD", flags), nil
+	}
+
+	return metaReply(fmt.Sprintf("VA %d", len(item.Value)), flags) + "\r\n" + string(item.Value), nil
+}
+
+func (receiver *Server) processMetaSet(storage MetaStorage, command utils.Command, value []byte) (string, error) {
+	data := cache.Data{Value: value, ByteCount: len(value), ExpiresAt: receiver.cache.ExpirationTime(0)}
+	options := cache.MetaSetOptions{Mode: cache.MetaSetModeSet, Invalidate: command.HasMetaFlag('I')}
+
+	if token, ok := command.MetaFlag('F'); ok {
+		data.Flags = uint16(metaUnsignedNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('T'); ok {
+		data.ExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('M'); ok {
+		options.Mode = cache.MetaSetMode(strings.ToUpper(token)[0])
+	}
+
+	if token, ok := command.MetaFlag('C'); ok {
+		options.CompareCas = true
+		options.CompareCasUnique = metaUnsignedNumber(token)
+	}
+
+	if token, ok := command.MetaFlag('N'); ok {
+		options.Vivify = true
+		options.VivifyExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('E'); ok {
+		options.CasUnique = metaUnsignedNumber(token)
+	}
+
+	receiver.stats.cmdSet.Add(1)
+	stored, err := storage.MetaSet(command.Key, data, options)
+
+	if options.CompareCas {
+		receiver.stats.countCas(err)
+	}
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	keyAlreadyExistsError := &cache.KeyAlreadyExistsError{}
+	casMismatchError := &cache.CasMismatchError{}
+
+	switch {
+	case errors.As(err, &keyNotFoundError) && options.CompareCas:
+		return metaReply("NF", metaEchoFlags(command)), nil
+	case errors.As(err, &keyNotFoundError), errors.As(err, &keyAlreadyExistsError):
+		return metaReply("NS", metaEchoFlags(command)), nil
+	case errors.As(err, &casMismatchError):
+		return metaReply("EX", metaEchoFlags(command)), nil
+	case err != nil:
+		return "", err
+	}
+
+	var flags []string
+
+	for _, flag := range command.MetaFlags {
+		if flag.Name == 'c' {
+			flags = append(flags, fmt.Sprintf("c%d", stored.CasUnique))
+		} else {
+			flags = appendEchoFlag(flags, command, flag)
+		}
+	}
+
+	return metaReply("HD", appendBase64Flag(flags, command)), nil
+}
+
+func (receiver *Server) processMetaDelete(storage MetaStorage, command utils.Command) (string, error) {
+	options := cache.MetaDeleteOptions{Invalidate: command.HasMetaFlag('I'), ClearValue: command.HasMetaFlag('x')}
+
+	if token, ok := command.MetaFlag('C'); ok {
+		options.CompareCas = true
+		options.CompareCasUnique = metaUnsignedNumber(token)
+	}
+
+	if token, ok := command.MetaFlag('T'); ok {
+		options.Touch = true
+		options.ExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('E'); ok {
+		options.CasUnique = metaUnsignedNumber(token)
+	}
+
+	err := storage.MetaDelete(command.Key, options)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	casMismatchError := &cache.CasMismatchError{}
+
+	switch {
+	case errors.As(err, &keyNotFoundError):
+		receiver.stats.deleteMisses.Add(1)
+		return metaReply("NF", metaEchoFlags(command)), nil
+	case errors.As(err, &casMismatchError):
+		return metaReply("EX", metaEchoFlags(command)), nil
+	case err != nil:
+		return "", err
+	}
+
+	receiver.stats.deleteHits.Add(1)
+
+	return metaReply("HD", metaEchoFlags(command)), nil
+}
+
+func (receiver *Server) processMetaArithmetic(storage MetaStorage, command utils.Command) (string, error) {
+	options := cache.MetaArithmeticOptions{Delta: 1}
+
+	if token, ok := command.MetaFlag('M'); ok {
+		options.Decrement = strings.ContainsAny(token, "Dd-")
+	}
+
+	if token, ok := command.MetaFlag('D'); ok {
+		options.Delta = metaUnsignedNumber(token)
+	}
+
+	if token, ok := command.MetaFlag('C'); ok {
+		options.CompareCas = true
+		options.CompareCasUnique = metaUnsignedNumber(token)
+	}
+
+	if token, ok := command.MetaFlag('N'); ok {
+		options.Vivify = true
+		options.VivifyExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('J'); ok {
+		options.InitialValue = metaUnsignedNumber(token)
+	}
+
+	if token, ok := command.MetaFlag('T'); ok {
+		options.Touch = true
+		options.ExpiresAt = receiver.cache.ExpirationTime(metaNumber(token))
+	}
+
+	if token, ok := command.MetaFlag('E'); ok {
+		options.CasUnique = metaUnsignedNumber(token)
+	}
+
+	data, err := storage.MetaArithmetic(command.Key, options)
+	receiver.stats.countIncrDecr(!options.Decrement, err)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	casMismatchError := &cache.CasMismatchError{}
+	nonNumericValueError := &cache.NonNumericValueError{}
+
+	switch {
+	case errors.As(err, &keyNotFoundError):
+		return metaReply("NF", metaEchoFlags(command)), nil
+	case errors.As(err, &casMismatchError):
+		return metaReply("EX", metaEchoFlags(command)), nil
+	case errors.As(err, &nonNumericValueError):
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value", nil
+	case err != nil:
+		return "", err
+	}
+
+	var flags []string
+
+	for _, flag := range command.MetaFlags {
+		switch flag.Name {
+		case 'c':
+			flags = append(flags, fmt.Sprintf("c%d", data.CasUnique))
+		case 't':
+			flags = append(flags, fmt.Sprintf("t%d", timeToLive(data, storage.Now())))
+		default:
+			flags = appendEchoFlag(flags, command, flag)
+		}
+	}
+
+	flags = appendBase64Flag(flags, command)
+
+	if !command.HasMetaFlag('v') {
+		return metaReply("HD", flags), nil
+	}
+
+	return metaReply(fmt.Sprintf("VA %d", len(data.Value)), flags) + "\r\n" + string(data.Value), nil
+}
+
+// processMetaDebug returns a human-readable description of the item, without counting it as an access
+func (receiver *Server) processMetaDebug(storage MetaStorage, command utils.Command) (string, error) {
+	item, err := storage.Inspect(command.Key)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return "EN", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	now := storage.Now()
+	fetched := "no"
+
+	if item.Fetched {
+		fetched = "yes"
+	}
+
+	return fmt.Sprintf(
+		"ME %s exp=%d la=%d cas=%d fetch=%s cls=%d size=%d",
+		metaKey(command),
+		timeToLive(item.Data, now),
+		int64(now.Sub(item.LastAccessedAt).Seconds()),
+		item.CasUnique,
+		fetched,
+		item.SlabClass,
+		len(item.Value),
+	), nil
+}
+
+// metaEchoFlags returns the flags sent back in every reply: the opaque token (`O`) and the key (`k`)
+func metaEchoFlags(command utils.Command) []string {
+	var flags []string
+
+	for _, flag := range command.MetaFlags {
+		flags = appendEchoFlag(flags, command, flag)
+	}
+
+	return appendBase64Flag(flags, command)
+}
+
+// appendEchoFlag appends the flag to flags if it's sent back in every reply (see metaEchoFlags)
+func appendEchoFlag(flags []string, command utils.Command, flag utils.MetaFlag) []string {
+	switch flag.Name {
+	case 'O':
+		return append(flags, "O"+flag.Token)
+	case 'k':
+		return append(flags, "k"+metaKey(command))
+	}
+
+	return flags
+}
+
+// appendBase64Flag appends `b` to flags if the key is sent back base64 encoded
+func appendBase64Flag(flags []string, command utils.Command) []string {
+	if command.HasMetaFlag('k') && command.HasMetaFlag('b') {
+		return append(flags, "b")
+	}
+
+	return flags
+}
+
+// metaKey returns the key as it was sent, i.e., base64 encoded if the `b` flag is set
+func metaKey(command utils.Command) string {
+	if command.HasMetaFlag('b') {
+		return base64.StdEncoding.EncodeToString([]byte(command.Key))
+	}
+
+	return command.Key
+}
+
+func metaReply(code string, flags []string) string {
+	if len(flags) == 0 {
+		return code
+	}
+
+	return code + " " + strings.Join(flags, " ")
+}
+
+func metaBool(value bool) string {
+	if value {
+		return "1"
+	}
+
+	return "0"
+}
+
+// metaNumber parses the token of a numeric flag. Tokens are validated by utils.ParseCommand
+func metaNumber(token string) int {
+	number, _ := strconv.Atoi(token)
+
+	return number
+}
+
+func metaUnsignedNumber(token string) uint64 {
+	number, _ := strconv.ParseUint(token, 10, 64)
+
+	return number
+}
+
+// timeToLive returns the number of seconds until the data expires, rounded up, or -1 if it never expires
+func timeToLive(data cache.Data, now time.Time) int64 {
+	if data.ExpiresAt.UnixMilli() <= 0 {
+		return -1
+	}
+
+	return int64(math.Ceil(data.ExpiresAt.Sub(now).Seconds()))
+}
+
+// isQuietMetaReply returns whether the reply is omitted when a meta command has the `q` flag. Only the replies that
+// clients don't need to act on are omitted, e.g., misses for `mg` or successes for `ms`
+func isQuietMetaReply(name string, reply string) bool {
+	code, _, _ := strings.Cut(reply, " ")
+
+	switch name {
+	case "mg":
+		return code == "EN"
+	case "ms", "ma":
+		return code == "HD"
+	case "md":
+		return code == "HD" || code == "NF"
+	}
+
+	return false
+}
+
+// isMetaCommand returns whether the command is part of the meta protocol
+func isMetaCommand(name string) bool {
+	switch name {
+	case "mg", "ms", "md", "ma", "me", "mn":
+		return true
+	}
+
+	return false
+}
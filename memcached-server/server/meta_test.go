@@ -0,0 +1,269 @@
+package server
+
+import (
+	"memcached-server/cache"
+	"memcached-server/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetaSetAndGet(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5 F3 T0\r\nhello\r\nmg test v f s t\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "VA 5 f3 s5 t-1\r\n", "hello\r\n")
+}
+
+func TestMetaGetMiss(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("mg test v Oabc k\r\n"))
+
+	assertTextResponse(t, client, "EN Oabc ktest\r\n")
+}
+
+func TestMetaGetReturnsCas(t *testing.T) {
+	c := cache.New(-1)
+	client := startTestConnectionWithCache(t, c)
+
+	writeTestBytes(t, client, []byte("ms test 5 c\r\nhello\r\n"))
+
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := client.reader.ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Peek("test")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	expected := "HD c" + itoa(data.CasUnique) + "\r\n"
+
+	if line != expected {
+		t.Fatalf("Unexpected response. Expected '%s', got '%s'\n", expected, line)
+	}
+
+	writeTestBytes(t, client, []byte("mg test c\r\n"))
+
+	assertTextResponse(t, client, expected)
+}
+
+func TestMetaGetHitBeforeAndLastAccess(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5\r\nhello\r\nmg test h l\r\nmg test h\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "HD h0 l0\r\n", "HD h1\r\n")
+}
+
+func TestMetaTimesUseStorageClock(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithTimeSource(timeSource)))
+
+	writeTestBytes(t, client, []byte("ms test 1 T100\r\n1\r\n"))
+	assertTextResponse(t, client, "HD\r\n")
+
+	timeSource.Advance(time.Second * 40)
+	writeTestBytes(t, client, []byte("me test\r\nmg test t l\r\nma test t\r\n"))
+
+	assertTextResponse(t, client, "ME test exp=60 la=40 cas=1 fetch=no cls=1 size=1\r\n", "HD t60 l40\r\n", "HD t60\r\n")
+}
+
+func TestMetaGetBase64Key(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms dGVzdA== 5 b\r\nhello\r\nmg test v\r\nmg dGVzdA== b k\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "VA 5\r\n", "hello\r\n", "HD kdGVzdA== b\r\n")
+}
+
+func TestMetaSetModes(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5 MR\r\nhello\r\nms test 5 ME\r\nhello\r\nms test 5 ME\r\nworld\r\n"))
+
+	assertTextResponse(t, client, "NS\r\n", "HD\r\n", "NS\r\n")
+
+	writeTestBytes(t, client, []byte("ms test 1 MA\r\n!\r\nms test 1 Mp\r\n>\r\nmg test v\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "HD\r\n", "VA 7\r\n", ">hello!\r\n")
+}
+
+func TestMetaSetCompareCas(t *testing.T) {
+	c := cache.New(-1)
+	client := startTestConnectionWithCache(t, c)
+
+	writeTestBytes(t, client, []byte("ms test 5 C1\r\nhello\r\n"))
+
+	assertTextResponse(t, client, "NF\r\n")
+
+	if _, err := c.Set("test", testData("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	data, _ := c.Peek("test")
+
+	message := "ms test 5 C" + itoa(data.CasUnique+1) + "\r\nworld\r\nms test 5 C" + itoa(data.CasUnique) + "\r\nworld\r\n"
+
+	writeTestBytes(t, client, []byte(message))
+
+	assertTextResponse(t, client, "EX\r\n", "HD\r\n")
+}
+
+func TestMetaSetInvalidFlagSkipsDataBlock(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 3 Zbad\r\nabc\r\nms test 0 MX\r\n\r\nmn\r\n"))
+
+	assertTextResponse(t, client, "CLIENT_ERROR invalid flag\r\n", "CLIENT_ERROR invalid mode for ms STORE\r\n", "MN\r\n")
+}
+
+func TestMetaSetExplicitCas(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5 E42 c\r\nhello\r\nmg test c\r\n"))
+
+	assertTextResponse(t, client, "HD c42\r\n", "HD c42\r\n")
+}
+
+func TestMetaDelete(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5\r\nhello\r\nmd test\r\nmd test\r\nmg test\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "HD\r\n", "NF\r\n", "EN\r\n")
+}
+
+func TestMetaDeleteInvalidate(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5\r\nhello\r\nmd test I T30\r\nmg test v c\r\nmg test v\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "HD\r\n")
+
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := client.reader.ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first client to see the stale item is told to recache it
+	if !regexp.MustCompile(`^VA 5 c\d+ X W\r\n$`).MatchString(line) {
+		t.Fatalf("Unexpected response: '%s'\n", line)
+	}
+
+	assertTextResponse(t, client, "hello\r\n", "VA 5 Z X\r\n", "hello\r\n")
+}
+
+func TestMetaGetVivify(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("mg test s N30\r\nmg test s N30\r\n"))
+
+	assertTextResponse(t, client, "HD s0 W\r\n", "HD s0 Z\r\n")
+}
+
+func TestMetaArithmetic(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ma counter\r\nma counter N0 J10 v\r\nma counter D5 v\r\nma counter MD D20 v\r\n"))
+
+	assertTextResponse(t, client, "NF\r\n", "VA 2\r\n", "10\r\n", "VA 2\r\n", "15\r\n", "VA 1\r\n", "0\r\n")
+}
+
+func TestMetaArithmeticNonNumericValue(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5\r\nhello\r\nma test\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+}
+
+func TestMetaQuietMode(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("ms test 5 q\r\nhello\r\nms test 5 q ME\r\nhello\r\nmg missing v q\r\nmd missing q\r\nmn\r\n"))
+
+	assertTextResponse(t, client, "NS\r\n", "MN\r\n")
+}
+
+func TestMetaDebug(t *testing.T) {
+	c := cache.New(-1)
+	client := startTestConnectionWithCache(t, c)
+
+	writeTestBytes(t, client, []byte("ms test 5 E7\r\nhello\r\nme test\r\nme missing\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n", "ME test exp=-1 la=0 cas=7 fetch=no cls=1 size=5\r\n", "EN\r\n")
+}
+
+func TestMetaDebugWithSlabs(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithSlabAllocator(0)))
+
+	writeTestBytes(t, client, []byte("ms test 200 E7\r\n"+strings.Repeat("a", 200)+"\r\nme test\r\n"))
+
+	assertTextResponse(t, client, "HD\r\n")
+
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := client.reader.ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 200 bytes don't fit in the chunks of the first class
+	if !regexp.MustCompile(`^ME test exp=-1 la=0 cas=7 fetch=no cls=([2-9]|\d\d+) size=200\r\n$`).MatchString(line) {
+		t.Fatalf("Unexpected response: '%s'\n", line)
+	}
+}
+
+func TestMetaCommandsOnReplica(t *testing.T) {
+	server := New(cache.New(-1))
+	server.replication.readOnly.Store(true)
+
+	for _, command := range []utils.Command{
+		{Name: "ms", Key: "test"},
+		{Name: "mg", Key: "test", MetaFlags: []utils.MetaFlag{{Name: 'T', Token: "30"}}},
+	} {
+		result, err := server.processCommand(command, []byte("hello"))
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+
+		if result != readOnlyReplicaReply {
+			t.Fatalf("Unexpected result for '%s': '%s'\n", command.Name, result)
+		}
+	}
+
+	result, _ := server.processCommand(utils.Command{Name: "mg", Key: "test"}, nil)
+
+	if result != "EN" {
+		t.Fatalf("Unexpected result: '%s'\n", result)
+	}
+}
+
+func TestMetaCommandsNotSupported(t *testing.T) {
+	server := New(&mockStorage{})
+	result, err := server.processCommand(utils.Command{Name: "mg", Key: "test"}, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if result != metaNotSupportedReply {
+		t.Fatalf("Unexpected result: '%s'\n", result)
+	}
+}
+
+func itoa(number uint64) string {
+	return strconv.FormatUint(number, 10)
+}
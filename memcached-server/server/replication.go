@@ -0,0 +1,367 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"memcached-server/cache"
+	"memcached-server/utils"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication protocol: a replica connects to the primary and sends `sync`. The primary replies with a snapshot of the
+// cache (see cache.Cache.WriteSnapshot) followed by every later mutation (see cache.WriteMutation) for as long as the
+// connection is open. Replicas apply the mutations to their own cache and reject writes from clients
+
+// Number of mutations queued for a replica before it's considered too slow to keep up and is disconnected
+const replicaBufferSize = 64 * 1024
+
+// Time to wait before reconnecting to the primary after the connection fails
+const replicationRetryInterval = time.Second
+
+// Time to wait for the connection to the primary to be established before retrying
+const replicationDialTimeout = 5 * time.Second
+
+const readOnlyReplicaReply = "SERVER_ERROR read-only replica"
+
+// replication state of a server, both as a primary of other servers and as a replica
+type replication struct {
+	// Replicas connected to the server
+	replicas map[*replica]struct{}
+	// Guards replicas
+	replicasMutex *sync.Mutex
+	// Used to register the mutation listener the first time a replica connects
+	listenerOnce *sync.Once
+	// Whether the server is a replica, in which case writes from clients are rejected
+	readOnly *atomic.Bool
+	// Address of the primary. Empty if the server isn't a replica
+	primaryAddress string
+	// Closed to stop replicating. Nil if the server isn't a replica
+	stop chan struct{}
+	// Closed by the replication task once it stops
+	done chan struct{}
+	// Guards primaryAddress, stop, and done
+	roleMutex *sync.Mutex
+}
+
+// replica connection of a replica to this server
+type replica struct {
+	// Encoded mutations waiting to be sent. Closed if the replica falls too far behind
+	mutations chan []byte
+}
+
+func newReplication() *replication {
+	return &replication{
+		replicas:      make(map[*replica]struct{}),
+		replicasMutex: &sync.Mutex{},
+		listenerOnce:  &sync.Once{},
+		readOnly:      &atomic.Bool{},
+		roleMutex:     &sync.Mutex{},
+	}
+}
+
+// ReplicaOf makes the server a replica of the primary at address (`host:port`). The contents of the cache are replaced
+// by the ones of the primary, and writes from clients are rejected. The connection is retried in the background until
+// it succeeds. An empty address stops replicating, making the server a primary again. Writes are accepted right away
+// and the contents of the cache are kept. Returns ErrReplicationNotSupported if the storage of the server doesn't
+// implement ReplicableStorage
+func (receiver *Server) ReplicaOf(address string) error {
+	storage, ok := receiver.cache.(ReplicableStorage)
+
+	if !ok && address != "" {
+		return ErrReplicationNotSupported
+	}
+
+	state := receiver.replication
+
+	state.roleMutex.Lock()
+	defer state.roleMutex.Unlock()
+
+	if address == "" {
+		if state.stop != nil {
+			log.Printf("Stopped replicating %s. Accepting writes\n", state.primaryAddress)
+		}
+
+		receiver.stopReplicating()
+		state.readOnly.Store(false)
+		return nil
+	}
+
+	receiver.stopReplicating()
+
+	state.readOnly.Store(true)
+	state.primaryAddress = address
+	state.stop = make(chan struct{})
+	state.done = make(chan struct{})
+
+	go receiver.replicate(storage, address, state.stop, state.done)
+
+	return nil
+}
+
+// PrimaryAddress returns the address of the primary the server replicates. Empty if the server isn't a replica
+func (receiver *Server) PrimaryAddress() string {
+	receiver.replication.roleMutex.Lock()
+	defer receiver.replication.roleMutex.Unlock()
+
+	return receiver.replication.primaryAddress
+}
+
+// isReadOnly returns whether writes from clients are rejected because the server is a replica
+func (receiver *Server) isReadOnly() bool {
+	return receiver.replication.readOnly.Load()
+}
+
+// stopReplicating stops the replication task and waits for it to stop, if it's running. Assumes the caller holds the
+// role mutex
+func (receiver *Server) stopReplicating() {
+	state := receiver.replication
+
+	if state.stop == nil {
+		return
+	}
+
+	close(state.stop)
+	<-state.done
+
+	state.primaryAddress = ""
+	state.stop = nil
+	state.done = nil
+}
+
+// replicate syncs with the primary, reconnecting whenever the connection fails, until stop is closed
+func (receiver *Server) replicate(storage ReplicableStorage, address string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		err := syncWithPrimary(storage, address, stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("Lost connection to primary %s: %v. Retrying in %v\n", address, err, replicationRetryInterval)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(replicationRetryInterval):
+		}
+	}
+}
+
+// syncWithPrimary replaces the contents of the storage with the snapshot sent by the primary, then applies the
+// mutations it sends until the connection fails or stop is closed
+func syncWithPrimary(storage ReplicableStorage, address string, stop <-chan struct{}) error {
+	// Canceled once replication is stopped, so stopping doesn't wait for the connection attempt or the reads below
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := (&net.Dialer{Timeout: replicationDialTimeout}).DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	// Closing the connection unblocks the reads below
+	context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	if _, err := conn.Write([]byte("sync\r\n")); err != nil {
+		return err
+	}
+
+	// ReadSnapshot reuses the buffered reader rather than wrapping it, so the mutations following the snapshot aren't
+	// consumed while reading it
+	reader := bufio.NewReader(conn)
+
+	storage.Clear()
+	count, err := storage.ReadSnapshot(reader)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Synced %d items from primary %s\n", count, address)
+
+	for {
+		mutation, err := cache.ReadMutation(reader)
+
+		if err != nil {
+			return err
+		}
+
+		// Items might not fit if the memory limit of the replica is lower than the one of the primary
+		itemTooLargeError := &cache.ItemTooLargeError{}
+		if err := storage.Apply(mutation); err != nil && !errors.As(err, &itemTooLargeError) {
+			return err
+		}
+	}
+}
+
+// serveReplica sends a snapshot of the cache to a replica that sent `sync`, followed by every later mutation, until
+// the connection is closed
+func (receiver *Server) serveReplica(conn *connection) {
+	// Replicas don't send commands, so the connection can be closed right away on shutdown
+	conn.busy.Store(false)
+
+	storage, ok := receiver.cache.(ReplicableStorage)
+
+	if !ok {
+		sendMessage("SERVER_ERROR "+ErrReplicationNotSupported.Error()+"\r\n", conn)
+		return
+	}
+
+	r := receiver.addReplica(storage)
+	defer receiver.removeReplica(r)
+
+	log.Printf("Replica %s connected\n", conn.RemoteAddr())
+
+	// Mutations made while the snapshot is written are also queued, so some of them might be sent twice. That's fine
+	// since applying them again has no effect
+	if _, err := storage.WriteSnapshot(conn); err != nil {
+		log.Printf("Error sending snapshot to replica %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+
+	writer := bufio.NewWriter(conn)
+
+	// The snapshot includes the items a pending flush will invalidate, so the replica needs to invalidate them as well
+	if flushAt, pending := storage.PendingFlush(); pending {
+		cache.WriteMutation(writer, cache.Mutation{Type: cache.MutationFlush, FlushAt: flushAt})
+
+		if err := writer.Flush(); err != nil {
+			log.Printf("Error sending pending flush to replica %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	// The replica doesn't send anything else, so reading only returns once the connection is closed
+	disconnected := make(chan struct{})
+
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(disconnected)
+	}()
+
+	for {
+		select {
+		case <-disconnected:
+			log.Printf("Replica %s disconnected\n", conn.RemoteAddr())
+			return
+		case mutation, ok := <-r.mutations:
+			if !ok {
+				log.Printf("Replica %s fell too far behind. Disconnecting\n", conn.RemoteAddr())
+				return
+			}
+
+			writer.Write(mutation)
+
+			// Mutations are sent in batches while more are queued
+			if len(r.mutations) > 0 {
+				continue
+			}
+
+			if err := writer.Flush(); err != nil {
+				log.Printf("Error sending mutations to replica %s: %v\n", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+func (receiver *Server) addReplica(storage ReplicableStorage) *replica {
+	state := receiver.replication
+
+	state.listenerOnce.Do(func() {
+		storage.AddMutationListener(receiver.sendToReplicas)
+	})
+
+	r := &replica{mutations: make(chan []byte, replicaBufferSize)}
+
+	state.replicasMutex.Lock()
+	defer state.replicasMutex.Unlock()
+
+	state.replicas[r] = struct{}{}
+
+	return r
+}
+
+func (receiver *Server) removeReplica(r *replica) {
+	state := receiver.replication
+
+	state.replicasMutex.Lock()
+	defer state.replicasMutex.Unlock()
+
+	delete(state.replicas, r)
+}
+
+// sendToReplicas queues the mutation to be sent to every replica. Used as the mutation listener of the cache
+func (receiver *Server) sendToReplicas(mutation cache.Mutation) {
+	state := receiver.replication
+
+	state.replicasMutex.Lock()
+	defer state.replicasMutex.Unlock()
+
+	if len(state.replicas) == 0 {
+		return
+	}
+
+	var record bytes.Buffer
+	cache.WriteMutation(&record, mutation)
+
+	for r := range state.replicas {
+		select {
+		case r.mutations <- record.Bytes():
+		default:
+			// The listener can't block, since it's called while the cache is locked
+			delete(state.replicas, r)
+			close(r.mutations)
+		}
+	}
+}
+
+// isWriteCommand returns whether the text protocol command modifies the cache
+func isWriteCommand(command utils.Command) bool {
+	switch command.Name {
+	case "set", "add", "replace", "append", "prepend", "cas", "incr", "decr", "delete", "touch", "gat", "gats", "flush_all":
+		return true
+	case "ms", "md", "ma":
+		return true
+	case "mg":
+		// Touching or vivifying the item modifies the cache
+		return command.HasMetaFlag('T') || command.HasMetaFlag('N')
+	}
+
+	return false
+}
+
+// isBinaryWrite returns whether the binary protocol request modifies the cache
+func isBinaryWrite(opcode byte) bool {
+	switch opcode {
+	case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ, opAppend, opAppendQ, opPrepend, opPrependQ, opDelete,
+		opDeleteQ, opIncrement, opIncrementQ, opDecrement, opDecrementQ:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"memcached-server/cache"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplication(t *testing.T) {
+	primary, primaryListener, _ := startReplicationTestServer(t)
+	replica, _, _ := startReplicationTestServer(t)
+
+	primary.cache.Set("before", testData("1"))
+	replica.cache.Set("stale", testData("2"))
+
+	replica.ReplicaOf(primaryListener.Addr().String())
+
+	// The replica starts with a copy of the primary
+	waitForValue(t, replica, "before", "1")
+	waitUntil(t, func() bool { return replica.cache.Stats().CurrItems == 1 })
+
+	// And receives every later mutation
+	client := dialTestServer(t, primaryListener)
+
+	client.conn.Write([]byte("set after 5 0 5\r\nhello\r\nappend after 0 0 1\r\n!\r\ndelete before\r\n"))
+	assertTextResponse(t, client, "STORED\r\n", "STORED\r\n", "DELETED\r\n")
+
+	waitForValue(t, replica, "after", "hello!")
+	waitUntil(t, func() bool { return !hasKey(replica, "before") })
+
+	if data, _ := replica.cache.Get("after"); data.Flags != 5 {
+		t.Fatalf("Unexpected flags. Expected 5, got %d\n", data.Flags)
+	}
+
+	client.conn.Write([]byte("flush_all\r\n"))
+	assertTextResponse(t, client, "OK\r\n")
+
+	waitUntil(t, func() bool { return !hasKey(replica, "after") })
+}
+
+func TestReplicaReceivesPendingFlush(t *testing.T) {
+	primary, primaryListener, _ := startReplicationTestServer(t)
+	replica, _, _ := startReplicationTestServer(t)
+
+	primary.cache.Set("key1", testData("1"))
+	primary.cache.Flush(time.Hour)
+
+	replica.ReplicaOf(primaryListener.Addr().String())
+	waitForValue(t, replica, "key1", "1")
+
+	// The items of the snapshot are invalidated on the replica at the same time as on the primary
+	waitUntil(t, func() bool {
+		_, pending := replica.cache.(*cache.Cache).PendingFlush()
+		return pending
+	})
+
+	expected, _ := primary.cache.(*cache.Cache).PendingFlush()
+
+	if flushAt, _ := replica.cache.(*cache.Cache).PendingFlush(); flushAt.Sub(expected).Abs() > time.Second {
+		t.Fatalf("Unexpected flush time on the replica. Expected %v, got %v\n", expected, flushAt)
+	}
+}
+
+func TestReplicaRejectsWrites(t *testing.T) {
+	primary, primaryListener, _ := startReplicationTestServer(t)
+	replica, replicaListener, _ := startReplicationTestServer(t)
+
+	primary.cache.Set("key1", testData("hello"))
+	replica.ReplicaOf(primaryListener.Addr().String())
+	waitForValue(t, replica, "key1", "hello")
+
+	client := dialTestServer(t, replicaListener)
+
+	for _, command := range []string{"set key1 0 0 1\r\nx\r\n", "delete key1 noreply\r\n", "incr key1 1\r\n", "touch key1 10\r\n", "flush_all\r\n"} {
+		client.conn.Write([]byte(command))
+		assertTextResponse(t, client, "SERVER_ERROR read-only replica\r\n")
+	}
+
+	// Reads are served
+	client.conn.Write([]byte("get key1\r\n"))
+	assertTextResponse(t, client, "VALUE key1 0 5\r\n", "hello\r\n", "END\r\n")
+
+	binaryClient := dialTestServer(t, replicaListener)
+
+	sendBinaryRequest(t, binaryClient, opSet, storeExtras(0, 0), "key1", "x")
+	assertBinaryStatus(t, readBinaryResponse(t, binaryClient), opSet, statusNotSupported)
+
+	sendBinaryRequest(t, binaryClient, opGet, nil, "key1", "")
+	assertBinaryStatus(t, readBinaryResponse(t, binaryClient), opGet, statusNoError)
+}
+
+func TestReplicaOfCommand(t *testing.T) {
+	primary, primaryListener, _ := startReplicationTestServer(t)
+	replica, replicaListener, _ := startReplicationTestServer(t)
+	client := dialTestServer(t, replicaListener)
+	host, port, _ := net.SplitHostPort(primaryListener.Addr().String())
+
+	primary.cache.Set("key1", testData("1"))
+
+	client.conn.Write([]byte(fmt.Sprintf("replicaof %s %s\r\n", host, port)))
+	assertTextResponse(t, client, "OK\r\n")
+	waitForValue(t, replica, "key1", "1")
+
+	if replica.PrimaryAddress() != primaryListener.Addr().String() {
+		t.Fatalf("Unexpected primary address: '%s'\n", replica.PrimaryAddress())
+	}
+
+	client.conn.Write([]byte("replicaof no one\r\n"))
+	assertTextResponse(t, client, "OK\r\n")
+
+	// The data is kept, writes are accepted, and the primary is no longer replicated
+	client.conn.Write([]byte("set key2 0 0 1\r\n2\r\n"))
+	assertTextResponse(t, client, "STORED\r\n")
+
+	primary.cache.Set("key3", testData("3"))
+	time.Sleep(50 * time.Millisecond)
+
+	if !hasKey(replica, "key1") || hasKey(replica, "key3") {
+		t.Fatalf("Unexpected replica contents after it stopped replicating\n")
+	}
+
+	if replica.PrimaryAddress() != "" {
+		t.Fatalf("Unexpected primary address: '%s'\n", replica.PrimaryAddress())
+	}
+}
+
+func TestReplicaReconnects(t *testing.T) {
+	primary, primaryListener, _ := startReplicationTestServer(t)
+	replica, _, _ := startReplicationTestServer(t)
+
+	primary.cache.Set("key1", testData("1"))
+	replica.ReplicaOf(primaryListener.Addr().String())
+	waitForValue(t, replica, "key1", "1")
+
+	// Dropping the connection to the replica, e.g., because it fell behind
+	primary.closeConnections(true)
+	primary.cache.Set("key2", testData("2"))
+
+	waitForValue(t, replica, "key2", "2")
+}
+
+func TestStopReplicatingWhileConnecting(t *testing.T) {
+	replica, _, _ := startReplicationTestServer(t)
+
+	// Reserved for documentation (RFC 5737), so connecting never succeeds. Usually it doesn't fail right away either
+	replica.ReplicaOf("192.0.2.1:11211")
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	replica.ReplicaOf("")
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected replication to stop right away. Took %v\n", elapsed)
+	}
+}
+
+func startReplicationTestServer(t *testing.T) (*Server, net.Listener, chan error) {
+	server, listener, serveErr := startTestServer(t)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	})
+
+	return server, listener, serveErr
+}
+
+// waitForValue waits until the key has the expected value in the cache of the server
+func waitForValue(t *testing.T, server *Server, key string, expected string) {
+	waitUntil(t, func() bool {
+		data, err := server.cache.Peek(key)
+
+		return err == nil && string(data.Value) == expected
+	})
+}
+
+func hasKey(server *Server, key string) bool {
+	_, err := server.cache.Peek(key)
+
+	return err == nil
+}
+
+func testData(value string) cache.Data {
+	return cache.Data{Value: []byte(value), ByteCount: len(value)}
+}
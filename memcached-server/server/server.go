@@ -2,6 +2,8 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,24 +11,101 @@ import (
 	"memcached-server/cache"
 	"memcached-server/utils"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Largest data block accepted by storage commands
+const maxDataBlockSize = utils.MaxItemSize
+
+// How often Shutdown checks whether the connections became idle
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Longest command line accepted, including "\r\n". Enough for retrieval commands with 32 keys of the maximum length
+const maxLineLength = 8 * 1024
+
+var badDataChunkError = errors.New("bad data chunk")
+var dataBlockTooLargeError = errors.New("data block too large")
+
+// ErrServerClosed returned by Run and Serve after Shutdown is called
+var ErrServerClosed = errors.New("server closed")
+
 type Server struct {
-	cache *cache.Cache
+	cache     Storage
+	stats     *serverStats
+	startTime time.Time
+	// Port the server is listening on. Only used for stats
+	port int
+	// Listeners and connections are tracked so they can be closed by Shutdown
+	listeners   map[net.Listener]struct{}
+	connections map[*connection]struct{}
+	// Guards listeners and connections
+	mutex        *sync.Mutex
+	shuttingDown *atomic.Bool
+	replication  *replication
+}
+
+// connection client connection tracked by the server
+type connection struct {
+	net.Conn
+	// Whether a command is being processed. Idle connections can be closed right away on shutdown
+	busy atomic.Bool
+	// Replies are buffered until every command received so far is processed, so the replies to pipelined commands
+	// are sent together
+	writer *bufio.Writer
 }
 
-func New(cache *cache.Cache) *Server {
-	// Ideally, the type for the cache should be an interface instead of a concrete type to allow flexibility of using different implementations.
-	// However, this is fine for the purpose of this project
+// flushingReader reads from the connection, but sends the buffered replies first. Reading only blocks once the
+// commands received so far are processed, so clients never wait for replies that are still buffered
+type flushingReader struct {
+	conn *connection
+}
+
+func (receiver flushingReader) Read(p []byte) (int, error) {
+	if err := receiver.conn.writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	return receiver.conn.Read(p)
+}
+
+// finishCommand sends the buffered replies and marks the connection as idle once there are no more commands to
+// process. Commands that were already received are processed first, so the connection isn't idle until then
+func (receiver *connection) finishCommand(reader *bufio.Reader) error {
+	if reader.Buffered() > 0 {
+		return nil
+	}
+
+	if err := receiver.writer.Flush(); err != nil {
+		return err
+	}
+
+	receiver.busy.Store(false)
+
+	return nil
+}
+
+// New creates a server that keeps items in the storage, usually a cache.Cache
+func New(storage Storage) *Server {
 	return &Server{
-		cache: cache,
+		cache:        storage,
+		stats:        &serverStats{},
+		startTime:    time.Now(),
+		listeners:    make(map[net.Listener]struct{}),
+		connections:  make(map[*connection]struct{}),
+		mutex:        &sync.Mutex{},
+		shuttingDown: &atomic.Bool{},
+		replication:  newReplication(),
 	}
 }
 
-// Run Runs server. This is a blocking call and will not return until the server is stopped
+// Run Runs server. This is a blocking call and will not return until the server is stopped. Returns ErrServerClosed
+// after Shutdown is called
 func (receiver *Server) Run(portNumber int) error {
+	receiver.port = portNumber
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", portNumber))
 
 	if err != nil {
@@ -35,26 +114,75 @@ func (receiver *Server) Run(portNumber int) error {
 
 	log.Printf("Server listening on port %d\n", portNumber)
 
+	return receiver.Serve(listener)
+}
+
+// Serve accepts connections on the listener until Shutdown is called. The listener is closed when Serve returns.
+// Returns ErrServerClosed after Shutdown is called
+func (receiver *Server) Serve(listener net.Listener) error {
 	defer func() {
 		closeErr := listener.Close()
-		if closeErr != nil {
-			log.Println("Error closing listener", err)
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			log.Println("Error closing listener", closeErr)
 			return
 		}
 
 		log.Println("Listener closed")
 	}()
 
+	if !receiver.trackListener(listener) {
+		return ErrServerClosed
+	}
+
+	defer receiver.untrackListener(listener)
+
 	receiver.handleConnections(listener)
 
+	return ErrServerClosed
+}
+
+// Shutdown stops the server gracefully. It stops accepting connections, closes idle connections, and waits for the
+// commands in progress to finish before closing the rest of the connections. If ctx is done first, the remaining
+// connections are closed right away and ctx's error is returned. The cache isn't closed
+func (receiver *Server) Shutdown(ctx context.Context) error {
+	receiver.shuttingDown.Store(true)
+
+	receiver.replication.roleMutex.Lock()
+	receiver.stopReplicating()
+	receiver.replication.roleMutex.Unlock()
+
+	receiver.mutex.Lock()
+	for listener := range receiver.listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Println("Error closing listener", err)
+		}
+	}
+	receiver.mutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !receiver.closeConnections(false) {
+		select {
+		case <-ctx.Done():
+			receiver.closeConnections(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
 	return nil
 }
 
-// handleConnections Handles incoming connections
+// handleConnections Handles incoming connections until the listener is closed
 func (receiver *Server) handleConnections(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if receiver.shuttingDown.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			log.Println("Error accepting connection", err)
 			continue
 		}
@@ -65,10 +193,88 @@ func (receiver *Server) handleConnections(listener net.Listener) {
 	}
 }
 
-func (receiver *Server) handleConnection(conn net.Conn) {
+// trackListener returns false if the server is shutting down, in which case the listener shouldn't be used
+func (receiver *Server) trackListener(listener net.Listener) bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.shuttingDown.Load() {
+		return false
+	}
+
+	receiver.listeners[listener] = struct{}{}
+
+	return true
+}
+
+func (receiver *Server) untrackListener(listener net.Listener) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	delete(receiver.listeners, listener)
+}
+
+// trackConnection returns false if the server is shutting down, in which case the connection should be closed
+func (receiver *Server) trackConnection(conn *connection) bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.shuttingDown.Load() {
+		return false
+	}
+
+	receiver.connections[conn] = struct{}{}
+
+	return true
+}
+
+func (receiver *Server) untrackConnection(conn *connection) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	delete(receiver.connections, conn)
+}
+
+// closeConnections closes idle connections, or all of them if force is true. Returns whether there are no connections
+// left. Busy connections are closed by their handler once the command in progress is done
+func (receiver *Server) closeConnections(force bool) bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	for conn := range receiver.connections {
+		if force || !conn.busy.Load() {
+			// The handler stops once reading from the connection fails, and then stops tracking it
+			if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Println("Error closing connection: ", err)
+			}
+		}
+	}
+
+	return len(receiver.connections) == 0
+}
+
+func (receiver *Server) handleConnection(netConn net.Conn) {
+	conn := &connection{Conn: netConn, writer: bufio.NewWriter(netConn)}
+
+	if !receiver.trackConnection(conn) {
+		netConn.Close()
+		return
+	}
+
+	receiver.stats.currConnections.Add(1)
+	receiver.stats.totalConnections.Add(1)
+
 	defer func() {
+		receiver.untrackConnection(conn)
+		receiver.stats.currConnections.Add(-1)
+
+		// E.g., the reply to the last command if the server is shutting down
+		if flushErr := conn.writer.Flush(); flushErr != nil && !errors.Is(flushErr, net.ErrClosed) {
+			log.Println("Error sending message: ", flushErr)
+		}
+
 		closeErr := conn.Close()
-		if closeErr != nil {
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
 			log.Println("Error closing connection: ", closeErr)
 			return
 		}
@@ -76,68 +282,177 @@ func (receiver *Server) handleConnection(conn net.Conn) {
 		log.Printf("Successfully closed connection")
 	}()
 
-	reader := bufio.NewReader(conn)
+	// Lines that don't fit in the buffer are too long (see maxLineLength)
+	reader := bufio.NewReaderSize(flushingReader{conn: conn}, maxLineLength)
+
+	// The protocol is determined by the first byte sent on the connection. Binary protocol requests always start with
+	// the request magic byte, which can't be the first character of a text command
+	firstByte, peekErr := reader.Peek(1)
+
+	if peekErr != nil {
+		if !errors.Is(peekErr, io.EOF) {
+			log.Println("Error reading from connection: ", peekErr)
+		}
+		return
+	}
+
+	if firstByte[0] == binaryRequestMagic {
+		receiver.handleBinaryConnection(conn, reader)
+		return
+	}
+
+	receiver.handleTextConnection(conn, reader)
+}
+
+// handleTextConnection serves text protocol commands until the connection is closed or the server shuts down
+func (receiver *Server) handleTextConnection(conn *connection, reader *bufio.Reader) {
+	// Reused for every command of the connection, so parsing doesn't allocate
+	command := &utils.Command{}
 
 	for {
-		message, readErr := reader.ReadString('\n')
-		message = strings.TrimSpace(message)
+		// The previous command is done, so the connection can be closed if the server is shutting down
+		if err := conn.finishCommand(reader); err != nil {
+			log.Println("Error sending message: ", err)
+			return
+		}
 
-		if readErr != nil {
+		if receiver.shuttingDown.Load() {
+			return
+		}
 
-			if errors.Is(readErr, io.EOF) {
-				break
-			} else {
+		line, readErr := reader.ReadSlice('\n')
+
+		if errors.Is(readErr, bufio.ErrBufferFull) {
+			// Same as memcached, the connection is closed, since the rest of the line can't be told apart from the
+			// next commands
+			sendMessage("CLIENT_ERROR line too long\r\n", conn.writer)
+			return
+		}
+
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) && !errors.Is(readErr, net.ErrClosed) {
 				log.Println("Error reading from connection: ", readErr)
 			}
-			continue
+			return
 		}
 
+		conn.busy.Store(true)
+		message := strings.TrimSpace(string(line))
+
 		log.Printf("Message received: '%s'\n", message)
 
-		command, parseCommandErr := utils.ParseCommand(message)
+		if parseCommandErr := command.Parse(message); parseCommandErr != nil {
+			clientError := &utils.ClientError{}
+
+			if errors.As(parseCommandErr, &clientError) && clientError.DataBlockLength > 0 {
+				if _, err := reader.Discard(clientError.DataBlockLength); err != nil {
+					return
+				}
+			}
 
-		if parseCommandErr != nil {
-			sendMessage(fmt.Sprint("Unexpected error parsing the command: ", parseCommandErr, "\r\n"), conn)
+			sendMessage(parseErrorReply(parseCommandErr)+"\r\n", conn.writer)
 			continue
 		}
 
-		var data string
+		// The connection is used to stream the cache to a replica from now on
+		if command.Name == "sync" {
+			if err := conn.writer.Flush(); err != nil {
+				log.Println("Error sending message: ", err)
+				return
+			}
+
+			receiver.serveReplica(conn)
+			return
+		}
+
+		var data []byte
 
-		if command.Name != "get" {
+		if expectsDataBlock(*command) {
 			var dataFetchErr error
-			// NOTE: there's no validation to check that the data size matches value of `byte count` in the command
-			data, dataFetchErr = reader.ReadString('\n')
+			data, dataFetchErr = readDataBlock(reader, command.ByteCount)
 
-			if dataFetchErr != nil {
-				log.Println("Error reading data: ", dataFetchErr)
+			if errors.Is(dataFetchErr, badDataChunkError) {
+				sendReply(*command, "CLIENT_ERROR bad data chunk", conn.writer)
 				continue
 			}
 
-			// Remove delimiter characters
-			data = data[0 : len(data)-2]
+			if errors.Is(dataFetchErr, dataBlockTooLargeError) {
+				sendReply(*command, "SERVER_ERROR object too large for cache", conn.writer)
+				continue
+			}
+
+			// The connection is broken or closed, so there's no way to read the next command
+			if dataFetchErr != nil {
+				if !errors.Is(dataFetchErr, io.EOF) && !errors.Is(dataFetchErr, net.ErrClosed) {
+					log.Println("Error reading data: ", dataFetchErr)
+				}
+				return
+			}
 		}
 
 		result, processCommandErr := receiver.processCommand(*command, data)
 
 		if processCommandErr != nil {
-			sendMessage(fmt.Sprint("Error processing command: ", processCommandErr, "\r\n"), conn)
-			continue
+			result = fmt.Sprint("Error processing command: ", processCommandErr)
 		}
 
-		_, writeErr := conn.Write([]byte(result + "\r\n"))
-		if writeErr != nil {
-			log.Println("Error sending message: ", writeErr)
-			continue
+		if err := sendReply(*command, result, conn.writer); err != nil {
+			return
 		}
 	}
 }
 
+// parseErrorReply returns the reply to a command line that couldn't be parsed, same as memcached: `ERROR` for
+// commands that don't exist or have the wrong number of arguments, and `CLIENT_ERROR <message>` for malformed ones
+func parseErrorReply(err error) string {
+	clientError := &utils.ClientError{}
+	if errors.As(err, &clientError) {
+		return "CLIENT_ERROR " + clientError.Message
+	}
+
+	return "ERROR"
+}
+
+// sendReply writes the reply to the command followed by "\r\n", unless it should be omitted (see shouldSendReply)
+func sendReply(command utils.Command, reply string, writer io.Writer) error {
+	if !shouldSendReply(command, reply) {
+		return nil
+	}
+
+	_, writeErr := io.WriteString(writer, reply+"\r\n")
+	if writeErr != nil {
+		log.Println("Error sending message: ", writeErr)
+	}
+
+	return writeErr
+}
+
 // processCommand returns status for command. If an error occurs, it returns the status as an empty string
-func (receiver *Server) processCommand(command utils.Command, value string) (string, error) {
+func (receiver *Server) processCommand(command utils.Command, value []byte) (string, error) {
+	result, err := receiver.executeCommand(command, value)
+
+	itemTooLargeError := &cache.ItemTooLargeError{}
+	if errors.As(err, &itemTooLargeError) {
+		return "SERVER_ERROR object too large for cache", nil
+	}
+
+	return result, err
+}
+
+func (receiver *Server) executeCommand(command utils.Command, value []byte) (string, error) {
+	if receiver.isReadOnly() && isWriteCommand(command) {
+		return readOnlyReplicaReply, nil
+	}
+
+	switch command.Name {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		receiver.stats.cmdSet.Add(1)
+	}
+
 	switch command.Name {
 	case "set":
 		return receiver.processSet(command, value)
-	case "get":
+	case "get", "gets", "gat", "gats":
 		return receiver.processGet(command)
 	case "add":
 		return receiver.processAdd(command, value)
@@ -147,17 +462,37 @@ func (receiver *Server) processCommand(command utils.Command, value string) (str
 		return receiver.processAppend(command, value)
 	case "prepend":
 		return receiver.processPrepend(command, value)
+	case "cas":
+		return receiver.processCas(command, value)
+	case "incr", "decr":
+		return receiver.processIncrDecr(command)
+	case "stats":
+		return receiver.processStats(command)
+	case "delete":
+		return receiver.processDelete(command)
+	case "touch":
+		return receiver.processTouch(command)
+	case "flush_all":
+		return receiver.processFlushAll(command)
+	case "mg", "ms", "md", "ma", "me", "mn":
+		return receiver.processMeta(command, value)
+	case "replicaof":
+		if err := receiver.ReplicaOf(command.Address); err != nil {
+			return "SERVER_ERROR " + err.Error(), nil
+		}
+
+		return "OK", nil
 	}
 
 	return "", fmt.Errorf("unexpected command name '%s'", command.Name)
 }
 
-func (receiver *Server) processSet(command utils.Command, value string) (string, error) {
-	err := receiver.cache.Set(command.Key, cache.Data{
+func (receiver *Server) processSet(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Set(command.Key, cache.Data{
 		Flags:     command.Flags,
 		ByteCount: command.ByteCount,
 		Value:     value,
-		ExpiresAt: getExpireTime(command),
+		ExpiresAt: receiver.getExpireTime(command),
 	})
 	if err != nil {
 		return "", err
@@ -166,27 +501,154 @@ func (receiver *Server) processSet(command utils.Command, value string) (string,
 	return "STORED", nil
 }
 
+// processGet returns a `VALUE` block for each key found followed by `END`. Keys that aren't found are omitted. `gat`
+// and `gats` also update the expiration time of the keys found
 func (receiver *Server) processGet(command utils.Command) (string, error) {
-	data, err := receiver.cache.Get(command.Key)
+	var lines []string
+	touch := command.Name == "gat" || command.Name == "gats"
+
+	for _, key := range command.Keys {
+		receiver.stats.cmdGet.Add(1)
+
+		var data cache.Data
+		var err error
+
+		if touch {
+			data, err = receiver.cache.GetAndTouch(key, receiver.getExpireTime(command))
+			receiver.stats.countTouch(err)
+		} else {
+			data, err = receiver.cache.Get(key)
+		}
+
+		keyNotFoundError := &cache.KeyNotFoundError{}
+		if errors.As(err, &keyNotFoundError) {
+			receiver.stats.getMisses.Add(1)
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		receiver.stats.getHits.Add(1)
+
+		header := fmt.Sprintf("VALUE %s %d %d", key, data.Flags, data.ByteCount)
+
+		if command.Name == "gets" || command.Name == "gats" {
+			header += fmt.Sprintf(" %d", data.CasUnique)
+		}
+
+		lines = append(lines, header, string(data.Value))
+	}
+
+	lines = append(lines, "END")
+
+	return strings.Join(lines, "\r\n"), nil
+}
+
+func (receiver *Server) processDelete(command utils.Command) (string, error) {
+	err := receiver.cache.Delete(command.Key)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		receiver.stats.deleteMisses.Add(1)
+		return "NOT_FOUND", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	receiver.stats.deleteHits.Add(1)
+
+	return "DELETED", nil
+}
+
+func (receiver *Server) processTouch(command utils.Command) (string, error) {
+	err := receiver.cache.Touch(command.Key, receiver.getExpireTime(command))
+	receiver.stats.countTouch(err)
 
 	keyNotFoundError := &cache.KeyNotFoundError{}
 	if errors.As(err, &keyNotFoundError) {
-		return "END", nil
+		return "NOT_FOUND", nil
 	}
 
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("VALUE %s %d %d", data.Value, data.Flags, data.ByteCount), nil
+	return "TOUCHED", nil
 }
 
-func (receiver *Server) processAdd(command utils.Command, value string) (string, error) {
-	err := receiver.cache.Add(command.Key, cache.Data{
+func (receiver *Server) processFlushAll(command utils.Command) (string, error) {
+	receiver.stats.cmdFlush.Add(1)
+	receiver.cache.Flush(time.Duration(command.Delay) * time.Second)
+
+	return "OK", nil
+}
+
+func (receiver *Server) processCas(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Cas(command.Key, cache.Data{
 		Value:     value,
 		Flags:     command.Flags,
 		ByteCount: command.ByteCount,
-		ExpiresAt: getExpireTime(command),
+		ExpiresAt: receiver.getExpireTime(command),
+	}, command.CasUnique)
+
+	receiver.stats.countCas(err)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return "NOT_FOUND", nil
+	}
+
+	casMismatchError := &cache.CasMismatchError{}
+	if errors.As(err, &casMismatchError) {
+		return "EXISTS", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return "STORED", nil
+}
+
+func (receiver *Server) processIncrDecr(command utils.Command) (string, error) {
+	var value uint64
+	var err error
+
+	if command.Name == "incr" {
+		value, _, err = receiver.cache.Increment(command.Key, command.Delta)
+	} else {
+		value, _, err = receiver.cache.Decrement(command.Key, command.Delta)
+	}
+
+	receiver.stats.countIncrDecr(command.Name == "incr", err)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		return "NOT_FOUND", nil
+	}
+
+	nonNumericValueError := &cache.NonNumericValueError{}
+	if errors.As(err, &nonNumericValueError) {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(value, 10), nil
+}
+
+func (receiver *Server) processAdd(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Add(command.Key, cache.Data{
+		Value:     value,
+		Flags:     command.Flags,
+		ByteCount: command.ByteCount,
+		ExpiresAt: receiver.getExpireTime(command),
 	})
 
 	keyExistsError := &cache.KeyAlreadyExistsError{}
@@ -202,12 +664,12 @@ func (receiver *Server) processAdd(command utils.Command, value string) (string,
 	return "STORED", nil
 }
 
-func (receiver *Server) processReplace(command utils.Command, value string) (string, error) {
-	err := receiver.cache.Replace(command.Key, cache.Data{
+func (receiver *Server) processReplace(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Replace(command.Key, cache.Data{
 		Value:     value,
 		Flags:     command.Flags,
 		ByteCount: command.ByteCount,
-		ExpiresAt: getExpireTime(command),
+		ExpiresAt: receiver.getExpireTime(command),
 	})
 
 	keyNotFoundError := &cache.KeyNotFoundError{}
@@ -223,12 +685,12 @@ func (receiver *Server) processReplace(command utils.Command, value string) (str
 	return "STORED", nil
 }
 
-func (receiver *Server) processAppend(command utils.Command, value string) (string, error) {
-	err := receiver.cache.Append(command.Key, cache.Data{
+func (receiver *Server) processAppend(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Append(command.Key, cache.Data{
 		Value:     value,
 		Flags:     command.Flags,
 		ByteCount: command.ByteCount,
-		ExpiresAt: getExpireTime(command),
+		ExpiresAt: receiver.getExpireTime(command),
 	})
 
 	keyNotFoundError := &cache.KeyNotFoundError{}
@@ -244,12 +706,12 @@ func (receiver *Server) processAppend(command utils.Command, value string) (stri
 	return "STORED", nil
 }
 
-func (receiver *Server) processPrepend(command utils.Command, value string) (string, error) {
-	err := receiver.cache.Prepend(command.Key, cache.Data{
+func (receiver *Server) processPrepend(command utils.Command, value []byte) (string, error) {
+	_, err := receiver.cache.Prepend(command.Key, cache.Data{
 		Value:     value,
 		Flags:     command.Flags,
 		ByteCount: command.ByteCount,
-		ExpiresAt: getExpireTime(command),
+		ExpiresAt: receiver.getExpireTime(command),
 	})
 
 	keyNotFoundError := &cache.KeyNotFoundError{}
@@ -265,6 +727,61 @@ func (receiver *Server) processPrepend(command utils.Command, value string) (str
 	return "STORED", nil
 }
 
+// readDataBlock reads a data block of exactly byteCount bytes followed by "\r\n". Returns badDataChunkError if the
+// block isn't terminated where expected, and dataBlockTooLargeError if byteCount is over maxDataBlockSize. In both cases
+// the rest of the block is discarded, so the next command can be read
+func readDataBlock(reader *bufio.Reader, byteCount int) ([]byte, error) {
+	if byteCount > maxDataBlockSize {
+		if _, err := reader.Discard(byteCount + 2); err != nil {
+			return nil, err
+		}
+
+		return nil, dataBlockTooLargeError
+	}
+
+	block := make([]byte, byteCount+2)
+
+	if _, err := io.ReadFull(reader, block); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(block, []byte("\r\n")) {
+		// The block is longer than byteCount. Skip the rest of the line so it isn't parsed as a command
+		if block[len(block)-1] != '\n' {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, badDataChunkError
+	}
+
+	return block[:byteCount], nil
+}
+
+// expectsDataBlock returns whether the command line is followed by a data block
+func expectsDataBlock(command utils.Command) bool {
+	switch command.Name {
+	case "get", "gets", "gat", "gats", "incr", "decr", "stats", "delete", "touch", "flush_all", "sync", "replicaof":
+		return false
+	case "mg", "md", "ma", "me", "mn":
+		return false
+	}
+
+	return true
+}
+
+// shouldSendReply returns whether the result of the command should be sent to the client
+func shouldSendReply(command utils.Command, reply string) bool {
+	if isMetaCommand(command.Name) && command.Noreply {
+		return !isQuietMetaReply(command.Name, reply)
+	}
+
+	// Same as memcached, server errors are sent even if the client asked for no reply, since the client can't tell
+	// whether the command was processed otherwise
+	return !command.Noreply || strings.HasPrefix(reply, "SERVER_ERROR")
+}
+
 func sendMessage(message string, writer io.Writer) error {
 	log.Printf("Sending mesessage: '%s'\n", message)
 	_, err := writer.Write([]byte(message))
@@ -278,11 +795,6 @@ func sendMessage(message string, writer io.Writer) error {
 	return err
 }
 
-func getExpireTime(command utils.Command) time.Time {
-	// Never expire
-	if command.ExpiresIn == 0 {
-		return time.UnixMicro(0)
-	}
-
-	return time.Now().Add(time.Second * time.Duration(command.ExpiresIn))
+func (receiver *Server) getExpireTime(command utils.Command) time.Time {
+	return receiver.cache.ExpirationTime(command.ExpiresIn)
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"memcached-server/cache"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Run with `go test -bench Pipelined -run ^$ ./server/` to measure the throughput of a client sending batches of
+// `depth` commands over TCP before reading the replies
+
+func BenchmarkPipelinedGets(b *testing.B) {
+	for _, depth := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			client := startBenchmarkServer(b)
+			request := strings.Repeat("get key\r\n", depth)
+
+			writeBenchmarkRequest(b, client, "set key 0 0 5\r\nhello\r\n")
+			readBenchmarkReplies(b, client, 1)
+
+			b.ResetTimer()
+
+			for sent := 0; sent < b.N; sent += depth {
+				writeBenchmarkRequest(b, client, request)
+				// Each reply is `VALUE`, the value, and `END`
+				readBenchmarkReplies(b, client, depth*3)
+			}
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "commands/s")
+		})
+	}
+}
+
+func BenchmarkPipelinedSets(b *testing.B) {
+	for _, depth := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			client := startBenchmarkServer(b)
+			request := strings.Repeat("set key 0 0 5\r\nhello\r\n", depth)
+
+			b.ResetTimer()
+
+			for sent := 0; sent < b.N; sent += depth {
+				writeBenchmarkRequest(b, client, request)
+				readBenchmarkReplies(b, client, depth)
+			}
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "commands/s")
+		})
+	}
+}
+
+// startBenchmarkServer starts a server listening on a random local port and returns a client connected to it. Logs are
+// discarded, since writing them would take longer than serving the commands
+func startBenchmarkServer(b *testing.B) *testClient {
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		b.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	server := New(cache.New(-1))
+	go server.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		b.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	b.Cleanup(func() {
+		conn.Close()
+		server.Shutdown(context.Background())
+		log.SetOutput(logOutput)
+	})
+
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func writeBenchmarkRequest(b *testing.B, client *testClient, request string) {
+	if _, err := io.WriteString(client.conn, request); err != nil {
+		b.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func readBenchmarkReplies(b *testing.B, client *testClient, lines int) {
+	for range lines {
+		if _, err := client.reader.ReadSlice('\n'); err != nil {
+			b.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+}
@@ -1,9 +1,19 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"memcached-server/cache"
 	"memcached-server/utils"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // Basic tests just to verify functionality; not meant to be exhaustive
@@ -17,7 +27,7 @@ func TestProcessSetCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -37,7 +47,7 @@ func TestProcessAddCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -53,7 +63,7 @@ func TestProcessReplaceCommand(t *testing.T) {
 	c := cache.New(-1)
 	server := New(c)
 
-	err := c.Set(key, cache.Data{})
+	_, err := c.Set(key, cache.Data{})
 
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -66,7 +76,7 @@ func TestProcessReplaceCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -86,7 +96,7 @@ func TestProcessReplaceCommand__KeyDoesntExist(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -106,7 +116,7 @@ func TestProcessGetCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(8),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -114,15 +124,59 @@ func TestProcessGetCommand(t *testing.T) {
 
 	result, err := server.processCommand(utils.Command{
 		Name:      "get",
-		Key:       "test_key",
+		Keys:      []string{"test_key"},
 		Noreply:   false,
 		ByteCount: 0,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "")
+	}, nil)
+
+	expected := "VALUE test_key 8 5\r\nhello\r\nEND"
+	if result != expected {
+		t.Errorf("Unexpected result: '%s'. Expected: '%s'\n", result, expected)
+	}
+}
+
+func TestProcessGetCommand_MultipleKeys(t *testing.T) {
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set("key1", cache.Data{Value: []byte("hello"), ByteCount: 5, Flags: uint16(1)})
+	c.Set("key3", cache.Data{Value: []byte("hi"), ByteCount: 2, Flags: uint16(3)})
+
+	result, err := server.processCommand(utils.Command{
+		Name: "get",
+		Keys: []string{"key1", "key2", "key3"},
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	if result != "VALUE hello 8 5" {
-		t.Errorf("Unexpected result: '%s'. Expected: '%s'\n", result, "hello")
+	expected := "VALUE key1 1 5\r\nhello\r\nVALUE key3 3 2\r\nhi\r\nEND"
+	if result != expected {
+		t.Errorf("Unexpected result: '%s'. Expected: '%s'\n", result, expected)
+	}
+}
+
+func TestProcessGetsCommand(t *testing.T) {
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set("key1", cache.Data{Value: []byte("hello"), ByteCount: 5, Flags: uint16(1)})
+
+	result, err := server.processCommand(utils.Command{
+		Name: "gets",
+		Keys: []string{"key1"},
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "VALUE key1 1 5 1\r\nhello\r\nEND"
+	if result != expected {
+		t.Errorf("Unexpected result: '%s'. Expected: '%s'\n", result, expected)
 	}
 }
 
@@ -130,12 +184,12 @@ func TestGetCommandKeyDoesntExist(t *testing.T) {
 	server := New(cache.New(-1))
 	result, err := server.processCommand(utils.Command{
 		Name:      "get",
-		Key:       "test_key",
+		Keys:      []string{"test_key"},
 		Noreply:   false,
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatalf("Unexpected error: %v\n", err)
@@ -151,7 +205,7 @@ func TestProcessAppendCommand(t *testing.T) {
 	c := cache.New(-1)
 	server := New(c)
 
-	err := c.Set(key, cache.Data{})
+	_, err := c.Set(key, cache.Data{})
 
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -164,7 +218,7 @@ func TestProcessAppendCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -176,7 +230,7 @@ func TestProcessAppendCommand(t *testing.T) {
 
 	cachedData, err := c.Get(key)
 
-	if cachedData.Value != "hello" || cachedData.ByteCount != 5 {
+	if string(cachedData.Value) != "hello" || cachedData.ByteCount != 5 {
 		t.Errorf("Invalid data: %v\n", cachedData)
 	}
 }
@@ -190,7 +244,7 @@ func TestProcessAppendCommand__KeyDoesntExist(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -206,7 +260,7 @@ func TestProcessPrependCommand(t *testing.T) {
 	c := cache.New(-1)
 	server := New(c)
 
-	err := c.Set(key, cache.Data{})
+	_, err := c.Set(key, cache.Data{})
 
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -219,7 +273,7 @@ func TestProcessPrependCommand(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -231,7 +285,7 @@ func TestProcessPrependCommand(t *testing.T) {
 
 	cachedData, err := c.Get(key)
 
-	if cachedData.Value != "hello" || cachedData.ByteCount != 5 {
+	if string(cachedData.Value) != "hello" || cachedData.ByteCount != 5 {
 		t.Errorf("Invalid data: %v\n", cachedData)
 	}
 }
@@ -245,7 +299,7 @@ func TestProcessPrependCommand__KeyDoesntExist(t *testing.T) {
 		ByteCount: 5,
 		ExpiresIn: 0,
 		Flags:     uint16(5),
-	}, "hello")
+	}, []byte("hello"))
 
 	if err != nil {
 		t.Fatal(err)
@@ -255,3 +309,756 @@ func TestProcessPrependCommand__KeyDoesntExist(t *testing.T) {
 		t.Errorf("Unexpected result: %s\n", result)
 	}
 }
+
+func TestProcessCasCommand(t *testing.T) {
+	key := "test_key"
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set(key, cache.Data{})
+	cachedData, _ := c.Get(key)
+
+	result, err := server.processCommand(utils.Command{
+		Name:      "cas",
+		Key:       key,
+		ByteCount: 5,
+		CasUnique: cachedData.CasUnique,
+	}, []byte("hello"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "STORED" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessCasCommand__CasMismatch(t *testing.T) {
+	key := "test_key"
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set(key, cache.Data{})
+	cachedData, _ := c.Get(key)
+	c.Set(key, cache.Data{})
+
+	result, err := server.processCommand(utils.Command{
+		Name:      "cas",
+		Key:       key,
+		ByteCount: 5,
+		CasUnique: cachedData.CasUnique,
+	}, []byte("hello"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "EXISTS" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessCasCommand__KeyDoesntExist(t *testing.T) {
+	server := New(cache.New(-1))
+	result, err := server.processCommand(utils.Command{
+		Name:      "cas",
+		Key:       "test_key",
+		ByteCount: 5,
+		CasUnique: 1,
+	}, []byte("hello"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "NOT_FOUND" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessIncrCommand(t *testing.T) {
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set("counter", cache.Data{Value: []byte("10"), ByteCount: 2})
+
+	result, err := server.processCommand(utils.Command{
+		Name:  "incr",
+		Key:   "counter",
+		Delta: 5,
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "15" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessDecrCommand(t *testing.T) {
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set("counter", cache.Data{Value: []byte("10"), ByteCount: 2})
+
+	result, err := server.processCommand(utils.Command{
+		Name:  "decr",
+		Key:   "counter",
+		Delta: 11,
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "0" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessIncrCommand__KeyDoesntExist(t *testing.T) {
+	server := New(cache.New(-1))
+	result, err := server.processCommand(utils.Command{
+		Name:  "incr",
+		Key:   "counter",
+		Delta: 1,
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "NOT_FOUND" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestProcessIncrCommand__NonNumericValue(t *testing.T) {
+	c := cache.New(-1)
+	server := New(c)
+
+	c.Set("counter", cache.Data{Value: []byte("hello"), ByteCount: 5})
+
+	result, err := server.processCommand(utils.Command{
+		Name:  "incr",
+		Key:   "counter",
+		Delta: 1,
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "CLIENT_ERROR cannot increment or decrement non-numeric value" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func TestIncrCommandNoreply(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set counter 0 0 1\r\n1\r\nincr counter 41 noreply\r\nget counter\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE counter 0 2\r\n", "42\r\n", "END\r\n")
+}
+
+func TestDataBlockContainingDelimiters(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 12\r\nhello\r\nworld\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE test 0 12\r\n", "hello\r\n", "world\r\n", "END\r\n")
+}
+
+func TestBinaryDataBlock(t *testing.T) {
+	c := cache.New(-1)
+	client := startTestConnectionWithCache(t, c)
+	value := []byte{0x00, 0xff, '\n', 0x80, '\r'}
+
+	writeTestBytes(t, client, append(append([]byte("set test 0 0 5\r\n"), value...), '\r', '\n'))
+
+	assertTextResponse(t, client, "STORED\r\n")
+
+	data, err := c.Get("test")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if !reflect.DeepEqual(data.Value, value) {
+		t.Fatalf("Unexpected value. Expected %v, got %v\n", value, data.Value)
+	}
+}
+
+func TestBadDataChunk(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 3\r\nhello\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "CLIENT_ERROR bad data chunk\r\n", "END\r\n")
+}
+
+func TestDataBlockTooLarge(t *testing.T) {
+	client := startTestConnection(t)
+	message := fmt.Sprintf("set test 0 0 %d\r\n%s\r\nget test\r\n", maxDataBlockSize+1, strings.Repeat("a", maxDataBlockSize+1))
+
+	writeTestBytes(t, client, []byte(message))
+
+	assertTextResponse(t, client, "SERVER_ERROR object too large for cache\r\n", "END\r\n")
+}
+
+func TestStatsCommand(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\nget test missing\r\nstats\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE test 0 5\r\n", "hello\r\n", "END\r\n")
+
+	stats := readStats(t, client)
+	expected := map[string]string{
+		"curr_connections": "1",
+		"cmd_get":          "2",
+		"cmd_set":          "1",
+		"get_hits":         "1",
+		"get_misses":       "1",
+		"curr_items":       "1",
+		"total_items":      "1",
+	}
+
+	for name, value := range expected {
+		if stats[name] != value {
+			t.Errorf("Unexpected value for %s. Expected '%s', got '%s'\n", name, value, stats[name])
+		}
+	}
+}
+
+func TestStatsSettingsCommand(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithShards(4), cache.WithMemoryLimit(1024)))
+
+	writeTestBytes(t, client, []byte("stats settings\r\n"))
+
+	stats := readStats(t, client)
+
+	if stats["maxbytes"] != "1024" || stats["shards"] != "4" || stats["eviction_policy"] != "lru" {
+		t.Fatalf("Unexpected settings: %v\n", stats)
+	}
+}
+
+func TestStatsSlabsCommand(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithShards(1), cache.WithSlabAllocator(0)))
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\nstats slabs\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n")
+
+	stats := readStats(t, client)
+
+	if stats["active_slabs"] != "1" || stats["1:used_chunks"] != "1" || stats["1:total_pages"] != "1" {
+		t.Fatalf("Unexpected stats: %v\n", stats)
+	}
+
+	if stats["total_malloced"] != "1048576" {
+		t.Fatalf("Unexpected total_malloced: %s\n", stats["total_malloced"])
+	}
+}
+
+func TestStatsItemsCommandWithSlabs(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithSlabAllocator(0)))
+
+	writeTestBytes(t, client, []byte("set small 0 0 5\r\nhello\r\nset large 0 0 200\r\n"+strings.Repeat("a", 200)+"\r\nstats items\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "STORED\r\n")
+
+	stats := readStats(t, client)
+	classes := 0
+
+	for name, value := range stats {
+		if strings.HasSuffix(name, ":number") {
+			classes++
+
+			if value != "1" {
+				t.Fatalf("Unexpected value for %s: %s\n", name, value)
+			}
+		}
+	}
+
+	if classes != 2 {
+		t.Fatalf("Unexpected stats: %v\n", stats)
+	}
+}
+
+func TestStatsUnknownGroup(t *testing.T) {
+	server := New(cache.New(-1))
+
+	result, err := server.processCommand(utils.Command{Name: "stats", Key: "unknown"}, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if result != "ERROR" {
+		t.Fatalf("Unexpected result: '%s'. Expected: 'ERROR'\n", result)
+	}
+}
+
+// readStats reads `STAT <name> <value>` lines until `END`
+func readStats(t *testing.T, client *testClient) map[string]string {
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	stats := map[string]string{}
+
+	for {
+		line, err := client.reader.ReadString('\n')
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line == "END\r\n" {
+			return stats
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 3 || fields[0] != "STAT" {
+			t.Fatalf("Unexpected stat line: '%s'\n", line)
+		}
+
+		stats[fields[1]] = fields[2]
+	}
+}
+
+func TestDeleteCommand(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\ndelete test\r\ndelete test\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "DELETED\r\n", "NOT_FOUND\r\n", "END\r\n")
+}
+
+func TestDeleteCommandNoreply(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\ndelete test noreply\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "END\r\n")
+}
+
+func TestTouchCommand(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	c := cache.New(-1, cache.WithTimeSource(timeSource))
+	client := startTestConnectionWithCache(t, c)
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\ntouch test 100\r\ntouch missing 100\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "TOUCHED\r\n", "NOT_FOUND\r\n")
+
+	data, _ := c.Peek("test")
+
+	if !data.ExpiresAt.Equal(timeSource.Now().Add(time.Second * 100)) {
+		t.Fatalf("Unexpected expiration time: %v\n", data.ExpiresAt)
+	}
+}
+
+func TestGatCommand(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	c := cache.New(-1, cache.WithTimeSource(timeSource))
+	client := startTestConnectionWithCache(t, c)
+
+	writeTestBytes(t, client, []byte("set test 3 0 5\r\nhello\r\ngats 100 test missing\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE test 3 5 1\r\n", "hello\r\n", "END\r\n")
+
+	data, _ := c.Peek("test")
+
+	if !data.ExpiresAt.Equal(timeSource.Now().Add(time.Second * 100)) {
+		t.Fatalf("Unexpected expiration time: %v\n", data.ExpiresAt)
+	}
+}
+
+func TestFlushAllCommand(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set key1 0 0 1\r\na\r\nflush_all\r\nget key1\r\nset key2 0 0 1\r\nb\r\nget key2\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "OK\r\n", "END\r\n", "STORED\r\n", "VALUE key2 0 1\r\n", "b\r\n", "END\r\n")
+}
+
+func TestFlushAllCommand_Delayed(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithTimeSource(timeSource)))
+
+	writeTestBytes(t, client, []byte("set test 0 0 1\r\na\r\nflush_all 1\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "OK\r\n", "VALUE test 0 1\r\n", "a\r\n", "END\r\n")
+
+	timeSource.Advance(time.Second)
+
+	writeTestBytes(t, client, []byte("get test\r\n"))
+
+	assertTextResponse(t, client, "END\r\n")
+}
+
+func TestPipelinedNoreplyCommands(t *testing.T) {
+	c := cache.New(-1)
+	client := startTestConnectionWithCache(t, c)
+	numKeys := 200
+
+	var message strings.Builder
+
+	for i := range numKeys {
+		key := fmt.Sprintf("key%d", i)
+
+		fmt.Fprintf(&message, "set %s 0 0 1 noreply\r\n1\r\n", key)
+		fmt.Fprintf(&message, "add %s 0 0 1 noreply\r\n2\r\n", key)
+		fmt.Fprintf(&message, "replace %s 0 0 1 noreply\r\n3\r\n", key)
+		fmt.Fprintf(&message, "append %s 0 0 1 noreply\r\n4\r\n", key)
+		fmt.Fprintf(&message, "prepend %s 0 0 1 noreply\r\n5\r\n", key)
+		fmt.Fprintf(&message, "cas %s 0 0 1 1 noreply\r\n6\r\n", key)
+		fmt.Fprintf(&message, "incr %s 10 noreply\r\n", key)
+		fmt.Fprintf(&message, "decr %s 1 noreply\r\n", key)
+		fmt.Fprintf(&message, "touch %s 100 noreply\r\n", key)
+
+		if i%2 == 0 {
+			fmt.Fprintf(&message, "delete %s noreply\r\n", key)
+		}
+	}
+
+	message.WriteString("flush_all 100 noreply\r\nget key1\r\n")
+
+	writeTestBytes(t, client, []byte(message.String()))
+
+	assertTextResponse(t, client, "VALUE key1 0 3\r\n", "543\r\n", "END\r\n")
+
+	if c.Size() != numKeys/2 {
+		t.Fatalf("Unexpected cache size. Expected %d, got %d\n", numKeys/2, c.Size())
+	}
+}
+
+func TestPipelinedRepliesSentTogether(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	conn := &countingConn{Conn: serverConn}
+
+	go New(cache.New(-1)).handleConnection(conn)
+	t.Cleanup(func() {
+		clientConn.Close()
+	})
+
+	client := &testClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+
+	writeTestBytes(t, client, []byte("set test 0 0 5\r\nhello\r\nget test\r\ndelete test\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE test 0 5\r\n", "hello\r\n", "END\r\n", "DELETED\r\n", "END\r\n")
+
+	if writes := conn.writes.Load(); writes != 1 {
+		t.Fatalf("Unexpected number of writes: %d\n", writes)
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	client := startTestConnection(t)
+
+	// The connection stops reading once the line is too long, so writing the rest of it blocks
+	go client.conn.Write([]byte("get " + strings.Repeat("a", maxLineLength) + "\r\n"))
+
+	assertTextResponse(t, client, "CLIENT_ERROR line too long\r\n")
+	assertConnectionClosed(t, client)
+}
+
+func TestConnectionClosedDuringDataBlock(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		New(cache.New(-1)).handleConnection(serverConn)
+		close(done)
+	}()
+
+	clientConn.Write([]byte("set test 0 0 5\r\nhel"))
+	clientConn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to be closed")
+	}
+}
+
+// countingConn counts the writes made to the connection
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (receiver *countingConn) Write(p []byte) (int, error) {
+	receiver.writes.Add(1)
+
+	return receiver.Conn.Write(p)
+}
+
+func TestNoreplyClientError(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 0 1 noreply\r\nhello\r\nset test 0 0 1 noreply\r\na\r\nincr test 1 noreply\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "VALUE test 0 1\r\n", "a\r\n", "END\r\n")
+}
+
+func TestNoreplyServerError(t *testing.T) {
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithMemoryLimit(100)))
+
+	writeTestBytes(t, client, []byte("set test 0 0 100 noreply\r\n"+strings.Repeat("a", 100)+"\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "SERVER_ERROR object too large for cache\r\n", "END\r\n")
+}
+
+func TestInvalidCommand(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("getfoo test\r\nget\r\nset test 0 0\r\nGET test\r\n"))
+
+	assertTextResponse(t, client, "ERROR\r\n", "ERROR\r\n", "ERROR\r\n", "ERROR\r\n")
+}
+
+func TestMalformedCommand(t *testing.T) {
+	client := startTestConnection(t)
+	longKey := strings.Repeat("a", 251)
+
+	writeTestBytes(t, client, []byte("get "+longKey+"\r\nset test x 0 5\r\nincr test x\r\ntouch test x\r\nmg test X\r\nget test\r\n"))
+
+	assertTextResponse(
+		t,
+		client,
+		"CLIENT_ERROR bad command line format\r\n",
+		"CLIENT_ERROR bad command line format\r\n",
+		"CLIENT_ERROR invalid numeric delta argument\r\n",
+		"CLIENT_ERROR invalid exptime argument\r\n",
+		"CLIENT_ERROR invalid flag\r\n",
+		"END\r\n",
+	)
+}
+
+func TestNegativeExpirationTime(t *testing.T) {
+	client := startTestConnection(t)
+
+	writeTestBytes(t, client, []byte("set test 0 -1 5\r\nhello\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "END\r\n")
+}
+
+func TestAbsoluteExpirationTime(t *testing.T) {
+	timeSource := newFakeTimeSource()
+	client := startTestConnectionWithCache(t, cache.New(-1, cache.WithTimeSource(timeSource)))
+
+	writeTestBytes(t, client, []byte("set test 0 1700000100 5\r\nhello\r\nget test\r\n"))
+
+	assertTextResponse(t, client, "STORED\r\n", "VALUE test 0 5\r\n", "hello\r\n", "END\r\n")
+
+	timeSource.Advance(time.Second * 101)
+	writeTestBytes(t, client, []byte("get test\r\n"))
+
+	assertTextResponse(t, client, "END\r\n")
+}
+
+func newFakeTimeSource() *utils.FakeTimeSource {
+	return &utils.FakeTimeSource{FixedTime: time.Unix(1_700_000_000, 0)}
+}
+
+// assertTextResponse checks that the next lines sent by the server match the expected lines, including delimiters
+func assertTextResponse(t *testing.T, client *testClient, expectedLines ...string) {
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for _, expected := range expectedLines {
+		line, err := client.reader.ReadString('\n')
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line != expected {
+			t.Errorf("Unexpected response. Expected '%s', got '%s'\n", expected, line)
+		}
+	}
+}
+
+func TestProcessSetCommand__ItemTooLarge(t *testing.T) {
+	server := New(cache.New(-1, cache.WithMemoryLimit(10)))
+	result, err := server.processCommand(utils.Command{
+		Name:      "set",
+		Key:       "test_key",
+		ByteCount: 5,
+	}, []byte("hello"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "SERVER_ERROR object too large for cache" {
+		t.Errorf("Unexpected result: %s\n", result)
+	}
+}
+
+func startTestServer(t *testing.T) (*Server, net.Listener, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := New(cache.New(-1))
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	return server, listener, serveErr
+}
+
+func dialTestServer(t *testing.T, listener net.Listener) *testClient {
+	conn, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func assertConnectionClosed(t *testing.T, client *testClient) {
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, err := client.reader.ReadByte()
+
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("Unexpected error. Expected EOF, got %v\n", err)
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	server, listener, serveErr := startTestServer(t)
+	client := dialTestServer(t, listener)
+
+	client.conn.Write([]byte("get test\r\n"))
+	assertTextResponse(t, client, "END\r\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Unexpected error shutting down: %v\n", err)
+	}
+
+	assertConnectionClosed(t, client)
+
+	if err := <-serveErr; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Unexpected Serve error. Expected ErrServerClosed, got %v\n", err)
+	}
+
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Fatalf("Unexpected connection accepted after shutdown\n")
+	}
+}
+
+func TestShutdownWaitsForCommandInProgress(t *testing.T) {
+	server, listener, _ := startTestServer(t)
+	client := dialTestServer(t, listener)
+
+	// The command is in progress until its data block is received
+	client.conn.Write([]byte("set test 0 0 5\r\n"))
+	waitUntil(t, server.hasBusyConnection)
+
+	shutdownErr := make(chan error, 1)
+
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Unexpected shutdown before the command finished: %v\n", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.conn.Write([]byte("hello\r\n"))
+	assertTextResponse(t, client, "STORED\r\n")
+	assertConnectionClosed(t, client)
+
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Unexpected error shutting down: %v\n", err)
+	}
+
+	data, err := server.cache.Get("test")
+
+	if err != nil || !reflect.DeepEqual(data.Value, []byte("hello")) {
+		t.Fatalf("Unexpected value stored: %v\n", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	server, listener, _ := startTestServer(t)
+	client := dialTestServer(t, listener)
+
+	client.conn.Write([]byte("set test 0 0 5\r\n"))
+	waitUntil(t, server.hasBusyConnection)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error. Expected DeadlineExceeded, got %v\n", err)
+	}
+
+	assertConnectionClosed(t, client)
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	server := New(cache.New(-1))
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error shutting down: %v\n", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Serve(listener); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Unexpected Serve error. Expected ErrServerClosed, got %v\n", err)
+	}
+}
+
+// hasBusyConnection returns whether a command is being processed on any connection
+func (receiver *Server) hasBusyConnection() bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	for conn := range receiver.connections {
+		if conn.busy.Load() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitUntil polls the condition until it's true, failing the test after a second
+func waitUntil(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for condition\n")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
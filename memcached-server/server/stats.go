@@ -0,0 +1,270 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"memcached-server/cache"
+	"memcached-server/utils"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reported by `stats`. There's no `version` command yet
+const version = "1.0.0"
+
+// serverStats counters for the connections and commands handled by the server. Safe for concurrent use
+type serverStats struct {
+	currConnections  atomic.Int64
+	totalConnections atomic.Uint64
+	cmdGet           atomic.Uint64
+	cmdSet           atomic.Uint64
+	getHits          atomic.Uint64
+	getMisses        atomic.Uint64
+	deleteHits       atomic.Uint64
+	deleteMisses     atomic.Uint64
+	incrHits         atomic.Uint64
+	incrMisses       atomic.Uint64
+	decrHits         atomic.Uint64
+	decrMisses       atomic.Uint64
+	casHits          atomic.Uint64
+	casMisses        atomic.Uint64
+	casBadval        atomic.Uint64
+	cmdTouch         atomic.Uint64
+	touchHits        atomic.Uint64
+	touchMisses      atomic.Uint64
+	cmdFlush         atomic.Uint64
+}
+
+// countIncrDecr counts the result of an `incr` or `decr` command. Only a missing key counts as a miss
+func (receiver *serverStats) countIncrDecr(isIncr bool, err error) {
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	miss := errors.As(err, &keyNotFoundError)
+
+	switch {
+	case isIncr && miss:
+		receiver.incrMisses.Add(1)
+	case isIncr:
+		receiver.incrHits.Add(1)
+	case miss:
+		receiver.decrMisses.Add(1)
+	default:
+		receiver.decrHits.Add(1)
+	}
+}
+
+// countCas counts the result of a check-and-set
+func (receiver *serverStats) countCas(err error) {
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	casMismatchError := &cache.CasMismatchError{}
+
+	switch {
+	case errors.As(err, &keyNotFoundError):
+		receiver.casMisses.Add(1)
+	case errors.As(err, &casMismatchError):
+		receiver.casBadval.Add(1)
+	case err == nil:
+		receiver.casHits.Add(1)
+	}
+}
+
+// countTouch counts the result of updating the expiration time of a key
+func (receiver *serverStats) countTouch(err error) {
+	receiver.cmdTouch.Add(1)
+
+	keyNotFoundError := &cache.KeyNotFoundError{}
+	if errors.As(err, &keyNotFoundError) {
+		receiver.touchMisses.Add(1)
+	} else if err == nil {
+		receiver.touchHits.Add(1)
+	}
+}
+
+type stat struct {
+	name  string
+	value any
+}
+
+// processStats returns a `STAT <name> <value>` line for each stat in the group requested, followed by `END`
+func (receiver *Server) processStats(command utils.Command) (string, error) {
+	var stats []stat
+
+	switch command.Key {
+	case "":
+		stats = receiver.generalStats()
+	case "items":
+		stats = receiver.itemStats()
+	case "slabs":
+		stats = receiver.slabStats()
+	case "settings":
+		stats = receiver.settingsStats()
+	default:
+		// Same as memcached, unknown groups are replied the same way as unknown commands
+		return "ERROR", nil
+	}
+
+	lines := make([]string, 0, len(stats)+1)
+
+	for _, s := range stats {
+		lines = append(lines, fmt.Sprintf("STAT %s %v", s.name, s.value))
+	}
+
+	lines = append(lines, "END")
+
+	return strings.Join(lines, "\r\n"), nil
+}
+
+func (receiver *Server) generalStats() []stat {
+	cacheStats := receiver.cache.Stats()
+	now := time.Now()
+
+	return []stat{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(receiver.startTime).Seconds())},
+		{"time", now.Unix()},
+		{"version", version},
+		{"curr_connections", receiver.stats.currConnections.Load()},
+		{"total_connections", receiver.stats.totalConnections.Load()},
+		{"cmd_get", receiver.stats.cmdGet.Load()},
+		{"cmd_set", receiver.stats.cmdSet.Load()},
+		{"cmd_flush", receiver.stats.cmdFlush.Load()},
+		{"cmd_touch", receiver.stats.cmdTouch.Load()},
+		{"get_hits", receiver.stats.getHits.Load()},
+		{"get_misses", receiver.stats.getMisses.Load()},
+		{"delete_misses", receiver.stats.deleteMisses.Load()},
+		{"delete_hits", receiver.stats.deleteHits.Load()},
+		{"incr_misses", receiver.stats.incrMisses.Load()},
+		{"incr_hits", receiver.stats.incrHits.Load()},
+		{"decr_misses", receiver.stats.decrMisses.Load()},
+		{"decr_hits", receiver.stats.decrHits.Load()},
+		{"cas_misses", receiver.stats.casMisses.Load()},
+		{"cas_hits", receiver.stats.casHits.Load()},
+		{"cas_badval", receiver.stats.casBadval.Load()},
+		{"touch_hits", receiver.stats.touchHits.Load()},
+		{"touch_misses", receiver.stats.touchMisses.Load()},
+		{"limit_maxbytes", receiver.memoryLimit()},
+		{"bytes", cacheStats.Bytes},
+		{"curr_items", cacheStats.CurrItems},
+		{"total_items", cacheStats.TotalItems},
+		{"expired_unfetched", cacheStats.ExpiredUnfetched},
+		{"expired_proactively", cacheStats.ExpiredProactively},
+		{"expired_lazily", cacheStats.ExpiredLazily},
+		{"evicted_unfetched", cacheStats.EvictedUnfetched},
+		{"evictions", cacheStats.Evictions},
+	}
+}
+
+// itemStats reports the items of each slab class in use. Without the slab allocator, items aren't grouped by size, so
+// every item is reported as part of slab class 1
+func (receiver *Server) itemStats() []stat {
+	slabs := receiver.slabClassStats()
+
+	if slabs == nil {
+		cacheStats := receiver.cache.Stats()
+
+		return []stat{
+			{"items:1:number", cacheStats.CurrItems},
+			{"items:1:evicted", cacheStats.Evictions},
+			{"items:1:evicted_unfetched", cacheStats.EvictedUnfetched},
+			{"items:1:expired_unfetched", cacheStats.ExpiredUnfetched},
+		}
+	}
+
+	var stats []stat
+
+	for _, class := range slabs {
+		if class.UsedChunks == 0 {
+			continue
+		}
+
+		prefix := fmt.Sprintf("items:%d:", class.ID)
+		stats = append(stats,
+			stat{prefix + "number", class.UsedChunks},
+			stat{prefix + "evicted", class.Evictions},
+			stat{prefix + "evicted_unfetched", class.EvictedUnfetched},
+			stat{prefix + "expired_unfetched", class.ExpiredUnfetched},
+		)
+	}
+
+	return stats
+}
+
+// slabStats reports the pages and chunks of each slab class with pages assigned. Without the slab allocator, only
+// totals are reported
+func (receiver *Server) slabStats() []stat {
+	slabs := receiver.slabClassStats()
+
+	if slabs == nil {
+		return []stat{
+			{"active_slabs", 0},
+			{"total_malloced", receiver.cache.Stats().Bytes},
+		}
+	}
+
+	var stats []stat
+	activeSlabs := 0
+	totalMalloced := int64(0)
+
+	for _, class := range slabs {
+		if class.TotalPages == 0 {
+			continue
+		}
+
+		activeSlabs++
+		totalMalloced += int64(class.ChunkSize * class.ChunksPerPage * class.TotalPages)
+
+		prefix := fmt.Sprintf("%d:", class.ID)
+		stats = append(stats,
+			stat{prefix + "chunk_size", class.ChunkSize},
+			stat{prefix + "chunks_per_page", class.ChunksPerPage},
+			stat{prefix + "total_pages", class.TotalPages},
+			stat{prefix + "total_chunks", class.ChunksPerPage * class.TotalPages},
+			stat{prefix + "used_chunks", class.UsedChunks},
+			stat{prefix + "free_chunks", class.FreeChunks},
+			stat{prefix + "mem_requested", class.RequestedBytes},
+		)
+	}
+
+	return append(stats, stat{"active_slabs", activeSlabs}, stat{"total_malloced", totalMalloced})
+}
+
+func (receiver *Server) settingsStats() []stat {
+	stats := []stat{
+		{"maxbytes", receiver.memoryLimit()},
+		{"tcpport", receiver.port},
+		{"evictions", "on"},
+		{"item_size_max", maxDataBlockSize},
+		{"cas_enabled", "yes"},
+	}
+
+	if c, ok := receiver.cache.(*cache.Cache); ok {
+		stats = append(stats, stat{"eviction_policy", c.EvictionPolicy}, stat{"shards", c.NumShards})
+
+		if c.SlabGrowthFactor > 0 {
+			stats = append(stats, stat{"growth_factor", c.SlabGrowthFactor})
+		}
+	}
+
+	return stats
+}
+
+// memoryLimit returns the memory limit of the storage. Storages other than cache.Cache report 0, since the limit isn't
+// part of Storage
+func (receiver *Server) memoryLimit() int64 {
+	if c, ok := receiver.cache.(*cache.Cache); ok {
+		return c.MemoryLimit
+	}
+
+	return 0
+}
+
+// slabClassStats returns the stats of the slab classes of the storage, or nil if the storage doesn't use the slab
+// allocator
+func (receiver *Server) slabClassStats() []cache.SlabClassStats {
+	if c, ok := receiver.cache.(*cache.Cache); ok {
+		return c.SlabStats()
+	}
+
+	return nil
+}
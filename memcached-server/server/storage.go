@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"memcached-server/cache"
+	"time"
+)
+
+// ErrReplicationNotSupported returned by ReplicaOf if the storage of the server doesn't implement ReplicableStorage
+var ErrReplicationNotSupported = errors.New("replication not supported by the storage")
+
+// Storage backend the server keeps items in. cache.Cache is the default implementation, but any store can be used,
+// e.g., a persistent or tiered one, or a mock in tests.
+//
+// Implementations must be safe for concurrent use, since every connection calls them concurrently. The outcome of the
+// commands is reported with the errors of the cache package (e.g., cache.KeyNotFoundError or cache.CasMismatchError),
+// which the server translates into the replies of the protocol. Commands that store data return the CAS unique value
+// assigned to it, which is sent back to binary protocol clients
+type Storage interface {
+	// Get returns the data stored for the key, or cache.KeyNotFoundError if there's none
+	Get(key string) (cache.Data, error)
+	// Peek same as Get, but the access isn't counted, e.g., for recency or stats. Used to check whether keys exist
+	Peek(key string) (cache.Data, error)
+	// GetAndTouch same as Get, but also updates the expiration time of the data
+	GetAndTouch(key string, expiresAt time.Time) (cache.Data, error)
+	Set(key string, data cache.Data) (uint64, error)
+	// Add stores the data only if the key doesn't exist. Returns cache.KeyAlreadyExistsError otherwise
+	Add(key string, data cache.Data) (uint64, error)
+	// Replace stores the data only if the key exists. Returns cache.KeyNotFoundError otherwise
+	Replace(key string, data cache.Data) (uint64, error)
+	// Cas stores the data only if casUnique matches the one stored. Returns cache.CasMismatchError otherwise
+	Cas(key string, data cache.Data, casUnique uint64) (uint64, error)
+	Append(key string, data cache.Data) (uint64, error)
+	Prepend(key string, data cache.Data) (uint64, error)
+	// Increment returns the new value and its CAS unique value, or cache.NonNumericValueError if the value stored isn't
+	// a number
+	Increment(key string, delta uint64) (uint64, uint64, error)
+	// Decrement same as Increment, but the value is decremented
+	Decrement(key string, delta uint64) (uint64, uint64, error)
+	// Delete returns cache.KeyNotFoundError if the key doesn't exist
+	Delete(key string) error
+	// CompareAndDelete deletes the key only if casUnique matches the one stored. Returns cache.KeyNotFoundError if the
+	// key doesn't exist and cache.CasMismatchError if casUnique doesn't match
+	CompareAndDelete(key string, casUnique uint64) error
+	Touch(key string, expiresAt time.Time) error
+	// Flush invalidates every item stored before the delay elapses
+	Flush(delay time.Duration)
+	// ExpirationTime converts an expiration time sent by a client to the time at which the data expires (see
+	// cache.Cache.ExpirationTime)
+	ExpirationTime(exptime int) time.Time
+	Stats() cache.Stats
+}
+
+// ReplicableStorage storage that supports replication (see Server.ReplicaOf). It's needed by both the primary and the
+// replicas
+type ReplicableStorage interface {
+	Storage
+	// AddMutationListener registers a listener called after every change made to the storage
+	AddMutationListener(listener cache.MutationListener)
+	// Apply makes a change received from a mutation listener
+	Apply(mutation cache.Mutation) error
+	// Clear deletes every item
+	Clear()
+	// PendingFlush returns the time at which a flush that hasn't taken effect yet invalidates the items, if any (see
+	// cache.Cache.PendingFlush)
+	PendingFlush() (time.Time, bool)
+	// WriteSnapshot writes the items in the storage in the format read by ReadSnapshot (see cache.Cache.WriteSnapshot)
+	WriteSnapshot(w io.Writer) (int, error)
+	// ReadSnapshot stores the items of a snapshot written by WriteSnapshot
+	ReadSnapshot(r io.Reader) (int, error)
+}
+
+// MetaStorage storage that supports the meta commands of the protocol (`mg`, `ms`, `md`, `ma`, and `me`). They're
+// replied with a server error otherwise
+type MetaStorage interface {
+	Storage
+	// MetaGet see cache.Cache.MetaGet
+	MetaGet(key string, options cache.MetaGetOptions) (cache.MetaItem, error)
+	// MetaSet see cache.Cache.MetaSet
+	MetaSet(key string, data cache.Data, options cache.MetaSetOptions) (cache.Data, error)
+	// MetaDelete see cache.Cache.MetaDelete
+	MetaDelete(key string, options cache.MetaDeleteOptions) error
+	// MetaArithmetic see cache.Cache.MetaArithmetic
+	MetaArithmetic(key string, options cache.MetaArithmeticOptions) (cache.Data, error)
+	// Inspect returns the item along with its metadata without counting it as an access
+	Inspect(key string) (cache.MetaItem, error)
+	// Now returns the current time of the clock used to decide when items expire, so the remaining time to live and
+	// the time since the last access are reported in the same clock
+	Now() time.Time
+}
+
+// cache.Cache supports every feature of the server
+var (
+	_ ReplicableStorage = (*cache.Cache)(nil)
+	_ MetaStorage       = (*cache.Cache)(nil)
+)
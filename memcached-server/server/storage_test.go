@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"memcached-server/cache"
+	"memcached-server/utils"
+	"testing"
+	"time"
+)
+
+// mockStorage Storage that records the keys it's asked for. Only the methods used by the tests are implemented
+type mockStorage struct {
+	Storage
+	data map[string]cache.Data
+	// Returned by Set
+	setErr error
+	calls  []string
+}
+
+func (receiver *mockStorage) Get(key string) (cache.Data, error) {
+	receiver.calls = append(receiver.calls, "get "+key)
+
+	if data, ok := receiver.data[key]; ok {
+		return data, nil
+	}
+
+	return cache.Data{}, &cache.KeyNotFoundError{Key: key}
+}
+
+func (receiver *mockStorage) Set(key string, data cache.Data) (uint64, error) {
+	receiver.calls = append(receiver.calls, "set "+key)
+
+	return 0, receiver.setErr
+}
+
+func (receiver *mockStorage) ExpirationTime(exptime int) time.Time {
+	return time.UnixMilli(0)
+}
+
+func (receiver *mockStorage) Stats() cache.Stats {
+	return cache.Stats{CurrItems: len(receiver.data)}
+}
+
+func TestCustomStorage(t *testing.T) {
+	storage := &mockStorage{data: map[string]cache.Data{"key1": {Value: []byte("hello"), Flags: 3, ByteCount: 5}}}
+	server := New(storage)
+
+	result, err := server.processCommand(utils.Command{Name: "get", Keys: []string{"key1", "key2"}}, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if expected := "VALUE key1 3 5\r\nhello\r\nEND"; result != expected {
+		t.Fatalf("Unexpected result: '%s'. Expected: '%s'\n", result, expected)
+	}
+
+	if len(storage.calls) != 2 || storage.calls[0] != "get key1" || storage.calls[1] != "get key2" {
+		t.Fatalf("Unexpected calls: %v\n", storage.calls)
+	}
+}
+
+func TestCustomStorage_Error(t *testing.T) {
+	server := New(&mockStorage{setErr: errors.New("disk full")})
+
+	_, err := server.processCommand(utils.Command{Name: "set", Key: "key1", ByteCount: 1}, []byte("1"))
+
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	// Errors of the cache package are translated into replies
+	server = New(&mockStorage{setErr: &cache.ItemTooLargeError{Key: "key1", Size: 1}})
+
+	result, err := server.processCommand(utils.Command{Name: "set", Key: "key1", ByteCount: 1}, []byte("1"))
+
+	if err != nil || result != "SERVER_ERROR object too large for cache" {
+		t.Fatalf("Unexpected result: '%s', %v\n", result, err)
+	}
+}
+
+func TestCustomStorage_Stats(t *testing.T) {
+	client := startTestConnectionWithCache(t, &mockStorage{data: map[string]cache.Data{"key1": {}, "key2": {}}})
+
+	client.conn.Write([]byte("stats\r\n"))
+	stats := readStats(t, client)
+
+	if stats["curr_items"] != "2" || stats["limit_maxbytes"] != "0" {
+		t.Fatalf("Unexpected stats: %v\n", stats)
+	}
+
+	// Settings specific to cache.Cache aren't reported
+	client.conn.Write([]byte("stats settings\r\n"))
+	stats = readStats(t, client)
+
+	if _, ok := stats["eviction_policy"]; ok {
+		t.Fatalf("Unexpected stats: %v\n", stats)
+	}
+}
+
+func TestCustomStorage_ReplicationNotSupported(t *testing.T) {
+	server := New(&mockStorage{})
+
+	if err := server.ReplicaOf("127.0.0.1:11211"); !errors.Is(err, ErrReplicationNotSupported) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if server.isReadOnly() {
+		t.Fatalf("Expected server to accept writes\n")
+	}
+
+	result, err := server.processCommand(utils.Command{Name: "replicaof", Address: "127.0.0.1:11211"}, nil)
+
+	if err != nil || result != "SERVER_ERROR replication not supported by the storage" {
+		t.Fatalf("Unexpected result: '%s', %v\n", result, err)
+	}
+
+	// Stopping replication is always possible
+	if err := server.ReplicaOf(""); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that save a snapshot of the cache while the server is running
+var snapshotSignals = []os.Signal{syscall.SIGUSR1}
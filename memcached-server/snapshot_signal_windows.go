@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// There's no SIGUSR1 on Windows, so snapshots are only saved on shutdown
+var snapshotSignals []os.Signal
@@ -1,9 +1,9 @@
 package utils
 
 import (
+	"encoding/base64"
 	"errors"
-	"fmt"
-	"regexp"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -14,7 +14,12 @@ type Command struct {
 
 	Key string
 
-	// If it is zero, the item never expires. If it's non-zero it is the number of seconds into the future in which the data expires
+	// Keys requested by retrieval commands (`get`, `gets`, `gat`, and `gats`)
+	Keys []string
+
+	// If it is zero, the item never expires. If it's negative, the item is expired immediately. Values up to 30 days
+	// (2592000) are the number of seconds into the future in which the data expires, and larger values are an absolute
+	// Unix timestamp
 	ExpiresIn int
 
 	// an arbitrary 16-bit unsigned integer (written out in decimal) that the server stores along with the data and sends back when the item is retrieved
@@ -25,85 +30,607 @@ type Command struct {
 
 	// the number of bytes is the number of bytes in the data block to follow, not including the delimiting
 	ByteCount int
+
+	// unique value of the data as returned by `gets`. Only used by the `cas` command
+	CasUnique uint64
+
+	// amount by which the value is incremented or decremented. Only used by the `incr` and `decr` commands
+	Delta uint64
+
+	// number of seconds to wait before invalidating the items in the cache. Only used by the `flush_all` command
+	Delay int
+
+	// address of the primary server to replicate, as `host:port`. Empty to stop replicating. Only used by the
+	// `replicaof` command
+	Address string
+
+	// flags of meta commands (`mg`, `ms`, `md`, `ma`, and `me`) in the order they were sent. The key is decoded if the
+	// `b` flag is set, and the `q` flag also sets Noreply
+	MetaFlags []MetaFlag
+}
+
+// MetaFlag flag of a meta command, e.g., `v` or `T30`. Token is empty for flags that don't take one
+type MetaFlag struct {
+	Name  byte
+	Token string
+}
+
+// Flags accepted by each meta command
+var metaCommandFlags = map[string]string{
+	"mg": "bcfhklOqstuvENRT",
+	"ms": "bcCEFIkOqTMN",
+	"md": "bCEIkOqTx",
+	"ma": "bcCEkNJDTMOqtv",
+	"me": "b",
+	"mn": "",
+}
+
+// Meta flags whose token is a number, and the bit size and signedness of the number
+var metaNumericFlags = map[byte]struct {
+	bitSize int
+	signed  bool
+}{
+	'T': {32, true},
+	'N': {32, true},
+	'R': {32, true},
+	'C': {64, false},
+	'E': {64, false},
+	'J': {64, false},
+	'D': {64, false},
+	'F': {16, false},
+}
+
+// Modes accepted by the `M` flag of each meta command
+var metaModes = map[string]string{
+	"ms": "SEAPRseapr",
+	"ma": "I+D-id",
 }
 
+// Longest opaque token accepted by the `O` flag, same as memcached
+const maxOpaqueLength = 32
+
+// MaxKeyLength longest key accepted, in bytes, same as memcached
+const MaxKeyLength = 250
+
+// MaxItemSize largest value accepted, in bytes, same as the default maximum item size of memcached
+const MaxItemSize = 1024 * 1024
+
+// Most arguments taken by commands other than the retrieval and meta commands, which take any number of them
+const maxArguments = 6
+
+// Messages of the client errors, same as memcached
+const (
+	badCommandLineFormat = "bad command line format"
+	invalidDelta         = "invalid numeric delta argument"
+	invalidExptime       = "invalid exptime argument"
+	invalidPort          = "port must be a number between 1 and 65535"
+	invalidMetaFlag      = "invalid flag"
+	invalidMetaToken     = "bad token in command line format"
+	invalidMetaMode      = "invalid mode for ms STORE"
+	invalidBase64Key     = "error decoding key"
+	opaqueTooLong        = "opaque token too long"
+)
+
+// ParseCommand parses a command line of the text protocol, without the trailing "\r\n". Returns an
+// InvalidCommandError if the command doesn't exist or has the wrong number of arguments, and a ClientError if its
+// arguments are malformed
 func ParseCommand(rawCommand string) (*Command, error) {
-	if strings.HasPrefix(rawCommand, "get") {
-		return parseGetCommand(rawCommand)
+	command := &Command{}
+
+	if err := command.Parse(rawCommand); err != nil {
+		return nil, err
+	}
+
+	return command, nil
+}
+
+// Parse is the same as ParseCommand, but the command is parsed into receiver, reusing the memory of its Keys and
+// MetaFlags. Parsing doesn't allocate otherwise, except to decode base64 keys. Keys and tokens are substrings of
+// rawCommand
+func (receiver *Command) Parse(rawCommand string) error {
+	*receiver = Command{Keys: receiver.Keys[:0], MetaFlags: receiver.MetaFlags[:0]}
+	tokens := tokenizer{line: rawCommand}
+	receiver.Name, _ = tokens.next()
+
+	switch receiver.Name {
+	case "get", "gets":
+		return receiver.parseGetCommand(&tokens)
+	case "gat", "gats":
+		return receiver.parseGatCommand(&tokens)
+	case "mg", "ms", "md", "ma", "me", "mn":
+		return receiver.parseMetaCommand(&tokens)
 	}
 
-	re := regexp.MustCompile(
-		fmt.Sprintf(
-			// Handling the possibility of multiple spaces. Although, multiple spaces probably aren't allowed by the
-			// actual Memcached implementation
-			`%s[ ]+%s[ ]+%s[ ]+%s[ ]+%s%s`,
-			buildNamedCaptureGroupRegexp(`\S+`, "name"),
-			buildNamedCaptureGroupRegexp(`\S+`, "key"),
-			buildNamedCaptureGroupRegexp(`\S+`, "flags"),
-			buildNamedCaptureGroupRegexp(`\S+`, "exptime"),
-			buildNamedCaptureGroupRegexp(`\S+`, "byteCount"),
-			buildNamedCaptureGroupRegexp("([ ]+noreply)?", "noReply"),
-		),
-	)
-	matches := re.FindStringSubmatch(rawCommand)
-	namedGroups := map[string]string{}
+	var buffer [maxArguments]string
+	args, ok := tokens.arguments(&buffer)
 
-	for i, match := range matches {
-		if len(re.SubexpNames()[i]) > 0 {
-			namedGroups[re.SubexpNames()[i]] = strings.TrimSpace(match)
+	if !ok {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	switch receiver.Name {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return receiver.parseStorageCommand(args)
+	case "incr", "decr":
+		return receiver.parseIncrDecrCommand(args)
+	case "stats":
+		return receiver.parseStatsCommand(args)
+	case "delete":
+		return receiver.parseDeleteCommand(args)
+	case "touch":
+		return receiver.parseTouchCommand(args)
+	case "flush_all":
+		return receiver.parseFlushAllCommand(args)
+	case "sync":
+		return receiver.parseSyncCommand(args)
+	case "replicaof":
+		return receiver.parseReplicaOfCommand(args)
+	}
+
+	return &InvalidCommandError{Name: receiver.Name}
+}
+
+// parseStorageCommand parses commands with the structure `<name> <key> <flags> <exptime> <bytes> [noreply]`. `cas`
+// also has the CAS unique value of the item after the byte count
+func (receiver *Command) parseStorageCommand(args []string) error {
+	numArgs := 4
+
+	if receiver.Name == "cas" {
+		numArgs = 5
+	}
+
+	if len(args) < numArgs || len(args) > numArgs+1 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	if !isValidKey(args[0]) {
+		return &ClientError{Message: badCommandLineFormat}
+	}
+
+	flags, flagsErr := strconv.ParseUint(args[1], 10, 16)
+	expiresIn, expiresInErr := strconv.ParseInt(args[2], 10, 32)
+	byteCount, byteCountErr := strconv.ParseInt(args[3], 10, 32)
+
+	if flagsErr != nil || expiresInErr != nil || byteCountErr != nil || byteCount < 0 {
+		return &ClientError{Message: badCommandLineFormat}
+	}
+
+	if receiver.Name == "cas" {
+		casUnique, convertErr := strconv.ParseUint(args[4], 10, 64)
+
+		if convertErr != nil {
+			return &ClientError{Message: badCommandLineFormat}
 		}
+
+		receiver.CasUnique = casUnique
+	}
+
+	noReply, err := parseNoreply(args[numArgs:])
+
+	if err != nil {
+		return err
+	}
+
+	receiver.Key = args[0]
+	receiver.Flags = uint16(flags)
+	receiver.ExpiresIn = int(expiresIn)
+	receiver.ByteCount = int(byteCount)
+	receiver.Noreply = noReply
+
+	return nil
+}
+
+// parseGetCommand parses retrieval commands, which have the structure `get|gets <key>+`
+func (receiver *Command) parseGetCommand(tokens *tokenizer) error {
+	return receiver.parseKeys(tokens)
+}
+
+// parseIncrDecrCommand parses commands with the structure `incr|decr <key> <value> [noreply]`
+func (receiver *Command) parseIncrDecrCommand(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	if !isValidKey(args[0]) {
+		return &ClientError{Message: badCommandLineFormat}
 	}
 
-	flags, convertErr := strconv.Atoi(namedGroups["flags"])
+	delta, convertErr := strconv.ParseUint(args[1], 10, 64)
 
 	if convertErr != nil {
-		return nil, errors.New("error parsing command: `flags` must be a number")
+		return &ClientError{Message: invalidDelta}
+	}
+
+	noReply, err := parseNoreply(args[2:])
+
+	if err != nil {
+		return err
+	}
+
+	receiver.Key = args[0]
+	receiver.Delta = delta
+	receiver.Noreply = noReply
+
+	return nil
+}
+
+// parseStatsCommand parses commands with the structure `stats [<group>]`. The group is stored in Key
+func (receiver *Command) parseStatsCommand(args []string) error {
+	if len(args) > 1 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	if len(args) == 1 {
+		receiver.Key = args[0]
+	}
+
+	return nil
+}
+
+// parseDeleteCommand parses commands with the structure `delete <key> [noreply]`
+func (receiver *Command) parseDeleteCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	if !isValidKey(args[0]) {
+		return &ClientError{Message: badCommandLineFormat}
+	}
+
+	noReply, err := parseNoreply(args[1:])
+
+	if err != nil {
+		return err
+	}
+
+	receiver.Key = args[0]
+	receiver.Noreply = noReply
+
+	return nil
+}
+
+// parseTouchCommand parses commands with the structure `touch <key> <exptime> [noreply]`
+func (receiver *Command) parseTouchCommand(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return &InvalidCommandError{Name: receiver.Name}
 	}
 
-	expiresIn, convertErr := strconv.Atoi(namedGroups["exptime"])
+	if !isValidKey(args[0]) {
+		return &ClientError{Message: badCommandLineFormat}
+	}
+
+	expiresIn, convertErr := strconv.ParseInt(args[1], 10, 32)
 
 	if convertErr != nil {
-		return nil, errors.New("error parsing command: `exptime` must be a number")
+		return &ClientError{Message: invalidExptime}
+	}
+
+	noReply, err := parseNoreply(args[2:])
+
+	if err != nil {
+		return err
+	}
+
+	receiver.Key = args[0]
+	receiver.ExpiresIn = int(expiresIn)
+	receiver.Noreply = noReply
+
+	return nil
+}
+
+// parseGatCommand parses commands with the structure `gat|gats <exptime> <key>+`
+func (receiver *Command) parseGatCommand(tokens *tokenizer) error {
+	token, ok := tokens.next()
+
+	if !ok {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	expiresIn, convertErr := strconv.ParseInt(token, 10, 32)
+
+	if err := receiver.parseKeys(tokens); err != nil {
+		return err
 	}
 
-	byteCount, convertErr := strconv.Atoi(namedGroups["byteCount"])
 	if convertErr != nil {
-		return nil, errors.New("error parsing command: `byteCount` must be a number")
+		return &ClientError{Message: invalidExptime}
 	}
 
-	noReply := false
+	receiver.ExpiresIn = int(expiresIn)
 
-	if namedGroups["noReply"] == "noreply" {
-		noReply = true
+	return nil
+}
+
+// parseFlushAllCommand parses commands with the structure `flush_all [delay] [noreply]`
+func (receiver *Command) parseFlushAllCommand(args []string) error {
+	if len(args) > 2 {
+		return &InvalidCommandError{Name: receiver.Name}
 	}
 
-	command := &Command{
-		Name:      namedGroups["name"],
-		Flags:     uint16(flags),
-		Key:       namedGroups["key"],
-		ExpiresIn: expiresIn,
-		Noreply:   noReply,
-		ByteCount: byteCount,
+	if len(args) > 0 && args[0] != "noreply" {
+		delay, convertErr := strconv.ParseInt(args[0], 10, 32)
+
+		if convertErr != nil || delay < 0 {
+			return &ClientError{Message: badCommandLineFormat}
+		}
+
+		receiver.Delay = int(delay)
+		args = args[1:]
 	}
 
-	return command, nil
+	noReply, err := parseNoreply(args)
+
+	if err != nil {
+		return err
+	}
+
+	receiver.Noreply = noReply
+
+	return nil
 }
 
-func parseGetCommand(rawCommand string) (*Command, error) {
-	split := strings.Split(rawCommand, " ")
+// parseSyncCommand parses commands with the structure `sync`, sent by replicas to start replicating
+func (receiver *Command) parseSyncCommand(args []string) error {
+	if len(args) != 0 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	return nil
+}
 
-	if len(split) != 2 {
-		return nil, fmt.Errorf("unexpected command structure for: '%s'", rawCommand)
+// parseReplicaOfCommand parses commands with the structure `replicaof <host> <port>` or `replicaof no one`
+func (receiver *Command) parseReplicaOfCommand(args []string) error {
+	if len(args) != 2 {
+		return &InvalidCommandError{Name: receiver.Name}
 	}
 
-	return &Command{
-		Name:  split[0],
-		Key:   split[1],
-		Flags: uint16(0),
-	}, nil
+	if args[0] == "no" && args[1] == "one" {
+		return nil
+	}
+
+	if port, convertErr := strconv.Atoi(args[1]); convertErr != nil || port < 1 || port > 65535 {
+		return &ClientError{Message: invalidPort}
+	}
+
+	receiver.Address = net.JoinHostPort(args[0], args[1])
+
+	return nil
+}
+
+// parseMetaCommand parses meta commands, which have the structure `<name> <key> <flag>*`. `ms` also has the length of
+// its data block after the key (`ms <key> <datalen> <flag>*`), and `mn` has no arguments
+func (receiver *Command) parseMetaCommand(tokens *tokenizer) error {
+	if receiver.Name == "mn" {
+		if _, ok := tokens.next(); ok {
+			return &InvalidCommandError{Name: receiver.Name}
+		}
+
+		return nil
+	}
+
+	key, ok := tokens.next()
+
+	if !ok {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	if receiver.Name == "ms" {
+		token, ok := tokens.next()
+
+		if !ok {
+			return &InvalidCommandError{Name: receiver.Name}
+		}
+
+		byteCount, convertErr := strconv.ParseInt(token, 10, 32)
+
+		if convertErr != nil || byteCount < 0 {
+			return &ClientError{Message: badCommandLineFormat}
+		}
+
+		receiver.ByteCount = int(byteCount)
+	}
+
+	err := receiver.parseMetaArguments(key, tokens)
+	clientError := &ClientError{}
+
+	// The data block of ms follows the command line even if it's malformed, so the caller has to skip it
+	if receiver.Name == "ms" && errors.As(err, &clientError) {
+		clientError.DataBlockLength = receiver.ByteCount + 2
+	}
+
+	return err
+}
+
+// parseMetaArguments parses the key and flags of a meta command
+func (receiver *Command) parseMetaArguments(key string, tokens *tokenizer) error {
+	base64Key := false
+
+	for token, ok := tokens.next(); ok; token, ok = tokens.next() {
+		flag := MetaFlag{Name: token[0], Token: token[1:]}
+
+		if err := validateMetaFlag(receiver.Name, flag); err != nil {
+			return err
+		}
+
+		switch flag.Name {
+		case 'q':
+			receiver.Noreply = true
+		case 'b':
+			base64Key = true
+		}
+
+		receiver.MetaFlags = append(receiver.MetaFlags, flag)
+	}
+
+	if !base64Key {
+		if !isValidKey(key) {
+			return &ClientError{Message: badCommandLineFormat}
+		}
+
+		receiver.Key = key
+
+		return nil
+	}
+
+	// Base64 encoded keys can contain any bytes once decoded
+	decoded, decodeErr := base64.StdEncoding.DecodeString(key)
+
+	if decodeErr != nil || len(decoded) == 0 || len(decoded) > MaxKeyLength {
+		return &ClientError{Message: invalidBase64Key}
+	}
+
+	receiver.Key = string(decoded)
+
+	return nil
 }
 
-// buildNamedCaptureGroupRegexp wraps expression as a named capture group. E.g., "[a-z]+", "firstName" -> "(?P<firstName>[a-z]+)"
-func buildNamedCaptureGroupRegexp(expression string, name string) string {
-	return fmt.Sprintf("(?P<%s>%s)", name, expression)
+// parseKeys parses the keys left in the command line. At least one key is required
+func (receiver *Command) parseKeys(tokens *tokenizer) error {
+	for key, ok := tokens.next(); ok; key, ok = tokens.next() {
+		receiver.Keys = append(receiver.Keys, key)
+	}
+
+	if len(receiver.Keys) == 0 {
+		return &InvalidCommandError{Name: receiver.Name}
+	}
+
+	for _, key := range receiver.Keys {
+		if !isValidKey(key) {
+			return &ClientError{Message: badCommandLineFormat}
+		}
+	}
+
+	return nil
+}
+
+// validateMetaFlag returns an error if the command doesn't accept the flag or its token is invalid
+func validateMetaFlag(name string, flag MetaFlag) error {
+	if strings.IndexByte(metaCommandFlags[name], flag.Name) < 0 {
+		return &ClientError{Message: invalidMetaFlag}
+	}
+
+	if number, ok := metaNumericFlags[flag.Name]; ok {
+		var convertErr error
+
+		if number.signed {
+			_, convertErr = strconv.ParseInt(flag.Token, 10, number.bitSize)
+		} else {
+			_, convertErr = strconv.ParseUint(flag.Token, 10, number.bitSize)
+		}
+
+		if convertErr != nil {
+			return &ClientError{Message: invalidMetaToken}
+		}
+	}
+
+	switch flag.Name {
+	case 'M':
+		if len(flag.Token) != 1 || strings.IndexByte(metaModes[name], flag.Token[0]) < 0 {
+			return &ClientError{Message: invalidMetaMode}
+		}
+	case 'O':
+		if len(flag.Token) > maxOpaqueLength {
+			return &ClientError{Message: opaqueTooLong}
+		}
+	}
+
+	return nil
+}
+
+// MetaFlag returns the token of the meta flag and whether it was sent
+func (receiver Command) MetaFlag(name byte) (string, bool) {
+	for _, flag := range receiver.MetaFlags {
+		if flag.Name == name {
+			return flag.Token, true
+		}
+	}
+
+	return "", false
+}
+
+// HasMetaFlag returns whether the meta flag was sent
+func (receiver Command) HasMetaFlag(name byte) bool {
+	_, ok := receiver.MetaFlag(name)
+
+	return ok
+}
+
+// parseNoreply parses the optional `noreply` argument at the end of a command. args are the arguments left after
+// parsing the rest of the command
+func parseNoreply(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	if len(args) > 1 || args[0] != "noreply" {
+		return false, &ClientError{Message: badCommandLineFormat}
+	}
+
+	return true, nil
+}
+
+// isValidKey returns whether the key is at most MaxKeyLength bytes long and has no control characters. Keys never
+// have spaces, since they're used to split the command line
+func isValidKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tokenizer splits a command line into the tokens separated by spaces, without allocating. Same as memcached, other
+// whitespace characters are part of the tokens
+type tokenizer struct {
+	line string
+}
+
+// next returns the next token and removes it from the line. Returns false if there are no tokens left
+func (receiver *tokenizer) next() (string, bool) {
+	start := 0
+
+	for start < len(receiver.line) && receiver.line[start] == ' ' {
+		start++
+	}
+
+	if start == len(receiver.line) {
+		receiver.line = ""
+
+		return "", false
+	}
+
+	end := strings.IndexByte(receiver.line[start:], ' ')
+
+	if end < 0 {
+		end = len(receiver.line)
+	} else {
+		end += start
+	}
+
+	token := receiver.line[start:end]
+	receiver.line = receiver.line[end:]
+
+	return token, true
+}
+
+// arguments returns the tokens left, stored in buffer. Returns false if there are more tokens than fit in buffer
+func (receiver *tokenizer) arguments(buffer *[maxArguments]string) ([]string, bool) {
+	count := 0
+
+	for token, ok := receiver.next(); ok; token, ok = receiver.next() {
+		if count == len(buffer) {
+			return nil, false
+		}
+
+		buffer[count] = token
+		count++
+	}
+
+	return buffer[:count], true
 }
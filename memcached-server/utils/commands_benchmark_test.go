@@ -0,0 +1,31 @@
+package utils
+
+import "testing"
+
+// Run with `go test -bench ParseCommand -run ^$ ./utils/`. Parsing into a reused Command shouldn't allocate
+
+var benchmarkCommands = map[string]string{
+	"set":      "set some:key 42 3600 1024 noreply",
+	"cas":      "cas some:key 42 3600 1024 18446744073709551615",
+	"get":      "get key1 key2 key3 key4 key5 key6 key7 key8",
+	"incr":     "incr counter 10",
+	"meta-get": "mg some:key v f t c s O123456 T30",
+	"meta-set": "ms some:key 1024 F42 T3600 MS q",
+}
+
+func BenchmarkParseCommand(b *testing.B) {
+	for _, name := range []string{"set", "cas", "get", "incr", "meta-get", "meta-set"} {
+		b.Run(name, func(b *testing.B) {
+			rawCommand := benchmarkCommands[name]
+			var command Command
+
+			b.ReportAllocs()
+
+			for range b.N {
+				if err := command.Parse(rawCommand); err != nil {
+					b.Fatalf("Unexpected error: %v\n", err)
+				}
+			}
+		})
+	}
+}
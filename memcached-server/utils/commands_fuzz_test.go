@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Run with `go test -fuzz FuzzParseCommand -run ^$ ./utils/` to look for inputs breaking the parser
+
+func FuzzParseCommand(f *testing.F) {
+	for _, rawCommand := range benchmarkCommands {
+		f.Add(rawCommand)
+	}
+
+	for _, rawCommand := range []string{
+		"", "get", "gets a b", "gat 10 a", "touch a -1 noreply", "delete a", "decr a 1", "flush_all 10 noreply",
+		"stats slabs", "sync", "replicaof localhost 11211", "replicaof no one", "mn", "md dGVzdA== b q", "me a",
+		"ma a N0 J10 D5 MI v", "set a 65535 -1 0", "set  a\t 0 0 5", "prepend a 0 0 5 noreply",
+	} {
+		f.Add(rawCommand)
+	}
+
+	f.Fuzz(func(t *testing.T, rawCommand string) {
+		command, err := ParseCommand(rawCommand)
+
+		if err != nil {
+			invalidCommandError := &InvalidCommandError{}
+			clientError := &ClientError{}
+
+			if !errors.As(err, &invalidCommandError) && !errors.As(err, &clientError) {
+				t.Fatalf("Unexpected error type: %T\n", err)
+			}
+
+			return
+		}
+
+		// Key holds the group of `stats` rather than a key
+		isKey := command.Key != "" && command.Name != "stats" && !command.HasMetaFlag('b')
+
+		if isKey && !isValidKey(command.Key) {
+			t.Fatalf("Unexpected key: '%s'\n", command.Key)
+		}
+
+		for _, key := range command.Keys {
+			if !isValidKey(key) {
+				t.Fatalf("Unexpected key: '%s'\n", key)
+			}
+		}
+
+		if command.ByteCount < 0 || command.Delay < 0 {
+			t.Fatalf("Unexpected command: %+v\n", command)
+		}
+
+		// Parsing into a command used for another one gives the same result
+		reused := Command{Keys: []string{"stale"}, MetaFlags: []MetaFlag{{Name: 'v'}}}
+		reused.Parse("mg other v")
+
+		if err := reused.Parse(rawCommand); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+
+		if len(reused.Keys) == 0 {
+			reused.Keys = nil
+		}
+
+		if len(reused.MetaFlags) == 0 {
+			reused.MetaFlags = nil
+		}
+
+		if !reflect.DeepEqual(*command, reused) {
+			t.Fatalf("Unexpected command. Expected %+v, got %+v\n", *command, reused)
+		}
+
+		if strings.Contains(command.Name, " ") {
+			t.Fatalf("Unexpected name: '%s'\n", command.Name)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -36,7 +37,7 @@ func TestParseCommandGet(t *testing.T) {
 
 	expected := &Command{
 		Name:      "get",
-		Key:       "test",
+		Keys:      []string{"test"},
 		Flags:     0,
 		ExpiresIn: 0,
 		ByteCount: 0,
@@ -46,6 +47,46 @@ func TestParseCommandGet(t *testing.T) {
 	assertSame(*expected, *command, t)
 }
 
+func TestParseCommandGetMultipleKeys(t *testing.T) {
+	rawCommand := "get key1 key2 key3"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name: "get",
+		Keys: []string{"key1", "key2", "key3"},
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandGets(t *testing.T) {
+	rawCommand := "gets key1 key2"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name: "gets",
+		Keys: []string{"key1", "key2"},
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandGetNoKeys_Error(t *testing.T) {
+	_, err := ParseCommand("get")
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
 func TestParseCommandNoreplyNotSet(t *testing.T) {
 	rawCommand := "set test 0 100 4"
 	command, err := ParseCommand(rawCommand)
@@ -66,6 +107,224 @@ func TestParseCommandNoreplyNotSet(t *testing.T) {
 	assertSame(*expected, *command, t)
 }
 
+func TestParseCommandCas(t *testing.T) {
+	rawCommand := "cas test 1 100 4 12345 noreply"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name:      "cas",
+		Key:       "test",
+		Flags:     1,
+		ExpiresIn: 100,
+		ByteCount: 4,
+		Noreply:   true,
+		CasUnique: 12345,
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandCasMissingCasUnique_Error(t *testing.T) {
+	rawCommand := "cas test 0 100 4"
+	_, err := ParseCommand(rawCommand)
+
+	// Same as memcached, commands with the wrong number of arguments are invalid rather than malformed
+	invalidCommandError := &InvalidCommandError{}
+	if !errors.As(err, &invalidCommandError) {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestParseCommandIncr(t *testing.T) {
+	rawCommand := "incr counter 15"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name:  "incr",
+		Key:   "counter",
+		Delta: 15,
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandDecrNoreply(t *testing.T) {
+	rawCommand := "decr counter 18446744073709551615 noreply"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name:    "decr",
+		Key:     "counter",
+		Delta:   18446744073709551615,
+		Noreply: true,
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandIncrInvalidDelta_Error(t *testing.T) {
+	rawCommand := "incr counter -1"
+	_, err := ParseCommand(rawCommand)
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: invalid numeric delta argument")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
+func TestParseCommandStats(t *testing.T) {
+	command, err := ParseCommand("stats")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "stats"}, *command, t)
+}
+
+func TestParseCommandStatsGroup(t *testing.T) {
+	command, err := ParseCommand("stats items")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "stats", Key: "items"}, *command, t)
+}
+
+func TestParseCommandDelete(t *testing.T) {
+	command, err := ParseCommand("delete test noreply")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "delete", Key: "test", Noreply: true}, *command, t)
+}
+
+func TestParseCommandTouch(t *testing.T) {
+	command, err := ParseCommand("touch test 100")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "touch", Key: "test", ExpiresIn: 100}, *command, t)
+}
+
+func TestParseCommandGat(t *testing.T) {
+	command, err := ParseCommand("gats 100 key1 key2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Command{
+		Name:      "gats",
+		Keys:      []string{"key1", "key2"},
+		ExpiresIn: 100,
+	}
+
+	assertSame(expected, *command, t)
+}
+
+func TestParseCommandGatMissingKeys_Error(t *testing.T) {
+	_, err := ParseCommand("gat 100")
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestParseCommandFlushAll(t *testing.T) {
+	testCases := map[string]Command{
+		"flush_all":            {Name: "flush_all"},
+		"flush_all 10":         {Name: "flush_all", Delay: 10},
+		"flush_all noreply":    {Name: "flush_all", Noreply: true},
+		"flush_all 10 noreply": {Name: "flush_all", Delay: 10, Noreply: true},
+	}
+
+	for rawCommand, expected := range testCases {
+		command, err := ParseCommand(rawCommand)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertSame(expected, *command, t)
+	}
+}
+
+func TestParseCommandFlushAllInvalidDelay_Error(t *testing.T) {
+	_, err := ParseCommand("flush_all -1")
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: bad command line format")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
+func TestParseCommandSync(t *testing.T) {
+	command, err := ParseCommand("sync")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "sync"}, *command, t)
+}
+
+func TestParseCommandReplicaOf(t *testing.T) {
+	testCases := map[string]Command{
+		"replicaof localhost 11211": {Name: "replicaof", Address: "localhost:11211"},
+		"replicaof ::1 11211":       {Name: "replicaof", Address: "[::1]:11211"},
+		"replicaof no one":          {Name: "replicaof"},
+	}
+
+	for rawCommand, expected := range testCases {
+		command, err := ParseCommand(rawCommand)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertSame(expected, *command, t)
+	}
+}
+
+func TestParseCommandReplicaOfInvalidPort_Error(t *testing.T) {
+	_, err := ParseCommand("replicaof localhost abc")
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: port must be a number between 1 and 65535")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
 func TestNonNumericFlags_Error(t *testing.T) {
 	rawCommand := "set test x 100 4"
 	_, err := ParseCommand(rawCommand)
@@ -74,7 +333,7 @@ func TestNonNumericFlags_Error(t *testing.T) {
 		t.Fatal("Expected error")
 	}
 
-	expected := errors.New("error parsing command: `flags` must be a number")
+	expected := errors.New("error parsing command: bad command line format")
 	if err.Error() != expected.Error() {
 		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
 	}
@@ -88,7 +347,7 @@ func TestNonNumericExpTime_Error(t *testing.T) {
 		t.Fatal("Expected error")
 	}
 
-	expected := errors.New("error parsing command: `exptime` must be a number")
+	expected := errors.New("error parsing command: bad command line format")
 	if err.Error() != expected.Error() {
 		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
 	}
@@ -102,10 +361,222 @@ func TestNonNumericByteCount_Error(t *testing.T) {
 		t.Fatal("Expected error")
 	}
 
-	expected := errors.New("error parsing command: `byteCount` must be a number")
+	expected := errors.New("error parsing command: bad command line format")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
+func TestParseCommandMetaGet(t *testing.T) {
+	rawCommand := "mg test v t T30 Oabc"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name: "mg",
+		Key:  "test",
+		MetaFlags: []MetaFlag{
+			{Name: 'v'},
+			{Name: 't'},
+			{Name: 'T', Token: "30"},
+			{Name: 'O', Token: "abc"},
+		},
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandMetaSet(t *testing.T) {
+	rawCommand := "ms test 5 F3 MA q"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Command{
+		Name:      "ms",
+		Key:       "test",
+		ByteCount: 5,
+		Noreply:   true,
+		MetaFlags: []MetaFlag{
+			{Name: 'F', Token: "3"},
+			{Name: 'M', Token: "A"},
+			{Name: 'q'},
+		},
+	}
+
+	assertSame(*expected, *command, t)
+}
+
+func TestParseCommandMetaBase64Key(t *testing.T) {
+	rawCommand := "md dGVzdA== b"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command.Key != "test" {
+		t.Fatalf("Unexpected key: '%s'\n", command.Key)
+	}
+}
+
+func TestParseCommandMetaNoop(t *testing.T) {
+	rawCommand := "mn"
+	command, err := ParseCommand(rawCommand)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSame(Command{Name: "mn"}, *command, t)
+}
+
+func TestParseCommandMetaInvalidFlag_Error(t *testing.T) {
+	rawCommand := "md test v"
+	_, err := ParseCommand(rawCommand)
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: invalid flag")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
+func TestParseCommandMetaNonNumericToken_Error(t *testing.T) {
+	rawCommand := "mg test Tx"
+	_, err := ParseCommand(rawCommand)
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: bad token in command line format")
+	if err.Error() != expected.Error() {
+		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
+	}
+}
+
+func TestParseCommandMetaInvalidMode_Error(t *testing.T) {
+	rawCommand := "ms test 5 MX"
+	_, err := ParseCommand(rawCommand)
+
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := errors.New("error parsing command: invalid mode for ms STORE")
 	if err.Error() != expected.Error() {
 		t.Fatalf("Unexpected error. Expected: '%s', got: '%s'\n", expected.Error(), err.Error())
 	}
+
+	clientError := &ClientError{}
+	if !errors.As(err, &clientError) || clientError.DataBlockLength != 7 {
+		t.Fatalf("Expected the data block of 5 bytes and \"\\r\\n\" to be skipped. Got: %#v\n", err)
+	}
+}
+
+func TestParseCommandInvalidCommand_Error(t *testing.T) {
+	for _, rawCommand := range []string{
+		"",
+		"getfoo key",
+		"gets",
+		"GET key",
+		"set test 0 0",
+		"set test 0 0 5 noreply extra",
+		"delete",
+		"incr counter",
+		"stats items extra",
+		"sync now",
+		"mn extra",
+		"ms test",
+	} {
+		_, err := ParseCommand(rawCommand)
+
+		invalidCommandError := &InvalidCommandError{}
+		if !errors.As(err, &invalidCommandError) {
+			t.Fatalf("Unexpected error for '%s': %v\n", rawCommand, err)
+		}
+	}
+}
+
+func TestParseCommandClientError(t *testing.T) {
+	testCases := map[string]string{
+		"set " + strings.Repeat("a", 251) + " 0 0 5": "bad command line format",
+		"set te\x01st 0 0 5":                         "bad command line format",
+		"get key1 ke\tykey2":                         "bad command line format",
+		"set test 65536 0 5":                         "bad command line format",
+		"set test 0 0 -1":                            "bad command line format",
+		"set test 0 4294967296 5":                    "bad command line format",
+		"set test 0 0 5 norepl":                      "bad command line format",
+		"delete test 0":                              "bad command line format",
+		"touch test x":                               "invalid exptime argument",
+		"gat x test":                                 "invalid exptime argument",
+		"mg te$t b":                                  "error decoding key",
+		"mg test O" + strings.Repeat("a", 33):        "opaque token too long",
+		"mg " + strings.Repeat("a", 251) + " v":      "bad command line format",
+	}
+
+	for rawCommand, message := range testCases {
+		_, err := ParseCommand(rawCommand)
+
+		clientError := &ClientError{}
+		if !errors.As(err, &clientError) || clientError.Message != message {
+			t.Fatalf("Unexpected error for '%s': %v\n", rawCommand, err)
+		}
+	}
+}
+
+func TestParseCommandLongestKey(t *testing.T) {
+	key := strings.Repeat("a", 250)
+	command, err := ParseCommand("get " + key)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	assertSame(Command{Name: "get", Keys: []string{key}}, *command, t)
+}
+
+func TestParseCommandMultipleSpaces(t *testing.T) {
+	command, err := ParseCommand("set  test   1 100  4")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	assertSame(Command{Name: "set", Key: "test", Flags: 1, ExpiresIn: 100, ByteCount: 4}, *command, t)
+}
+
+func TestCommandParseReusesCommand(t *testing.T) {
+	var command Command
+
+	if err := command.Parse("mg test v T30"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if err := command.Parse("get key1 key2"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if command.Name != "get" || !reflect.DeepEqual(command.Keys, []string{"key1", "key2"}) || len(command.MetaFlags) != 0 {
+		t.Fatalf("Unexpected command: %+v\n", command)
+	}
+
+	if err := command.Parse("set test 1 100 4"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if command.Key != "test" || len(command.Keys) != 0 {
+		t.Fatalf("Unexpected command: %+v\n", command)
+	}
 }
 
 func assertSame(expected Command, actual Command, t *testing.T) {
@@ -0,0 +1,27 @@
+package utils
+
+import "fmt"
+
+// InvalidCommandError the command doesn't exist or has the wrong number of arguments. Same as memcached, the client is
+// sent `ERROR`
+type InvalidCommandError struct {
+	Name string
+}
+
+func (e *InvalidCommandError) Error() string {
+	return fmt.Sprintf("invalid command: '%s'", e.Name)
+}
+
+// ClientError the arguments of the command are malformed. Same as memcached, the client is sent
+// `CLIENT_ERROR <Message>`
+type ClientError struct {
+	Message string
+
+	// number of bytes following the command line that belong to the command, i.e., the data block of an `ms` command
+	// and its "\r\n". They have to be discarded so they aren't parsed as the next command
+	DataBlockLength int
+}
+
+func (e *ClientError) Error() string {
+	return "error parsing command: " + e.Message
+}
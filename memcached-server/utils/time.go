@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type TimeSource interface {
+	Now() time.Time
+	// After returns a channel that receives the current time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+type RealTimeSource struct{}
+
+func (receiver *RealTimeSource) Now() time.Time {
+	return time.Now()
+}
+
+func (receiver *RealTimeSource) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeTimeSource time source for tests. Time only moves when SetTime or Advance are called. It's safe for concurrent
+// use, since the cache reads it from the connection handlers and the cleanup task
+type FakeTimeSource struct {
+	FixedTime time.Time
+	mutex     sync.Mutex
+	timers    []fakeTimer
+	// Signaled when a timer is added. Created on first use so the zero value is ready to use
+	timerAdded *sync.Cond
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	channel  chan time.Time
+}
+
+func (f *FakeTimeSource) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.FixedTime
+}
+
+func (f *FakeTimeSource) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	channel := make(chan time.Time, 1)
+
+	if d <= 0 {
+		channel <- f.FixedTime
+		return channel
+	}
+
+	f.timers = append(f.timers, fakeTimer{deadline: f.FixedTime.Add(d), channel: channel})
+	f.cond().Broadcast()
+
+	return channel
+}
+
+func (f *FakeTimeSource) SetTime(t time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.FixedTime = t
+	f.fireTimers()
+}
+
+// Advance moves the time forward by d
+func (f *FakeTimeSource) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.FixedTime = f.FixedTime.Add(d)
+	f.fireTimers()
+}
+
+// BlockUntil blocks until at least n goroutines are waiting on channels returned by After. Useful to make sure a
+// background task is waiting before advancing the time
+func (f *FakeTimeSource) BlockUntil(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for len(f.timers) < n {
+		f.cond().Wait()
+	}
+}
+
+// fireTimers sends the current time to the timers whose deadline has passed. Assumes the caller holds the mutex
+func (f *FakeTimeSource) fireTimers() {
+	pending := f.timers[:0]
+
+	for _, timer := range f.timers {
+		if timer.deadline.After(f.FixedTime) {
+			pending = append(pending, timer)
+			continue
+		}
+
+		timer.channel <- f.FixedTime
+	}
+
+	f.timers = pending
+}
+
+// cond returns the condition variable signaled when timers are added. Assumes the caller holds the mutex
+func (f *FakeTimeSource) cond() *sync.Cond {
+	if f.timerAdded == nil {
+		f.timerAdded = sync.NewCond(&f.mutex)
+	}
+
+	return f.timerAdded
+}
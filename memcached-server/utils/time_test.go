@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTimeSourceAfter(t *testing.T) {
+	timeSource := &FakeTimeSource{FixedTime: time.Unix(0, 0)}
+	channel := timeSource.After(time.Second)
+
+	timeSource.Advance(time.Millisecond * 999)
+
+	select {
+	case <-channel:
+		t.Fatal("Timer should not fire before the deadline")
+	default:
+	}
+
+	timeSource.Advance(time.Millisecond)
+
+	select {
+	case fired := <-channel:
+		if !fired.Equal(time.Unix(1, 0)) {
+			t.Fatalf("Unexpected time. Expected %v, got %v\n", time.Unix(1, 0), fired)
+		}
+	default:
+		t.Fatal("Timer should have fired")
+	}
+}
+
+func TestFakeTimeSourceBlockUntil(t *testing.T) {
+	timeSource := &FakeTimeSource{}
+	done := make(chan bool)
+
+	go func() {
+		<-timeSource.After(time.Second)
+		done <- true
+	}()
+
+	timeSource.BlockUntil(1)
+	timeSource.Advance(time.Second)
+
+	<-done
+}